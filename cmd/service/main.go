@@ -4,13 +4,28 @@ package main
 import (
 	"context"
 	"embed"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/andygeiss/cloud-native-store/internal/app/adapters/inbound/api"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/file"
 	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/keys"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/lsm"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/postgres"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/redis"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/s3"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/spanner"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/txlog"
 	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
 	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
 	"github.com/andygeiss/cloud-native-utils/security"
 	"github.com/andygeiss/cloud-native-utils/service"
@@ -20,26 +35,155 @@ import (
 var efs embed.FS
 
 func main() {
-	// Create a configuration for an in-memory store.
+	integrityScan := flag.Bool("integrity-scan", false, "list the keys whose values are corrupt or fail to authenticate and exit")
+	issueAdminToken := flag.String("issue-admin-token", "", "issue an API token with the given name and the admin role for all keys and exit")
+	flag.Parse()
+
+	// Parse the retired encryption keys, which are only used to decrypt existing values.
+	retiredKeys, err := parseKeys(os.Getenv("ENCRYPTION_RETIRED_KEYS"))
+	if err != nil {
+		log.Fatalf("error during key parsing: %v", err)
+	}
+
+	// Create a configuration for the store selected by STORE_PORT.
 	cfg := &config.Config{
+		KeyProvider: config.KeyProvider{
+			KeyID: os.Getenv("ENCRYPTION_KMS_KEY_ID"),
+			Name:  os.Getenv("ENCRYPTION_KEY_PROVIDER"),
+			Path:  getenv("ENCRYPTION_KEY_FILE", "keys.json"),
+			Token: os.Getenv("ENCRYPTION_KMS_TOKEN"),
+			URL:   os.Getenv("ENCRYPTION_KMS_URL"),
+		},
+		PortFile: config.PortFile{
+			Path: getenv("STORE_FILE_PATH", "data/file"),
+		},
+		PortCloudSpanner: config.PortCloudSpanner{
+			DatabaseID:  os.Getenv("GCP_SPANNER_DATABASE_ID"),
+			InstanceID:  os.Getenv("GCP_SPANNER_INSTANCE_ID"),
+			MetadataURL: os.Getenv("GCP_METADATA_URL"),
+			ProjectID:   os.Getenv("GCP_PROJECT_ID"),
+			Table:       getenv("GCP_SPANNER_TABLE", spanner.DefaultTable),
+			Token:       os.Getenv("GCP_SPANNER_TOKEN"),
+			URL:         getenv("GCP_SPANNER_URL", spanner.DefaultURL),
+		},
+		PortInMemory: config.PortInMemory{
+			Shards: security.ParseInt("STORE_SHARDS", 2),
+		},
+		PortLSM: config.PortLSM{
+			MemtableSize: security.ParseInt("STORE_LSM_MEMTABLE_SIZE", 4<<20),
+			Path:         getenv("STORE_LSM_PATH", "data/lsm"),
+			Sync:         getenv("STORE_LSM_SYNC", "true") == "true",
+		},
+		PortPostgres: config.PortPostgres{
+			ConnMaxLifetime: security.ParseDuration("STORE_POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute),
+			MaxIdleConns:    security.ParseInt("STORE_POSTGRES_MAX_IDLE_CONNS", 5),
+			MaxOpenConns:    security.ParseInt("STORE_POSTGRES_MAX_OPEN_CONNS", 10),
+			SetupTimeout:    security.ParseDuration("STORE_POSTGRES_SETUP_TIMEOUT", time.Minute),
+			URL:             os.Getenv("STORE_POSTGRES_URL"),
+		},
+		PortRedis: config.PortRedis{
+			Address:  getenv("STORE_REDIS_ADDRESS", redis.DefaultAddress),
+			Database: security.ParseInt("STORE_REDIS_DATABASE", 0),
+			Password: os.Getenv("STORE_REDIS_PASSWORD"),
+			Prefix:   os.Getenv("STORE_REDIS_PREFIX"),
+			Username: os.Getenv("STORE_REDIS_USERNAME"),
+		},
+		PortS3: config.PortS3{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			Bucket:          os.Getenv("STORE_S3_BUCKET"),
+			Endpoint:        os.Getenv("STORE_S3_ENDPOINT"),
+			Prefix:          os.Getenv("STORE_S3_PREFIX"),
+			Region:          getenv("AWS_REGION", s3.DefaultRegion),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
 		Service: config.Service{
-			Key: security.Getenv("ENCRYPTION_KEY"),
+			Key:           security.Getenv("ENCRYPTION_KEY"),
+			KeyID:         os.Getenv("ENCRYPTION_KEY_ID"),
+			Port:          getenv("STORE_PORT", config.PortNameInMemory),
+			Reencrypt:     os.Getenv("ENCRYPTION_REENCRYPT") == "true",
+			RejectLegacy:  os.Getenv("ENCRYPTION_REJECT_LEGACY") == "true",
+			RetiredKeys:   retiredKeys,
+			SweepInterval: security.ParseDuration("STORE_SWEEP_INTERVAL", time.Minute),
+		},
+		Stability: config.Stability{
+			Read:  stabilityPolicy("STORE_READ_"),
+			Write: stabilityPolicy("STORE_WRITE_"),
 		},
 		Server: config.Server{
-			Efs:       efs,
-			Port:      os.Getenv("PORT"),
-			Templates: "assets/*.html",
+			ACLPath:      os.Getenv("SESSION_ACL_FILE"),
+			Efs:          efs,
+			MaxBodyBytes: int64(security.ParseInt("SERVER_MAX_BODY_BYTES", 1<<20)),
+			Port:         os.Getenv("PORT"),
+			Templates:    "assets/*.html",
+		},
+		TransactionLog: config.TransactionLog{
+			MaxSegmentSize:   int64(security.ParseInt("STORE_TRANSACTION_LOG_SEGMENT_SIZE", 64<<20)),
+			Path:             lookupenv("STORE_TRANSACTION_LOG", transactionLogPath(getenv("STORE_PORT", config.PortNameInMemory))),
+			Recover:          os.Getenv("STORE_TRANSACTION_LOG_RECOVER") == "true",
+			SnapshotInterval: security.ParseDuration("STORE_SNAPSHOT_INTERVAL", 5*time.Minute),
 		},
 	}
 
-	// Create a new in-memory adapter.
-	objectPort := inmemory.NewObjectStore(security.ParseInt("STORE_SHARDS", 2))
+	// Create a new outbound adapter.
+	objectPort, err := newObjectPort(cfg)
+	if err != nil {
+		log.Fatalf("error during port creation: %v", err)
+	}
+	// Close the port after the teardown of the service, e.g. to flush the embedded storage engine.
+	defer closePort(objectPort)
 
 	// Create a new Object Service.
 	svc := services.
 		NewObjectService(cfg).
 		WithPort(objectPort)
 
+	// Open the transaction log which is replayed during setup, if it is enabled.
+	var txLogger *txlog.FileLogger
+	if cfg.TransactionLog.Path != "" {
+		txLogger, err = txlog.NewFileLogger(cfg.TransactionLog.Path)
+		if err != nil {
+			log.Fatalf("error during transaction log creation: %v", err)
+		}
+		txLogger = txLogger.
+			WithMaxSegmentSize(cfg.TransactionLog.MaxSegmentSize).
+			WithRecovery(cfg.TransactionLog.Recover)
+		svc = svc.WithTransactionalLogger(txLogger)
+	}
+
+	// Enable envelope encryption, if a key provider is selected.
+	if cfg.KeyProvider.Name != "" {
+		keyProvider, err := newKeyProvider(cfg)
+		if err != nil {
+			log.Fatalf("error during key provider creation: %v", err)
+		}
+		svc = svc.WithKeyProvider(keyProvider)
+	}
+
+	// Create a new Token Service, which stores the API tokens in the Object Service
+	// and authorizes the UI sessions by the ACL, if it is configured.
+	tokens := services.NewTokenService(svc)
+	if cfg.Server.ACLPath != "" {
+		acl, err := newACL(cfg)
+		if err != nil {
+			log.Fatalf("error during ACL creation: %v", err)
+		}
+		tokens = tokens.WithACL(acl)
+	}
+
+	// Scan the values or issue a token instead of serving requests, if requested.
+	// The transaction log must not be used by a running service at the same time.
+	if *integrityScan {
+		code := runIntegrityScan(svc)
+		closePort(objectPort)
+		os.Exit(code)
+	}
+	if *issueAdminToken != "" {
+		code := runIssueAdminToken(svc, tokens, *issueAdminToken)
+		closePort(objectPort)
+		os.Exit(code)
+	}
+
 	// Create a new context with a cancel function.
 	ctx, cancel := service.Context()
 	defer cancel()
@@ -48,10 +192,15 @@ func main() {
 	if err := svc.Setup(); err != nil {
 		log.Fatalf("error during setup: %v", err)
 	}
+	if txLogger != nil {
+		if skipped := txLogger.Skipped(); len(skipped) > 0 {
+			log.Printf("recovered transaction log by skipping %d corrupt records", len(skipped))
+		}
+	}
 	defer svc.Teardown()
 
 	// Initialize the API router using the configuration object.
-	mux := api.Route(svc, ctx, cfg)
+	mux := api.Route(svc, tokens, ctx, cfg)
 
 	// Create a new secure server.
 	srv := security.NewServer(mux)
@@ -82,3 +231,164 @@ func main() {
 		log.Fatalf("listening failed: %v", err)
 	}
 }
+
+// closePort closes the port, if it holds resources like files or connections.
+func closePort(port ports.ObjectPort[string, string]) {
+	closer, ok := port.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Printf("error during port close: %v", err)
+	}
+}
+
+// getenv returns the value of the environment variable or the fallback if it is empty.
+func getenv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// lookupenv returns the value of the environment variable or the fallback if it is not set.
+// Unlike getenv, an empty value is kept, e.g. to disable a feature.
+func lookupenv(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// transactionLogPath returns the default path of the transaction log for the port,
+// which has its own directory like the other ports.
+// Only the in-memory port needs the log. The other ports keep the values by themselves,
+// thus replaying a log would rewrite them at every start, and a shared database would
+// even be overwritten with the old local history of each instance.
+func transactionLogPath(port string) string {
+	if port != config.PortNameInMemory {
+		return ""
+	}
+	return "data/txlog/transactions.log"
+}
+
+// stabilityPolicy returns the stability policy of the environment variables with the prefix,
+// which fall back to the variables shared by reads and writes, e.g. STORE_READ_TIMEOUT to STORE_TIMEOUT.
+func stabilityPolicy(prefix string) config.StabilityPolicy {
+	return config.StabilityPolicy{
+		BreakerThreshold: security.ParseInt(prefix+"BREAKER_THRESHOLD", security.ParseInt("STORE_BREAKER_THRESHOLD", 3)),
+		RetryDelay:       security.ParseDuration(prefix+"RETRY_DELAY", security.ParseDuration("STORE_RETRY_DELAY", 5*time.Second)),
+		RetryMax:         security.ParseInt(prefix+"RETRY_MAX", security.ParseInt("STORE_RETRY_MAX", 3)),
+		Timeout:          security.ParseDuration(prefix+"TIMEOUT", security.ParseDuration("STORE_TIMEOUT", 5*time.Second)),
+	}
+}
+
+// runIntegrityScan replays the transaction log and prints the namespaces and keys whose values cannot be read.
+// It returns the exit code, which is 1 if any value failed or the scan could not be completed.
+func runIntegrityScan(svc *services.ObjectService) int {
+	if err := svc.Replay(); err != nil {
+		log.Printf("error during replay: %v", err)
+		return 1
+	}
+	defer svc.Teardown()
+
+	failures, err := svc.Verify(context.Background())
+	for _, failure := range failures {
+		fmt.Printf("%s\t%s\t%v\n", failure.Namespace, failure.Key, failure.Err)
+	}
+	if err != nil {
+		log.Printf("error during integrity scan: %v", err)
+		return 1
+	}
+	log.Printf("integrity scan found %d failed values", len(failures))
+	if len(failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runIssueAdminToken replays the transaction log, issues an admin token with the name and prints its secret.
+// It returns the exit code, which is 1 if the token could not be issued.
+func runIssueAdminToken(svc *services.ObjectService, tokens *services.TokenService, name string) int {
+	if err := svc.Replay(); err != nil {
+		log.Printf("error during replay: %v", err)
+		return 1
+	}
+	defer svc.Teardown()
+
+	token, secret, err := tokens.IssueAdmin(context.Background(), name)
+	if err != nil {
+		log.Printf("error during token issue: %v", err)
+		return 1
+	}
+	log.Printf("issued admin token %q with ID %s", token.Name, token.ID)
+	fmt.Println(secret)
+	return 0
+}
+
+// parseKeys parses a comma-separated list of encryption keys in the form "id:hex".
+// An empty ID refers to the key of the values which were written before key IDs were introduced.
+func parseKeys(value string) (map[string][32]byte, error) {
+	keys := make(map[string][32]byte)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		data, err := hex.DecodeString(encoded)
+		if !found || err != nil || len(data) != 32 {
+			return nil, fmt.Errorf("invalid key %q", id)
+		}
+		var key [32]byte
+		copy(key[:], data)
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// newACL reads the ACL, which maps the GitHub logins of the UI sessions to grants, from the configured file.
+func newACL(cfg *config.Config) (services.ACL, error) {
+	data, err := os.ReadFile(cfg.Server.ACLPath)
+	if err != nil {
+		return services.ACL{}, err
+	}
+	return services.ParseACL(data)
+}
+
+// newKeyProvider creates the key provider selected by the configuration.
+func newKeyProvider(cfg *config.Config) (ports.KeyProvider, error) {
+	switch cfg.KeyProvider.Name {
+	case config.KeyProviderNameFile:
+		return keys.NewFileProvider(cfg.KeyProvider.Path)
+	case config.KeyProviderNameKMS:
+		return keys.NewKMSProvider(cfg.KeyProvider.URL, cfg.KeyProvider.KeyID).
+			WithToken(cfg.KeyProvider.Token), nil
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", cfg.KeyProvider.Name)
+	}
+}
+
+// newObjectPort creates the outbound adapter selected by the configuration.
+func newObjectPort(cfg *config.Config) (ports.ObjectPort[string, string], error) {
+	switch cfg.Service.Port {
+	case config.PortNameFile:
+		return file.NewObjectStore(cfg.PortFile.Path)
+	case config.PortNameInMemory:
+		return inmemory.NewObjectStore(cfg.PortInMemory.Shards), nil
+	case config.PortNameLSM:
+		return lsm.NewObjectStore(cfg.PortLSM)
+	case config.PortNamePostgres:
+		// An unreachable database must not block the start of the service forever.
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.PortPostgres.SetupTimeout)
+		defer cancel()
+		return postgres.NewObjectStore(ctx, cfg.PortPostgres)
+	case config.PortNameRedis:
+		return redis.NewObjectStore(cfg.PortRedis), nil
+	case config.PortNameS3:
+		return s3.NewObjectStore(cfg.PortS3), nil
+	case config.PortNameSpanner:
+		return spanner.NewObjectStore(cfg.PortCloudSpanner), nil
+	default:
+		return nil, fmt.Errorf("unknown port %q", cfg.Service.Port)
+	}
+}
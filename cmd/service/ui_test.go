@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/inbound/api"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/assert"
+	"github.com/andygeiss/cloud-native-utils/security"
+	"github.com/andygeiss/cloud-native-utils/templating"
+)
+
+// sessions is a stand-in for the server sessions of the GitHub login.
+type sessions map[string]security.ServerSession
+
+func (a sessions) Get(id string) (security.ServerSession, bool) {
+	session, found := a[id]
+	return session, found
+}
+
+// newUIServer creates a test server with the UI endpoints, which renders the embedded templates.
+// The session "admin" may write all keys and the session "reader" may only read the keys with the prefix "users/".
+func newUIServer(t *testing.T) *httptest.Server {
+	acl, err := services.ParseACL([]byte(`{"rules": [
+		{"subjects": ["alice"], "grants": [{"namespace": "*", "prefix": "", "role": "write"}]},
+		{"subjects": ["bob"], "grants": [{"prefix": "users/", "role": "read"}]}
+	]}`))
+	assert.That(t, "err must be nil", err, nil)
+
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	tokens := services.NewTokenService(svc).WithACL(acl)
+	engine := templating.NewEngine(efs)
+	engine.Parse("assets/*.html")
+
+	mux := http.NewServeMux()
+	api.RouteUI(mux, engine, svc, tokens, sessions{
+		"admin":  {ID: "admin", Login: "alice", Name: "Alice"},
+		"reader": {ID: "reader", Login: "bob", Name: "Bob"},
+		"guest":  {ID: "guest", Login: "eve", Name: "bob"},
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// send sends a request with the form values and returns the response and its body.
+func send(t *testing.T, method, url string, form url.Values) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(form.Encode()))
+	assert.That(t, "err must be nil", err, nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req)
+	assert.That(t, "err must be nil", err, nil)
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+// versionPattern matches the version of the form of the value panel.
+var versionPattern = regexp.MustCompile(`name="version" type="hidden" value="(\d+)"`)
+
+// expiresAtPattern matches the expiry of the form of the value panel.
+var expiresAtPattern = regexp.MustCompile(`name="expires_at" type="hidden" value="(\d+)"`)
+
+// version returns the version of the form of the value panel.
+func version(body string) string {
+	match := versionPattern.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func TestUI_Store_Is_Gated_On_Session(t *testing.T) {
+	srv := newUIServer(t)
+
+	_, body := send(t, http.MethodGet, srv.URL+"/ui/store", nil)
+	assert.That(t, "store without session must show the login", strings.Contains(body, "/auth/login"), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store?s=guest", nil)
+	assert.That(t, "store of user without access must show an error", strings.Contains(body, `class="flash error"`), true)
+	assert.That(t, "store of user without access must not show the keys", strings.Contains(body, `id="keys"`), false)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store?s=admin", nil)
+	assert.That(t, "store must show the keys", strings.Contains(body, `id="keys"`), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/keys?s=unknown", nil)
+	assert.That(t, "keys without session must show an error", strings.Contains(body, "Please log in"), true)
+}
+
+func TestUI_Create_Edit_Delete(t *testing.T) {
+	srv := newUIServer(t)
+
+	_, body := send(t, http.MethodGet, srv.URL+"/ui/store/value?s=admin", nil)
+	assert.That(t, "new form must have a key input", strings.Contains(body, `<input name="key" required`), true)
+
+	res, body := send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"users/1"}, "value": {"<alice>"}})
+	assert.That(t, "save must trigger the key list", res.Header.Get("HX-Trigger"), "keys-changed")
+	assert.That(t, "save must show a message", strings.Contains(body, "Saved users/1."), true)
+	first := version(body)
+	assert.That(t, "save must show the version", first != "" && first != "0", true)
+
+	res, body = send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"users/1"}, "value": {"bob"}})
+	assert.That(t, "create of an existing key must not trigger the key list", res.Header.Get("HX-Trigger"), "")
+	assert.That(t, "create of an existing key must show an error", strings.Contains(body, `class="flash error"`), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/value?s=admin&key=users/1", nil)
+	assert.That(t, "value must be escaped", strings.Contains(body, "&lt;alice&gt;"), true)
+	assert.That(t, "value must have the version", version(body), first)
+
+	_, body = send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"users/1"}, "value": {"bob"}, "version": {first}})
+	second := version(body)
+	assert.That(t, "update must show a message", strings.Contains(body, "Saved users/1."), true)
+	assert.That(t, "update must show a new version", second != first, true)
+
+	_, body = send(t, http.MethodDelete, srv.URL+"/ui/store/value?s=admin&key=users/1&version="+first, nil)
+	assert.That(t, "delete of an old version must show an error", strings.Contains(body, "Error: version mismatch."), true)
+
+	res, body = send(t, http.MethodDelete, srv.URL+"/ui/store/value?s=admin&key=users/1&version="+second, nil)
+	assert.That(t, "delete must trigger the key list", res.Header.Get("HX-Trigger"), "keys-changed")
+	assert.That(t, "delete must show a message", strings.Contains(body, "Deleted users/1."), true)
+}
+
+func TestUI_Save_Keeps_Expiry(t *testing.T) {
+	srv := newUIServer(t)
+
+	_, body := send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"session/1"}, "value": {"a"}, "ttl": {"3600"}})
+	assert.That(t, "save must show the expiry", strings.Contains(body, "expires at"), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/value?s=admin&key=session/1", nil)
+	match := expiresAtPattern.FindStringSubmatch(body)
+	assert.That(t, "form must have the expiry", match != nil, true)
+	expiresAt := match[1]
+
+	// An empty ttl keeps the expiry of the form.
+	_, body = send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"session/1"}, "value": {"b"},
+		"version": {version(body)}, "ttl": {""}, "expires_at": {expiresAt}})
+	assert.That(t, "update must show a message", strings.Contains(body, "Saved session/1."), true)
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/value?s=admin&key=session/1", nil)
+	assert.That(t, "update must keep the expiry", expiresAtPattern.MatchString(body), true)
+
+	_, body = send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {"session/1"}, "value": {"c"},
+		"version": {version(body)}, "ttl": {""}, "expires_at": {expiresAt}, "persist": {"true"}})
+	assert.That(t, "update must show a message", strings.Contains(body, "Saved session/1."), true)
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/value?s=admin&key=session/1", nil)
+	assert.That(t, "never expires must remove the expiry", strings.Contains(body, "expires at"), false)
+	assert.That(t, "form must not have an expiry", expiresAtPattern.MatchString(body), false)
+}
+
+func TestUI_Keys_Search_And_Pages(t *testing.T) {
+	srv := newUIServer(t)
+	for _, key := range []string{"orders/1", "users/01", "users/02", "users/03", "users/04", "users/05", "users/06", "users/07",
+		"users/08", "users/09", "users/10", "users/11", "users/12", "users/13", "users/14", "users/15", "users/16",
+		"users/17", "users/18", "users/19", "users/20", "users/21"} {
+		send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"admin"}, "key": {key}, "value": {"x"}})
+	}
+
+	_, body := send(t, http.MethodGet, srv.URL+"/ui/store/keys?s=reader&prefix=users/", nil)
+	assert.That(t, "first page must have 20 keys", strings.Count(body, "<li>"), 20)
+	assert.That(t, "first page must not contain other prefixes", strings.Contains(body, "orders/1"), false)
+	assert.That(t, "first page must link the next page", strings.Contains(body, "after=users%2F20"), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/keys?s=reader&prefix=users/&after=users/20", nil)
+	assert.That(t, "second page must have 1 key", strings.Count(body, "<li>"), 1)
+	assert.That(t, "second page must link the first page", strings.Contains(body, "First page"), true)
+
+	_, body = send(t, http.MethodGet, srv.URL+"/ui/store/keys?s=reader", nil)
+	assert.That(t, "keys of other prefixes must be forbidden", strings.Contains(body, "Error: forbidden."), true)
+
+	_, body = send(t, http.MethodPut, srv.URL+"/ui/store/value", url.Values{"s": {"reader"}, "key": {"users/01"}, "value": {"y"}})
+	assert.That(t, "write of reader must be forbidden", strings.Contains(body, "Error: forbidden."), true)
+}
@@ -2,7 +2,10 @@ module github.com/andygeiss/cloud-native-store
 
 go 1.23.2
 
-require github.com/andygeiss/cloud-native-utils v0.1.44
+require (
+	github.com/andygeiss/cloud-native-utils v0.1.44
+	github.com/lib/pq v1.10.9
+)
 
 require (
 	golang.org/x/crypto v0.31.0 // indirect
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/security"
+)
+
+// Sessions returns the server session of the ID, which knows the GitHub user of the UI.
+// It is implemented by security.ServerSessions. The user is authorized by the login of the session,
+// because its name is the display name, which is neither unique nor fixed.
+type Sessions interface {
+	Get(id string) (security.ServerSession, bool)
+}
+
+// tokenContextKey is the key of the authenticated token in the context of a request.
+type tokenContextKey struct{}
+
+// Authenticate defines an HTTP middleware which requires either a valid API token as the bearer token
+// of the Authorization header or the ID of a server session as "Session <id>", which is used by the UI.
+// The token of the request or the token with the grants of the session user is added to the context
+// of the request, so that the handlers are able to check its grants for the keys of the request.
+func Authenticate(tokens *services.TokenService, sessions Sessions, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := authenticate(tokens, sessions, r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, "tokens.Authenticate", err)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+	}
+}
+
+// IssueToken defines an HTTP handler function for issuing an API token.
+// It expects a JSON request body with the fields "name" and "grants", whose entries have the
+// fields "namespace", "prefix" and "role" ("read", "write" or "admin"). The token of the request
+// must have the admin role for all grants. The secret of the new token is only returned once.
+func IssueToken(tokens *services.TokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Grants []services.Grant `json:"grants"`
+			Name   string           `json:"name"`
+		}
+		var res struct {
+			services.Token
+			Secret string `json:"secret"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "", bodyError(err))
+			return
+		}
+
+		token, secret, err := tokens.Issue(r.Context(), issuer(r), req.Name, req.Grants)
+		if err != nil {
+			writeError(w, "tokens.Issue", err)
+			return
+		}
+
+		res.Token = token
+		res.Secret = secret
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// ListTokens defines an HTTP handler function for listing the API tokens,
+// which are managed by the token of the request. The secrets are not returned.
+func ListTokens(tokens *services.TokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var res struct {
+			Tokens []services.Token `json:"tokens"`
+		}
+
+		list, err := tokens.List(r.Context(), issuer(r))
+		if err != nil {
+			writeError(w, "tokens.List", err)
+			return
+		}
+
+		res.Tokens = list
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// RevokeToken defines an HTTP handler function for revoking the API token with the ID in the path.
+// The token of the request must have the admin role for all grants of the revoked token.
+func RevokeToken(tokens *services.TokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := tokens.Revoke(r.Context(), issuer(r), r.PathValue("id")); err != nil {
+			writeError(w, "tokens.Revoke", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authenticate returns the token of the API token or the server session in the Authorization header.
+func authenticate(tokens *services.TokenService, sessions Sessions, r *http.Request) (token services.Token, err error) {
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return tokens.Authenticate(r.Context(), credentials)
+	case strings.EqualFold(scheme, "Session") && sessions != nil:
+		session, found := sessions.Get(credentials)
+		if !found || credentials == "" {
+			return token, services.ErrorUnauthorized
+		}
+		return tokens.AuthenticateSession(session.Login)
+	default:
+		return token, services.ErrorUnauthorized
+	}
+}
+
+// authorize checks that the token of the request grants the role for the key in the namespace.
+func authorize(r *http.Request, namespace, key string, role services.Role) error {
+	if !issuer(r).Allows(namespace, key, role) {
+		return services.ErrorForbidden
+	}
+	return nil
+}
+
+// issuer returns the token of the request, which has been added by Authenticate.
+// A request without a token gets a token without any grants.
+func issuer(r *http.Request) services.Token {
+	token, _ := r.Context().Value(tokenContextKey{}).(services.Token)
+	return token
+}
@@ -0,0 +1,199 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/assert"
+	"github.com/andygeiss/cloud-native-utils/security"
+)
+
+// sessions is a stand-in for the server sessions of the GitHub login.
+type sessions map[string]security.ServerSession
+
+func (a sessions) Get(id string) (security.ServerSession, bool) {
+	session, found := a[id]
+	return session, found
+}
+
+// asSession sends a request with the ID of the session and returns the response and its body.
+func asSession(t *testing.T, id, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.That(t, "err must be nil", err, nil)
+	req.Header.Set("Authorization", "Session "+id)
+	res, err := http.DefaultClient.Do(req)
+	assert.That(t, "err must be nil", err, nil)
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+// as sends a request with the bearer token and returns the response and its body.
+func as(t *testing.T, token, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.That(t, "err must be nil", err, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.That(t, "err must be nil", err, nil)
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+// issue issues a token with the grants using the admin token of the server and returns its ID and secret.
+func issue(t *testing.T, url, grants string) (id, secret string) {
+	res, body := do(t, http.MethodPost, url+"/api/v1/tokens", `{"name":"test","grants":`+grants+`}`)
+	assert.That(t, "issue status must be 201", res.StatusCode, http.StatusCreated)
+	var token struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	_ = json.Unmarshal([]byte(body), &token)
+	return token.ID, token.Secret
+}
+
+func TestAuth_Requires_Token(t *testing.T) {
+	srv := newServer(t)
+
+	res, _ := as(t, "", http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "status must be 401", res.StatusCode, http.StatusUnauthorized)
+	assert.That(t, "WWW-Authenticate must be Bearer", res.Header.Get("WWW-Authenticate"), "Bearer")
+
+	res, _ = as(t, "cns_0000000000000000_invalid", http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "unknown token status must be 401", res.StatusCode, http.StatusUnauthorized)
+
+	res, _ = as(t, "invalid", http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "malformed token status must be 401", res.StatusCode, http.StatusUnauthorized)
+}
+
+func TestAuth_Roles_Per_Prefix(t *testing.T) {
+	srv := newServer(t)
+	do(t, http.MethodPut, srv.URL+"/api/v1/store/users/1", "alice")
+	do(t, http.MethodPut, srv.URL+"/api/v1/store/orders/1", "book")
+	_, reader := issue(t, srv.URL, `[{"prefix":"users/","role":"read"}]`)
+	_, writer := issue(t, srv.URL, `[{"prefix":"users/","role":"write"}]`)
+
+	res, body := as(t, reader, http.MethodGet, srv.URL+"/api/v1/store/users/1", "")
+	assert.That(t, "read status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "body must be the value", body, "alice")
+
+	res, _ = as(t, reader, http.MethodPut, srv.URL+"/api/v1/store/users/1", "bob")
+	assert.That(t, "write with read role status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, reader, http.MethodGet, srv.URL+"/api/v1/store/orders/1", "")
+	assert.That(t, "read of other prefix status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, reader, http.MethodGet, srv.URL+"/api/v1/store/keys?prefix=users/", "")
+	assert.That(t, "list of the prefix status must be 200", res.StatusCode, http.StatusOK)
+
+	res, _ = as(t, reader, http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "list of all keys status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, writer, http.MethodPut, srv.URL+"/api/v1/store/users/1", "bob")
+	assert.That(t, "write status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, _ = as(t, writer, http.MethodPut, srv.URL+"/api/v1/store", `{"key":"orders/1","value":"pen"}`)
+	assert.That(t, "write of other prefix status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, writer, http.MethodPost, srv.URL+"/api/v1/store/batch", `{"operations":[{"type":"put","key":"users/2","value":"x"},{"type":"delete","key":"orders/1"}]}`)
+	assert.That(t, "batch with other prefix status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, writer, http.MethodPost, srv.URL+"/api/v1/tokens", `{"name":"x","grants":[{"prefix":"users/","role":"read"}]}`)
+	assert.That(t, "issue without admin role status must be 403", res.StatusCode, http.StatusForbidden)
+}
+
+func TestAuth_Namespaces(t *testing.T) {
+	srv := newServer(t)
+	_, token := issue(t, srv.URL, `[{"namespace":"team-a","role":"write"}]`)
+
+	res, _ := as(t, token, http.MethodPut, srv.URL+"/api/v1/ns/team-a/store/foo", "bar")
+	assert.That(t, "write to own namespace status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, _ = as(t, token, http.MethodPut, srv.URL+"/api/v1/ns/team-b/store/foo", "bar")
+	assert.That(t, "write to other namespace status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, token, http.MethodPut, srv.URL+"/api/v1/store/foo", "bar")
+	assert.That(t, "write to default namespace status must be 403", res.StatusCode, http.StatusForbidden)
+}
+
+func TestAuth_Manage_Tokens(t *testing.T) {
+	srv := newServer(t)
+	_, admin := issue(t, srv.URL, `[{"namespace":"team-a","role":"admin"}]`)
+
+	// The admin of a namespace is only allowed to issue tokens for it.
+	res, body := as(t, admin, http.MethodPost, srv.URL+"/api/v1/tokens", `{"name":"reader","grants":[{"namespace":"team-a","prefix":"users/","role":"read"}]}`)
+	assert.That(t, "issue status must be 201", res.StatusCode, http.StatusCreated)
+	var token struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	_ = json.Unmarshal([]byte(body), &token)
+	assert.That(t, "secret must be returned", strings.HasPrefix(token.Secret, "cns_"+token.ID+"_"), true)
+	assert.That(t, "hash must not be returned", strings.Contains(body, "hash"), false)
+
+	res, _ = as(t, admin, http.MethodPost, srv.URL+"/api/v1/tokens", `{"name":"other","grants":[{"namespace":"team-b","role":"read"}]}`)
+	assert.That(t, "issue for other namespace status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = as(t, admin, http.MethodPost, srv.URL+"/api/v1/tokens", `{"name":"other","grants":[{"namespace":"team-a","role":"owner"}]}`)
+	assert.That(t, "issue with unknown role status must be 400", res.StatusCode, http.StatusBadRequest)
+
+	// The admin of a namespace only lists the tokens which it manages.
+	_, body = as(t, admin, http.MethodGet, srv.URL+"/api/v1/tokens", "")
+	var list struct {
+		Tokens []struct {
+			Name string `json:"name"`
+		} `json:"tokens"`
+	}
+	_ = json.Unmarshal([]byte(body), &list)
+	assert.That(t, "list must contain 2 tokens", len(list.Tokens), 2)
+
+	res, _ = as(t, admin, http.MethodDelete, srv.URL+"/api/v1/tokens/"+token.ID, "")
+	assert.That(t, "revoke status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, _ = as(t, token.Secret, http.MethodGet, srv.URL+"/api/v1/ns/team-a/store/keys?prefix=users/", "")
+	assert.That(t, "revoked token status must be 401", res.StatusCode, http.StatusUnauthorized)
+}
+
+func TestAuth_Sessions(t *testing.T) {
+	acl, err := services.ParseACL([]byte(`{
+		"rules": [
+			{"subjects": ["alice"], "grants": [{"namespace": "*", "prefix": "", "role": "admin"}]},
+			{"subjects": ["team:acme/ops"], "grants": [{"prefix": "users/", "role": "read"}]}
+		],
+		"teams": {"acme/ops": ["bob"]}
+	}`))
+	assert.That(t, "err must be nil", err, nil)
+	srv := newServerWithSessions(t, inmemory.NewObjectStore(2), acl, sessions{
+		"s1": {ID: "s1", Login: "alice", Name: "Alice"},
+		"s2": {ID: "s2", Login: "Bob", Name: "Bob"},
+		"s3": {ID: "s3", Login: "eve", Name: "Eve"},
+		"s4": {ID: "s4", Login: "mallory", Name: "alice"},
+	})
+
+	res, _ := asSession(t, "s1", http.MethodPut, srv.URL+"/api/v1/store/users/1", "alice")
+	assert.That(t, "write of alice status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, body := asSession(t, "s2", http.MethodGet, srv.URL+"/api/v1/store/users/1", "")
+	assert.That(t, "read of team member status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "body must be the value", body, "alice")
+
+	res, _ = asSession(t, "s2", http.MethodPut, srv.URL+"/api/v1/store/users/1", "bob")
+	assert.That(t, "write of team member status must be 403", res.StatusCode, http.StatusForbidden)
+
+	res, _ = asSession(t, "s3", http.MethodGet, srv.URL+"/api/v1/store/users/1", "")
+	assert.That(t, "user without rule status must be 401", res.StatusCode, http.StatusUnauthorized)
+
+	res, _ = asSession(t, "s4", http.MethodGet, srv.URL+"/api/v1/store/users/1", "")
+	assert.That(t, "user with the name of an allowed login status must be 401", res.StatusCode, http.StatusUnauthorized)
+
+	res, _ = asSession(t, "unknown", http.MethodGet, srv.URL+"/api/v1/store/users/1", "")
+	assert.That(t, "unknown session status must be 401", res.StatusCode, http.StatusUnauthorized)
+
+	res, _ = asSession(t, "s1", http.MethodPost, srv.URL+"/api/v1/tokens", `{"name":"ci","grants":[{"prefix":"users/","role":"read"}]}`)
+	assert.That(t, "issue by admin session status must be 201", res.StatusCode, http.StatusCreated)
+}
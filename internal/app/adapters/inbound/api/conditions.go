@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+)
+
+var (
+	// errInvalidPrecondition is returned when a conditional request header cannot be parsed.
+	errInvalidPrecondition = errors.New("invalid precondition")
+	// errInvalidTTL is returned when the X-TTL header is not a number of seconds.
+	errInvalidTTL = errors.New("invalid ttl")
+)
+
+// condition returns the condition of a write from the If-Match and If-None-Match headers.
+func condition(r *http.Request) (cond services.Condition, err error) {
+	return parseCondition(r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+}
+
+// parseCondition returns the condition of a write from the values of the If-Match and If-None-Match headers.
+// Only a single strong ETag or "*" is supported in If-Match and only "*" in If-None-Match.
+func parseCondition(match, noneMatch string) (cond services.Condition, err error) {
+	if match == "*" {
+		cond.Present = true
+	} else if match != "" {
+		unquoted, ok := strings.CutPrefix(match, `"`)
+		unquoted, found := strings.CutSuffix(unquoted, `"`)
+		if !ok || !found {
+			return cond, errInvalidPrecondition
+		}
+		if cond.Version, err = strconv.ParseUint(unquoted, 10, 64); err != nil || cond.Version == 0 {
+			return cond, errInvalidPrecondition
+		}
+	}
+
+	switch noneMatch {
+	case "":
+	case "*":
+		cond.Absent = true
+	default:
+		return cond, errInvalidPrecondition
+	}
+
+	return cond, nil
+}
+
+// etag returns the entity tag of the version of an object.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// timeToLive returns the duration in the X-TTL header, which is given in seconds.
+// It returns zero if the header is missing.
+func timeToLive(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get("X-TTL")
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, errInvalidTTL
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+)
+
+var (
+	// errInvalidRequest is returned when the body or the query of a request cannot be parsed.
+	errInvalidRequest = errors.New("invalid request")
+	// errRequestTooLarge is returned when the body of a request exceeds the configured limit.
+	errRequestTooLarge = errors.New("request body too large")
+	// errServiceUnavailable is the message of the errors, which are answered with 503 without their details.
+	errServiceUnavailable = errors.New("service unavailable")
+)
+
+// errorStatus maps the errors of the requests and the service to HTTP status codes.
+// The first matching error determines the status code.
+var errorStatus = []struct {
+	err    error
+	status int
+}{
+	{errInvalidPrecondition, http.StatusBadRequest},
+	{errInvalidRequest, http.StatusBadRequest},
+	{errInvalidTTL, http.StatusBadRequest},
+	{services.ErrorInvalidBatch, http.StatusBadRequest},
+	{services.ErrorInvalidKey, http.StatusBadRequest},
+	{services.ErrorInvalidGrant, http.StatusBadRequest},
+	{services.ErrorInvalidNamespace, http.StatusBadRequest},
+	{services.ErrorUnauthorized, http.StatusUnauthorized},
+	{services.ErrorForbidden, http.StatusForbidden},
+	{errRequestTooLarge, http.StatusRequestEntityTooLarge},
+	{services.ErrKeyNotFound, http.StatusNotFound},
+	{services.ErrorVersionMismatch, http.StatusPreconditionFailed},
+	{services.ErrCorruptValue, http.StatusUnprocessableEntity},
+	{services.ErrDecryptionFailed, http.StatusInternalServerError},
+	{services.ErrorBatchNotSupported, http.StatusNotImplemented},
+}
+
+// writeError responds with the status code of the error and a JSON body with its message.
+// Errors which are not caused by the request or the stored data, like a failing port,
+// are answered with 503 and logged together with the operation. Their message is not sent to
+// the client, because it may contain internal details of the port, like the address of a database.
+func writeError(w http.ResponseWriter, operation string, err error) {
+	var res struct {
+		Error string `json:"error"`
+	}
+
+	status := statusOf(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s error: %v", operation, err)
+	}
+
+	res.Error = err.Error()
+	if status == http.StatusServiceUnavailable {
+		res.Error = errServiceUnavailable.Error()
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// bodyError returns errRequestTooLarge, if the body of the request exceeded its limit while it was read,
+// and errInvalidRequest otherwise.
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errRequestTooLarge
+	}
+	return errInvalidRequest
+}
+
+// statusOf returns the status code of the first matching error or 503 for any other error.
+func statusOf(err error) int {
+	for _, entry := range errorStatus {
+		if errors.Is(err, entry.err) {
+			return entry.status
+		}
+	}
+	return http.StatusServiceUnavailable
+}
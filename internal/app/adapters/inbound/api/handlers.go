@@ -2,16 +2,103 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
 	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
 	"github.com/andygeiss/cloud-native-utils/security"
 	"github.com/andygeiss/cloud-native-utils/templating"
 )
 
+const (
+	// contentTypeJSON is the content type of the JSON endpoints.
+	contentTypeJSON = "application/json"
+	// contentTypeValue is the content type of raw values, which are stored without a media type.
+	contentTypeValue = "application/octet-stream"
+	// defaultPageLimit is the number of keys in a page if no limit is requested.
+	defaultPageLimit = 100
+	// maxPageLimit is the maximum number of keys in a page.
+	maxPageLimit = 1000
+)
+
+// Batch defines an HTTP handler function for applying several writes atomically.
+// It expects a JSON request body with an "operations" array, whose entries have the fields
+// "type" ("put" or "delete"), "key", "value" and "ttl" in seconds, as well as the optional
+// conditions "if_match" and "if_none_match" with the same values as the headers.
+// Either all operations are applied or none of them. The new versions of the objects
+// are returned in the same order as the operations. The operations are applied in the
+// namespace of the path or in the default namespace.
+func Batch(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Operations []struct {
+				IfMatch     string `json:"if_match,omitempty"`
+				IfNoneMatch string `json:"if_none_match,omitempty"`
+				Key         string `json:"key"`
+				TTL         int64  `json:"ttl,omitempty"`
+				Type        string `json:"type"`
+				Value       string `json:"value,omitempty"`
+			} `json:"operations"`
+		}
+		var res struct {
+			Versions []uint64 `json:"versions"`
+		}
+
+		ns, err := namespace(service, r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "", bodyError(err))
+			return
+		}
+
+		ops := make([]services.Operation, len(req.Operations))
+		for i, op := range req.Operations {
+			if err := authorize(r, ns.Name(), op.Key, services.RoleWrite); err != nil {
+				writeError(w, "", err)
+				return
+			}
+			cond, err := parseCondition(op.IfMatch, op.IfNoneMatch)
+			if err != nil {
+				writeError(w, "", err)
+				return
+			}
+			if op.TTL < 0 {
+				writeError(w, "", errInvalidTTL)
+				return
+			}
+			ops[i] = services.Operation{
+				Condition: cond,
+				Key:       op.Key,
+				TTL:       time.Duration(op.TTL) * time.Second,
+				Type:      services.OperationType(op.Type),
+				Value:     op.Value,
+			}
+		}
+
+		versions, err := ns.Apply(r.Context(), ops)
+		if err != nil {
+			writeError(w, "service.Apply", err)
+			return
+		}
+
+		res.Versions = versions
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
 // Delete defines an HTTP handler function for deleting an object by key.
 // It expects a JSON request body with the "key" field and deletes the corresponding object.
+// The deletion can be restricted to a version of the object by the If-Match header.
 func Delete(service *services.ObjectService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
@@ -19,78 +106,281 @@ func Delete(service *services.ObjectService) http.HandlerFunc {
 		}
 		var res struct{}
 
+		cond, err := condition(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, "", bodyError(err))
+			return
+		}
+
+		if err := authorize(r, services.DefaultNamespace, req.Key, services.RoleWrite); err != nil {
+			writeError(w, "", err)
 			return
 		}
 
-		if err := service.Delete(r.Context(), req.Key); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("service.Delete error: %v", err)
+		if err := service.DeleteIf(r.Context(), req.Key, cond); err != nil {
+			writeError(w, "service.DeleteIf", err)
 			return
 		}
 
+		w.Header().Set("Content-Type", contentTypeJSON)
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(res)
 	}
 }
 
+// DeleteValue defines an HTTP handler function for deleting an object by the key and the optional namespace in the path.
+// The deletion can be restricted to a version of the object by the If-Match header.
+func DeleteValue(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, err := namespace(service, r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		if err := authorize(r, ns.Name(), r.PathValue("key"), services.RoleWrite); err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		cond, err := condition(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		if err := ns.DeleteIf(r.Context(), r.PathValue("key"), cond); err != nil {
+			writeError(w, "service.DeleteIf", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // Get defines an HTTP handler function for retrieving an object by key.
 // It expects a JSON request body with the "key" field and retrieves the corresponding object.
+// The version of the object is returned as the ETag header and its expiry as the Expires header.
 func Get(service *services.ObjectService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Key string `json:"key"`
 		}
 		var res struct {
-			Value string `json:"value,omitempty"`
+			Value   string `json:"value,omitempty"`
+			Version uint64 `json:"version,omitempty"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, "", bodyError(err))
 			return
 		}
 
-		value, err := service.Get(r.Context(), req.Key)
+		if err := authorize(r, services.DefaultNamespace, req.Key, services.RoleRead); err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		obj, err := service.GetObject(r.Context(), req.Key)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			log.Printf("service.Get error: %v", err)
+			writeError(w, "service.GetObject", err)
 			return
 		}
 
-		res.Value = value
+		res.Value = obj.Value
+		res.Version = obj.Version
 
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("ETag", etag(obj.Version))
+		setExpires(w, obj)
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(res)
 	}
 }
 
+// GetValue defines an HTTP handler function for retrieving an object by the key and the optional namespace in the path.
+// The value is returned as the raw response body, its version as the ETag header
+// and its expiry as the Expires header.
+// HEAD requests get the same headers without the body.
+func GetValue(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, err := namespace(service, r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		if err := authorize(r, ns.Name(), r.PathValue("key"), services.RoleRead); err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		obj, err := ns.GetObject(r.Context(), r.PathValue("key"))
+		if err != nil {
+			writeError(w, "service.GetObject", err)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.Value)))
+		w.Header().Set("Content-Type", contentTypeValue)
+		w.Header().Set("ETag", etag(obj.Version))
+		setExpires(w, obj)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, obj.Value)
+	}
+}
+
+// Keys defines an HTTP handler function for listing the keys of the store or of the namespace in the path page by page.
+// It expects the optional query parameters "prefix", "start", "end", "cursor" and "limit"
+// and returns the keys in ascending order together with the cursor of the next page.
+func Keys(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, err := namespace(service, r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		query := r.URL.Query()
+		if err := authorize(r, ns.Name(), query.Get("prefix"), services.RoleRead); err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		limit := defaultPageLimit
+		if value := query.Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxPageLimit {
+				writeError(w, "", errInvalidRequest)
+				return
+			}
+		}
+
+		page, err := ns.Scan(r.Context(), ports.Range[string]{
+			After:  query.Get("cursor"),
+			End:    query.Get("end"),
+			Limit:  limit,
+			Prefix: query.Get("prefix"),
+			Start:  query.Get("start"),
+		})
+		if err != nil {
+			writeError(w, "service.Scan", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(page)
+	}
+}
+
 // Put defines an HTTP handler function for creating or updating an object.
-// It expects a JSON request body with "key" and "value" fields.
+// It expects a JSON request body with "key" and "value" fields and an optional
+// "ttl" field or X-TTL header with the number of seconds after which the object expires.
+// The write can be restricted by the If-Match and If-None-Match headers.
+// The new version of the object is returned as the ETag header.
 func Put(service *services.ObjectService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Key   string `json:"key"`
+			TTL   int64  `json:"ttl,omitempty"`
 			Value string `json:"value"`
 		}
 		var res struct{}
 
+		cond, err := condition(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		ttl, err := timeToLive(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, "", bodyError(err))
+			return
+		}
+		if req.TTL < 0 {
+			writeError(w, "", errInvalidRequest)
+			return
+		}
+		if req.TTL > 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+
+		if err := authorize(r, services.DefaultNamespace, req.Key, services.RoleWrite); err != nil {
+			writeError(w, "", err)
 			return
 		}
 
-		if err := service.Put(r.Context(), req.Key, req.Value); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("service.Put error: %v", err)
+		version, err := service.PutIf(r.Context(), req.Key, req.Value, cond, ttl)
+		if err != nil {
+			writeError(w, "service.PutIf", err)
 			return
 		}
 
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("ETag", etag(version))
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(res)
 	}
 }
 
+// PutValue defines an HTTP handler function for creating or updating an object by the key and the optional namespace in the path.
+// It expects the raw value as the request body and an optional X-TTL header with the number
+// of seconds after which the object expires. The write can be restricted by the
+// If-Match and If-None-Match headers. The new version of the object is returned as the ETag header.
+func PutValue(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, err := namespace(service, r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		if err := authorize(r, ns.Name(), r.PathValue("key"), services.RoleWrite); err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		cond, err := condition(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		ttl, err := timeToLive(r)
+		if err != nil {
+			writeError(w, "", err)
+			return
+		}
+
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, "", bodyError(err))
+			return
+		}
+
+		version, err := ns.PutIf(r.Context(), r.PathValue("key"), string(value), cond, ttl)
+		if err != nil {
+			writeError(w, "service.PutIf", err)
+			return
+		}
+
+		w.Header().Set("ETag", etag(version))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // View defines an HTTP handler function for rendering a template with data.
 func View(engine *templating.Engine, name string, data any) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -108,11 +398,15 @@ func ViewIndex(engine *templating.Engine, serverSessions *security.ServerSession
 	}
 }
 
-// ViewStore defines an HTTP handler function for rendering the store template.
-func ViewStore(engine *templating.Engine, serverSessions *security.ServerSessions) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.FormValue("s")
-		session, _ := serverSessions.Get(id)
-		View(engine, "store", session)(w, r)
+// namespace returns the namespace in the path of the request or the default namespace
+// for the endpoints without a namespace.
+func namespace(service *services.ObjectService, r *http.Request) (*services.Namespace, error) {
+	return service.Namespace(r.PathValue("ns"))
+}
+
+// setExpires sets the Expires header if the object expires.
+func setExpires(w http.ResponseWriter, obj services.Object) {
+	if !obj.ExpiresAt.IsZero() {
+		w.Header().Set("Expires", obj.ExpiresAt.UTC().Format(http.TimeFormat))
 	}
 }
@@ -0,0 +1,299 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/inbound/api"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// maxBodyBytes is the limit of the request bodies of the test servers.
+const maxBodyBytes = 1 << 10
+
+// newServer creates a test server with the store endpoints on an in-memory port.
+// Requests without an Authorization header are sent with an admin token.
+func newServer(t *testing.T) *httptest.Server {
+	return newServerWithPort(t, inmemory.NewObjectStore(2))
+}
+
+// newServerWithPort creates a test server with the store endpoints on the port.
+func newServerWithPort(t *testing.T, port ports.ObjectPort[string, string]) *httptest.Server {
+	return newServerWithSessions(t, port, services.ACL{}, nil)
+}
+
+// newServerWithSessions creates a test server with the store endpoints on the port,
+// which authorizes the sessions by the ACL.
+func newServerWithSessions(t *testing.T, port ports.ObjectPort[string, string], acl services.ACL, sessions api.Sessions) *httptest.Server {
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	tokens := services.NewTokenService(svc).WithACL(acl)
+	_, secret, err := tokens.IssueAdmin(context.Background(), "test")
+	assert.That(t, "err must be nil", err, nil)
+
+	mux := http.NewServeMux()
+	api.RouteStore(mux, svc, tokens, sessions, maxBodyBytes)
+	api.RouteTokens(mux, tokens, sessions, maxBodyBytes)
+	mux.HandleFunc("GET /metrics", api.Metrics(svc))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+secret)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do sends a request with the body and returns the response and its body.
+func do(t *testing.T, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.That(t, "err must be nil", err, nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.That(t, "err must be nil", err, nil)
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+func TestValue_Put_Get_Head_Delete(t *testing.T) {
+	srv := newServer(t)
+	url := srv.URL + "/api/v1/store/users/42"
+
+	res, _ := do(t, http.MethodPut, url, "hello")
+	assert.That(t, "put status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, body := do(t, http.MethodGet, url, "")
+	assert.That(t, "get status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "body must be the raw value", body, "hello")
+	assert.That(t, "content type must be binary", res.Header.Get("Content-Type"), "application/octet-stream")
+
+	res, body = do(t, http.MethodHead, url, "")
+	assert.That(t, "head status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "head must not have a body", body, "")
+	assert.That(t, "head must have the content length", res.ContentLength, int64(5))
+
+	res, _ = do(t, http.MethodDelete, url, "")
+	assert.That(t, "delete status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, _ = do(t, http.MethodGet, url, "")
+	assert.That(t, "get status must be 404", res.StatusCode, http.StatusNotFound)
+}
+
+func TestValue_Compatible_With_JSON_Endpoints(t *testing.T) {
+	srv := newServer(t)
+
+	res, _ := do(t, http.MethodPut, srv.URL+"/api/v1/store", `{"key":"foo","value":"bar"}`)
+	assert.That(t, "put status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "content type must be JSON", res.Header.Get("Content-Type"), "application/json")
+
+	_, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/foo", "")
+	assert.That(t, "body must be the value", body, "bar")
+}
+
+func TestKeys(t *testing.T) {
+	srv := newServer(t)
+	for _, key := range []string{"a", "b", "c"} {
+		do(t, http.MethodPut, srv.URL+"/api/v1/store/"+key, "value")
+	}
+
+	res, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/keys?limit=2", "")
+	assert.That(t, "status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "body must be the first page", body, `{"keys":["a","b"],"next":"b"}`+"\n")
+
+	res, _ = do(t, http.MethodGet, srv.URL+"/api/v1/store/keys?limit=0", "")
+	assert.That(t, "status must be 400", res.StatusCode, http.StatusBadRequest)
+}
+
+func TestNamespaces(t *testing.T) {
+	srv := newServer(t)
+	do(t, http.MethodPut, srv.URL+"/api/v1/ns/team-a/store/foo", "a")
+	do(t, http.MethodPut, srv.URL+"/api/v1/ns/team-b/store/foo", "b")
+	do(t, http.MethodPut, srv.URL+"/api/v1/store/bar", "default")
+
+	_, body := do(t, http.MethodGet, srv.URL+"/api/v1/ns/team-a/store/foo", "")
+	assert.That(t, "body must be the value of team-a", body, "a")
+
+	_, body = do(t, http.MethodGet, srv.URL+"/api/v1/ns/team-b/store/keys", "")
+	assert.That(t, "keys must only be those of team-b", body, `{"keys":["foo"]}`+"\n")
+
+	_, body = do(t, http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "keys must only be those of the default namespace", body, `{"keys":["bar"]}`+"\n")
+
+	res, _ := do(t, http.MethodGet, srv.URL+"/api/v1/store/foo", "")
+	assert.That(t, "status must be 404", res.StatusCode, http.StatusNotFound)
+
+	res, _ = do(t, http.MethodGet, srv.URL+"/api/v1/ns/Team_A!/store/foo", "")
+	assert.That(t, "status must be 400", res.StatusCode, http.StatusBadRequest)
+}
+
+func TestValue_Conditional_Writes(t *testing.T) {
+	srv := newServer(t)
+	url := srv.URL + "/api/v1/store/foo"
+
+	put := func(value string, header ...string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(value))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.That(t, "err must be nil", err, nil)
+		_ = res.Body.Close()
+		return res
+	}
+
+	res := put("v1", "If-None-Match", "*")
+	assert.That(t, "create status must be 204", res.StatusCode, http.StatusNoContent)
+	tag := res.Header.Get("ETag")
+
+	res, _ = do(t, http.MethodGet, url, "")
+	assert.That(t, "get must return the ETag", res.Header.Get("ETag"), tag)
+
+	res = put("v2", "If-None-Match", "*")
+	assert.That(t, "second create status must be 412", res.StatusCode, http.StatusPreconditionFailed)
+
+	res = put("v2", "If-Match", tag)
+	assert.That(t, "update status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res = put("v3", "If-Match", tag)
+	assert.That(t, "outdated update status must be 412", res.StatusCode, http.StatusPreconditionFailed)
+
+	res = put("v3", "If-Match", "W/abc")
+	assert.That(t, "invalid ETag status must be 400", res.StatusCode, http.StatusBadRequest)
+
+	_, body := do(t, http.MethodGet, url, "")
+	assert.That(t, "value must be 'v2'", body, "v2")
+}
+
+func TestValue_TTL(t *testing.T) {
+	srv := newServer(t)
+	url := srv.URL + "/api/v1/store/session"
+
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("token"))
+	req.Header.Set("X-TTL", "60")
+	res, err := http.DefaultClient.Do(req)
+	assert.That(t, "err must be nil", err, nil)
+	_ = res.Body.Close()
+	assert.That(t, "put status must be 204", res.StatusCode, http.StatusNoContent)
+
+	res, _ = do(t, http.MethodGet, url, "")
+	expires, err := http.ParseTime(res.Header.Get("Expires"))
+	assert.That(t, "Expires must be a date", err, nil)
+	assert.That(t, "Expires must be in the future", expires.After(time.Now()), true)
+
+	res, _ = do(t, http.MethodPut, srv.URL+"/api/v1/store", `{"key":"other","value":"v","ttl":-1}`)
+	assert.That(t, "negative ttl status must be 400", res.StatusCode, http.StatusBadRequest)
+}
+
+func TestBatch(t *testing.T) {
+	srv := newServer(t)
+	do(t, http.MethodPut, srv.URL+"/api/v1/store/a", "1")
+
+	res, _ := do(t, http.MethodPost, srv.URL+"/api/v1/store/batch", `{"operations":[
+		{"type":"delete","key":"a"},
+		{"type":"put","key":"b","value":"2","if_none_match":"*"}
+	]}`)
+	assert.That(t, "status must be 200", res.StatusCode, http.StatusOK)
+
+	res, _ = do(t, http.MethodPost, srv.URL+"/api/v1/store/batch", `{"operations":[
+		{"type":"put","key":"a","value":"1"},
+		{"type":"put","key":"b","value":"3","if_none_match":"*"}
+	]}`)
+	assert.That(t, "conflict status must be 412", res.StatusCode, http.StatusPreconditionFailed)
+
+	res, _ = do(t, http.MethodPost, srv.URL+"/api/v1/store/batch", `{"operations":[{"type":"move","key":"a"}]}`)
+	assert.That(t, "invalid status must be 400", res.StatusCode, http.StatusBadRequest)
+
+	_, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/keys", "")
+	assert.That(t, "only the first batch must be applied", body, `{"keys":["b"]}`+"\n")
+}
+
+func TestErrors(t *testing.T) {
+	srv := newServer(t)
+
+	res, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/missing", "")
+	assert.That(t, "status must be 404", res.StatusCode, http.StatusNotFound)
+	assert.That(t, "body must be the error", body, `{"error":"key does not exist"}`+"\n")
+
+	res, _ = do(t, http.MethodPut, srv.URL+"/api/v1/store", `{"key":`)
+	assert.That(t, "status must be 400", res.StatusCode, http.StatusBadRequest)
+	assert.That(t, "content type must be JSON", res.Header.Get("Content-Type"), "application/json")
+}
+
+func TestErrors_Body_Too_Large(t *testing.T) {
+	srv := newServer(t)
+	large := strings.Repeat("x", maxBodyBytes+1)
+
+	res, body := do(t, http.MethodPut, srv.URL+"/api/v1/store/foo", large)
+	assert.That(t, "value status must be 413", res.StatusCode, http.StatusRequestEntityTooLarge)
+	assert.That(t, "body must be the error", body, `{"error":"request body too large"}`+"\n")
+
+	res, _ = do(t, http.MethodPut, srv.URL+"/api/v1/store", `{"key":"foo","value":"`+large+`"}`)
+	assert.That(t, "JSON status must be 413", res.StatusCode, http.StatusRequestEntityTooLarge)
+
+	res, _ = do(t, http.MethodPut, srv.URL+"/api/v1/store/foo", large[1:])
+	assert.That(t, "value within the limit status must be 204", res.StatusCode, http.StatusNoContent)
+}
+
+// unavailablePort fails the reads of the key "unavailable" with an error, which reveals the address of its database.
+type unavailablePort struct {
+	ports.ObjectPort[string, string]
+}
+
+func (a unavailablePort) Get(ctx context.Context, key string) (string, error) {
+	if key == "unavailable" {
+		return "", errors.New("dial tcp 10.0.0.1:5432: connection refused")
+	}
+	return a.ObjectPort.Get(ctx, key)
+}
+
+func TestErrors_Unavailable_Port(t *testing.T) {
+	srv := newServerWithPort(t, unavailablePort{inmemory.NewObjectStore(2)})
+
+	res, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/unavailable", "")
+	assert.That(t, "status must be 503", res.StatusCode, http.StatusServiceUnavailable)
+	assert.That(t, "body must not reveal the error", body, `{"error":"service unavailable"}`+"\n")
+}
+
+func TestErrors_Corrupt_Value(t *testing.T) {
+	port := inmemory.NewObjectStore(2)
+	_ = port.Put(context.Background(), "foo", "not base64!")
+	srv := newServerWithPort(t, port)
+
+	res, body := do(t, http.MethodGet, srv.URL+"/api/v1/store/foo", "")
+	assert.That(t, "status must be 422", res.StatusCode, http.StatusUnprocessableEntity)
+	assert.That(t, "body must be the error", strings.HasPrefix(body, `{"error":"corrupt value`), true)
+}
+
+func TestMetrics(t *testing.T) {
+	srv := newServer(t)
+	do(t, http.MethodPut, srv.URL+"/api/v1/store/foo", "bar")
+	do(t, http.MethodGet, srv.URL+"/api/v1/store/foo", "")
+	do(t, http.MethodGet, srv.URL+"/api/v1/store/missing", "")
+
+	res, body := do(t, http.MethodGet, srv.URL+"/metrics", "")
+	assert.That(t, "status must be 200", res.StatusCode, http.StatusOK)
+	assert.That(t, "content type must be the text format", strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4"), true)
+	// The admin token of the requests is read by the same operation as the values.
+	for _, line := range []string{
+		`# TYPE store_operation_duration_seconds histogram`,
+		`store_operation_errors_total{operation="get",type="not_found"} 1`,
+		`store_operation_duration_seconds_bucket{operation="get",le="+Inf"} `,
+		`store_operation_duration_seconds_count{operation="put"} `,
+		`store_breaker_state{call="get",state="closed"} 1`,
+		`store_breaker_state{call="get",state="open"} 0`,
+		`store_port_retries_total{call="get"} 0`,
+	} {
+		assert.That(t, "metrics must contain "+line, strings.Contains(body, line), true)
+	}
+	assert.That(t, "metrics must contain the shards", strings.Contains(body, `store_shard_keys{shard="1"}`), true)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+)
+
+// contentTypeMetrics is the content type of the Prometheus text format.
+const contentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metrics defines an HTTP handler function for exposing the metrics of the service in the Prometheus text format.
+// It exposes the number, errors and latencies of the operations, the retries and the breaker state of the
+// calls of the port, the number of keys per shard of a sharded port and the size of the transaction log.
+func Metrics(service *services.ObjectService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := service.Stats()
+
+		w.Header().Set("Content-Type", contentTypeMetrics)
+		w.WriteHeader(http.StatusOK)
+
+		out := bufio.NewWriter(w)
+		defer out.Flush()
+
+		family(out, "store_operations_total", "counter", "Number of operations of the store.")
+		for _, op := range stats.Operations {
+			fmt.Fprintf(out, "store_operations_total{operation=%q} %d\n", op.Operation, op.Count)
+		}
+		family(out, "store_operation_errors_total", "counter", "Number of failed operations of the store by the type of the error.")
+		for _, op := range stats.Operations {
+			for _, typ := range slices.Sorted(maps.Keys(op.Errors)) {
+				fmt.Fprintf(out, "store_operation_errors_total{operation=%q,type=%q} %d\n", op.Operation, typ, op.Errors[typ])
+			}
+		}
+		family(out, "store_operation_duration_seconds", "histogram", "Latency of the operations of the store.")
+		for _, op := range stats.Operations {
+			for i, bound := range services.LatencyBuckets {
+				le := strconv.FormatFloat(bound, 'g', -1, 64)
+				fmt.Fprintf(out, "store_operation_duration_seconds_bucket{operation=%q,le=%q} %d\n", op.Operation, le, op.Buckets[i])
+			}
+			fmt.Fprintf(out, "store_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", op.Operation, op.Count)
+			fmt.Fprintf(out, "store_operation_duration_seconds_sum{operation=%q} %g\n", op.Operation, op.Sum.Seconds())
+			fmt.Fprintf(out, "store_operation_duration_seconds_count{operation=%q} %d\n", op.Operation, op.Count)
+		}
+
+		family(out, "store_port_retries_total", "counter", "Number of retried calls of the port.")
+		for _, call := range stats.Calls {
+			fmt.Fprintf(out, "store_port_retries_total{call=%q} %d\n", call.Call, call.Retries)
+		}
+		family(out, "store_breaker_state", "gauge", "State of the circuit breaker of the calls of the port, which is 1 for the current state.")
+		for _, call := range stats.Calls {
+			for _, state := range []services.BreakerState{services.BreakerClosed, services.BreakerHalfOpen, services.BreakerOpen} {
+				current := 0
+				if call.BreakerState == state {
+					current = 1
+				}
+				fmt.Fprintf(out, "store_breaker_state{call=%q,state=%q} %d\n", call.Call, state, current)
+			}
+		}
+		family(out, "store_breaker_trips_total", "counter", "Number of times the circuit breaker of the calls of the port has been opened, including the failed trials.")
+		for _, call := range stats.Calls {
+			fmt.Fprintf(out, "store_breaker_trips_total{call=%q} %d\n", call.Call, call.BreakerTrips)
+		}
+
+		if stats.ShardKeys != nil {
+			family(out, "store_shard_keys", "gauge", "Number of keys per shard of the port.")
+			for i, keys := range stats.ShardKeys {
+				fmt.Fprintf(out, "store_shard_keys{shard=\"%d\"} %d\n", i, keys)
+			}
+		}
+		if stats.TransactionLogBytes >= 0 {
+			family(out, "store_transaction_log_bytes", "gauge", "Size of the transaction log including its snapshot.")
+			fmt.Fprintf(out, "store_transaction_log_bytes %d\n", stats.TransactionLogBytes)
+		}
+	}
+}
+
+// family writes the help and the type of a metric family.
+func family(out *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
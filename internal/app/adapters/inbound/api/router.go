@@ -11,16 +11,21 @@ import (
 )
 
 // Route creates a new mux with the liveness and readiness probe (/liveness, /readiness),
-// the static assets endpoint (/) and the store endpoints (/api/v1/store).
-func Route(service *services.ObjectService, ctx context.Context, cfg *config.Config) *http.ServeMux {
+// the static assets endpoint (/) and the store endpoints (/api/v1/store, /api/v1/store/{key}, /api/v1/store/batch)
+// together with the store endpoints of the namespaces (/api/v1/ns/{ns}/store/{key}, /api/v1/ns/{ns}/store/batch)
+// the token endpoints (/api/v1/tokens, /api/v1/tokens/{id}) and the metrics endpoint (/metrics).
+func Route(service *services.ObjectService, tokens *services.TokenService, ctx context.Context, cfg *config.Config) *http.ServeMux {
 	// Create a new mux with liveness and readyness endpoint.
 	// Embed the assets into the mux.
 	mux, serverSessions := security.NewServeMux(ctx, cfg.Server.Efs)
 
-	// Add the store endpoints to the mux.
-	mux.HandleFunc("DELETE /api/v1/store", Delete(service))
-	mux.HandleFunc("GET /api/v1/store", Get(service))
-	mux.HandleFunc("PUT /api/v1/store", Put(service))
+	// Add the store and token endpoints to the mux.
+	// The UI sessions are authorized by the same rules as the API tokens.
+	RouteStore(mux, service, tokens, serverSessions, cfg.Server.MaxBodyBytes)
+	RouteTokens(mux, tokens, serverSessions, cfg.Server.MaxBodyBytes)
+
+	// Add the metrics endpoint in the Prometheus text format.
+	mux.HandleFunc("GET /metrics", Metrics(service))
 
 	// Create a new templating engine and parse the templates.
 	engine := templating.NewEngine(cfg.Server.Efs)
@@ -28,6 +33,59 @@ func Route(service *services.ObjectService, ctx context.Context, cfg *config.Con
 
 	// Add the UI endpoints for HTMX.
 	mux.HandleFunc("GET /ui", ViewIndex(engine, serverSessions))
-	mux.HandleFunc("GET /ui/store", ViewStore(engine, serverSessions))
+	RouteUI(mux, engine, service, tokens, serverSessions)
 	return mux
 }
+
+// RouteStore adds the store endpoints to the mux.
+// Every request requires an API token or a UI session, which grants the role for the keys of the request.
+// The body of a request is limited to maxBodyBytes, unless it is zero.
+func RouteStore(mux *http.ServeMux, service *services.ObjectService, tokens *services.TokenService, sessions Sessions, maxBodyBytes int64) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, limitBody(maxBodyBytes, Authenticate(tokens, sessions, handler)))
+	}
+
+	// The endpoints with the key in the JSON body are kept for backward compatibility.
+	handle("DELETE /api/v1/store", Delete(service))
+	handle("GET /api/v1/store", Get(service))
+	handle("PUT /api/v1/store", Put(service))
+
+	// Add the endpoint for applying several writes atomically.
+	handle("POST /api/v1/store/batch", Batch(service))
+
+	// Add the store endpoints with the key in the path.
+	// Keys may contain slashes, but "keys" is reserved for listing the keys.
+	// The GET patterns also match HEAD requests.
+	handle("GET /api/v1/store/keys", Keys(service))
+	handle("DELETE /api/v1/store/{key...}", DeleteValue(service))
+	handle("GET /api/v1/store/{key...}", GetValue(service))
+	handle("PUT /api/v1/store/{key...}", PutValue(service))
+
+	// Add the same endpoints for the isolated key space of each namespace.
+	handle("POST /api/v1/ns/{ns}/store/batch", Batch(service))
+	handle("GET /api/v1/ns/{ns}/store/keys", Keys(service))
+	handle("DELETE /api/v1/ns/{ns}/store/{key...}", DeleteValue(service))
+	handle("GET /api/v1/ns/{ns}/store/{key...}", GetValue(service))
+	handle("PUT /api/v1/ns/{ns}/store/{key...}", PutValue(service))
+}
+
+// RouteTokens adds the endpoints for managing the API tokens to the mux.
+// Every request requires an API token or a UI session with the admin role for the grants of the managed tokens.
+// The body of a request is limited to maxBodyBytes, unless it is zero.
+func RouteTokens(mux *http.ServeMux, tokens *services.TokenService, sessions Sessions, maxBodyBytes int64) {
+	mux.HandleFunc("GET /api/v1/tokens", limitBody(maxBodyBytes, Authenticate(tokens, sessions, ListTokens(tokens))))
+	mux.HandleFunc("POST /api/v1/tokens", limitBody(maxBodyBytes, Authenticate(tokens, sessions, IssueToken(tokens))))
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", limitBody(maxBodyBytes, Authenticate(tokens, sessions, RevokeToken(tokens))))
+}
+
+// limitBody defines an HTTP middleware, which fails the reads of a request body after the given number of bytes,
+// so that a single request cannot exhaust the memory. A limit of zero disables it.
+func limitBody(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
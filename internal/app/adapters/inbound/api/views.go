@@ -0,0 +1,310 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/security"
+	"github.com/andygeiss/cloud-native-utils/templating"
+)
+
+// uiPageLimit is the number of keys in a page of the key list of the UI.
+const uiPageLimit = 20
+
+// flash is a message, which is shown above the panels of the UI.
+// Kind is either "info" or "error".
+type flash struct {
+	Kind    string
+	Message string
+}
+
+// keyLink is an entry of the key list, whose URL opens the value of the key.
+type keyLink struct {
+	Name string
+	URL  string
+}
+
+// keysView is the data of the key list of the UI.
+type keysView struct {
+	First string // URL of the first page, if this is not the first page.
+	Flash flash
+	Keys  []keyLink
+	Next  string // URL of the next page, if there are more keys.
+}
+
+// storeView is the data of the console of the UI.
+type storeView struct {
+	Flash     flash
+	Namespace string
+	Session   security.ServerSession
+}
+
+// valueView is the data of the value panel of the UI. Its form creates a new object if New is set
+// and updates the object if it has a version.
+type valueView struct {
+	ExpiresAt time.Time
+	Flash     flash
+	Key       string
+	Namespace string
+	New       bool
+	SessionID string
+	Value     string
+	Version   uint64
+}
+
+// RouteUI adds the HTMX endpoints of the UI to the mux.
+// Every request requires a UI session, whose user is authorized by the ACL like the requests of the store API.
+func RouteUI(mux *http.ServeMux, engine *templating.Engine, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) {
+	mux.HandleFunc("GET /ui/store", ViewStore(engine, sessions, tokens))
+	mux.HandleFunc("GET /ui/store/keys", ViewKeys(engine, service, tokens, sessions))
+	mux.HandleFunc("GET /ui/store/value", ViewValue(engine, service, tokens, sessions))
+	mux.HandleFunc("PUT /ui/store/value", SaveValue(engine, service, tokens, sessions))
+	mux.HandleFunc("DELETE /ui/store/value", RemoveValue(engine, service, tokens, sessions))
+}
+
+// ViewStore defines an HTTP handler function for rendering the store template, which is the console of the UI.
+// It shows the login link without a session and an error if the ACL does not grant any access to the user.
+func ViewStore(engine *templating.Engine, sessions Sessions, tokens *services.TokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := storeView{Namespace: r.FormValue("ns")}
+		data.Session, _ = sessions.Get(r.FormValue("s"))
+		if data.Session.ID != "" {
+			if _, err := consoleToken(r, tokens, sessions); err != nil {
+				data.Flash = flashError("", err)
+			}
+		}
+		View(engine, "store", data)(w, r)
+	}
+}
+
+// ViewKeys defines an HTTP handler function for rendering a page of the keys in the namespace "ns"
+// with the prefix "prefix" after the key "after" as a partial of the UI.
+func ViewKeys(engine *templating.Engine, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data keysView
+
+		ns, token, err := consoleNamespace(r, service, tokens, sessions)
+		if err == nil && !token.Allows(ns.Name(), r.FormValue("prefix"), services.RoleRead) {
+			err = services.ErrorForbidden
+		}
+		if err != nil {
+			data.Flash = flashError("service.Scan", err)
+			View(engine, "store-keys", data)(w, r)
+			return
+		}
+
+		page, err := ns.Scan(r.Context(), ports.Range[string]{
+			After:  r.FormValue("after"),
+			Limit:  uiPageLimit,
+			Prefix: r.FormValue("prefix"),
+		})
+		if err != nil {
+			data.Flash = flashError("service.Scan", err)
+			View(engine, "store-keys", data)(w, r)
+			return
+		}
+
+		query := url.Values{"ns": {ns.Name()}, "s": {r.FormValue("s")}}
+		for _, key := range page.Keys {
+			data.Keys = append(data.Keys, keyLink{Name: key, URL: uiURL("/ui/store/value", query, "key", key)})
+		}
+		query.Set("prefix", r.FormValue("prefix"))
+		if r.FormValue("after") != "" {
+			data.First = uiURL("/ui/store/keys", query, "after", "")
+		}
+		if page.Next != "" {
+			data.Next = uiURL("/ui/store/keys", query, "after", page.Next)
+		}
+
+		View(engine, "store-keys", data)(w, r)
+	}
+}
+
+// ViewValue defines an HTTP handler function for rendering the value of the key "key" in the namespace "ns"
+// as an editable partial of the UI. Without a key an empty form for creating a new object is rendered.
+func ViewValue(engine *templating.Engine, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := valueView{Key: r.FormValue("key"), SessionID: r.FormValue("s")}
+
+		ns, token, err := consoleNamespace(r, service, tokens, sessions)
+		if err != nil {
+			data.Flash = flashError("", err)
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+		data.Namespace, data.New = ns.Name(), data.Key == ""
+		if data.New {
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+
+		if !token.Allows(ns.Name(), data.Key, services.RoleRead) {
+			data.Flash = flashError("", services.ErrorForbidden)
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+		obj, err := ns.GetObject(r.Context(), data.Key)
+		if err != nil {
+			data.Flash = flashError("service.GetObject", err)
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+		data.ExpiresAt, data.Value, data.Version = obj.ExpiresAt, obj.Value, obj.Version
+
+		View(engine, "store-value", data)(w, r)
+	}
+}
+
+// SaveValue defines an HTTP handler function for saving the value of the form of the value panel.
+// A new object must not exist yet and an existing object must still have the version of the form,
+// so that concurrent changes are not overwritten. An existing object keeps its expiry, unless the form sets a new one. The key list is refreshed by the "keys-changed" event.
+func SaveValue(engine *templating.Engine, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := valueView{Key: r.FormValue("key"), SessionID: r.FormValue("s"), Value: r.FormValue("value")}
+		data.Version, _ = strconv.ParseUint(r.FormValue("version"), 10, 64)
+		data.New = data.Version == 0
+
+		ns, token, err := consoleNamespace(r, service, tokens, sessions)
+		if err == nil {
+			data.Namespace = ns.Name()
+		}
+		ttl, expiresAt, ttlErr := uiExpiry(r, time.Now())
+		var version uint64
+		switch {
+		case err != nil:
+		case ttlErr != nil:
+			err = ttlErr
+		case !token.Allows(ns.Name(), data.Key, services.RoleWrite):
+			err = services.ErrorForbidden
+		default:
+			cond := services.Condition{Absent: data.New, Version: data.Version}
+			version, err = ns.PutIf(r.Context(), data.Key, data.Value, cond, ttl)
+		}
+		if err != nil {
+			data.Flash = flashError("service.PutIf", err)
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+
+		data.ExpiresAt, data.New, data.Version = expiresAt, false, version
+		data.Flash = flash{Kind: "info", Message: "Saved " + data.Key + "."}
+		w.Header().Set("HX-Trigger", "keys-changed")
+		View(engine, "store-value", data)(w, r)
+	}
+}
+
+// RemoveValue defines an HTTP handler function for deleting the key of the value panel,
+// if it still has the version of the panel. The key list is refreshed by the "keys-changed" event.
+func RemoveValue(engine *templating.Engine, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := valueView{SessionID: r.FormValue("s")}
+		key := r.FormValue("key")
+		version, _ := strconv.ParseUint(r.FormValue("version"), 10, 64)
+
+		ns, token, err := consoleNamespace(r, service, tokens, sessions)
+		switch {
+		case err != nil:
+		case !token.Allows(ns.Name(), key, services.RoleWrite):
+			err = services.ErrorForbidden
+		default:
+			err = ns.DeleteIf(r.Context(), key, services.Condition{Present: true, Version: version})
+		}
+		if err != nil {
+			data.Flash = flashError("service.DeleteIf", err)
+			View(engine, "store-value", data)(w, r)
+			return
+		}
+
+		data.Flash = flash{Kind: "info", Message: "Deleted " + key + "."}
+		w.Header().Set("HX-Trigger", "keys-changed")
+		View(engine, "store-value", data)(w, r)
+	}
+}
+
+// consoleNamespace returns the namespace "ns" of the request together with the token,
+// which has the grants of the ACL for the user of the session "s".
+func consoleNamespace(r *http.Request, service *services.ObjectService, tokens *services.TokenService, sessions Sessions) (*services.Namespace, services.Token, error) {
+	token, err := consoleToken(r, tokens, sessions)
+	if err != nil {
+		return nil, token, err
+	}
+	ns, err := service.Namespace(r.FormValue("ns"))
+	return ns, token, err
+}
+
+// consoleToken returns the token, which has the grants of the ACL for the user of the session "s".
+func consoleToken(r *http.Request, tokens *services.TokenService, sessions Sessions) (services.Token, error) {
+	id := r.FormValue("s")
+	session, found := sessions.Get(id)
+	if !found || id == "" {
+		return services.Token{}, services.ErrorUnauthorized
+	}
+	return tokens.AuthenticateSession(session.Login)
+}
+
+// flashError returns the error message for the UI. Errors which are not caused by the request
+// or the stored data are logged together with the operation and are not shown in detail.
+func flashError(operation string, err error) flash {
+	switch status := statusOf(err); {
+	case errors.Is(err, services.ErrorUnauthorized):
+		return flash{Kind: "error", Message: "Please log in with a GitHub account which has access to the store."}
+	case status >= http.StatusInternalServerError:
+		log.Printf("%s error: %v", operation, err)
+		return flash{Kind: "error", Message: "The store is not available at the moment, please try again later."}
+	default:
+		return flash{Kind: "error", Message: "Error: " + err.Error() + "."}
+	}
+}
+
+// uiExpiry returns the ttl of the object of the form and the time at which it expires.
+// An empty "ttl" keeps the expiry "expires_at" of the form, which is the expiry of the version of the form,
+// unless "persist" removes it. Thus saving an object without changing its expiry does not remove it.
+func uiExpiry(r *http.Request, now time.Time) (time.Duration, time.Time, error) {
+	ttl, err := uiTTL(r.FormValue("ttl"))
+	switch {
+	case err != nil:
+		return 0, time.Time{}, err
+	case ttl > 0:
+		return ttl, now.Add(ttl), nil
+	case r.FormValue("ttl") != "", r.FormValue("persist") != "", r.FormValue("expires_at") == "":
+		return 0, time.Time{}, nil
+	}
+	nanos, err := strconv.ParseInt(r.FormValue("expires_at"), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, errInvalidTTL
+	}
+	expiresAt := time.Unix(0, nanos)
+	// The object has expired since the form was rendered, thus it does not have the version of the form anymore.
+	if !expiresAt.After(now) {
+		return 0, time.Time{}, services.ErrorVersionMismatch
+	}
+	return expiresAt.Sub(now), expiresAt, nil
+}
+
+// uiTTL parses the number of seconds after which an object of the UI expires. An empty value means no expiry.
+func uiTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, errInvalidTTL
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// uiURL returns the path with the query and the additional parameter, which is omitted if it is empty.
+func uiURL(path string, query url.Values, name, value string) string {
+	values := maps.Clone(query)
+	if value != "" {
+		values.Set(name, value)
+	}
+	return path + "?" + values.Encode()
+}
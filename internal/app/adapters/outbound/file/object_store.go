@@ -0,0 +1,363 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+)
+
+const (
+	// journalName is the name of the file which holds a batch while it is applied.
+	journalName = "batch.journal"
+	// objectSuffix is the file extension of a committed object.
+	objectSuffix = ".json"
+	// tempPrefix marks files which are still being written and have not been committed yet.
+	tempPrefix = ".tmp-"
+)
+
+// object is the on-disk representation of a key and its value.
+type object struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ObjectStore is a durable object storage that keeps every key in its own file.
+// Writes go to a temporary file which is synced and then atomically renamed,
+// so that a crash during a write never leaves a partially written object behind.
+// Writes are serialized, which makes compare-and-swap atomic. Reads do not need a lock,
+// because a file is always replaced as a whole. A batch is written to a journal first,
+// which is applied again on open, if a crash interrupted the batch.
+// The keys are hashed into the file names, thus a sorted index of the keys is kept in memory for scans.
+type ObjectStore struct {
+	index sync.RWMutex // Protects keys, which are also read by scans while a write is running.
+	keys  []string     // Keys of the committed objects in ascending order.
+	mutex sync.Mutex
+	path  string
+}
+
+// NewObjectStore initializes a new ObjectStore in the given directory.
+// The directory is created if necessary and left-over temporary files from an
+// interrupted write are removed. The keys of all objects are read into the index and a batch which was interrupted is completed.
+// It returns an implementation of the ports.ObjectPort interface.
+func NewObjectStore(path string) (ports.ObjectPort[string, string], error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	// Remove the files of writes which have not been committed before a crash.
+	temps, err := filepath.Glob(filepath.Join(path, tempPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, temp := range temps {
+		if err := os.Remove(temp); err != nil {
+			return nil, err
+		}
+	}
+
+	a := &ObjectStore{path: path}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+	if err := a.recoverJournal(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Apply applies either all writes or none of them.
+// The writes are committed to the journal before the first file is changed.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Check every write before the batch is committed.
+	for _, w := range writes {
+		current, err := a.Get(ctx, w.Key)
+		exists := !errors.Is(err, ErrorKeyDoesNotExist)
+		if exists && err != nil {
+			return err
+		}
+		if exists != (w.Old != "") || current != w.Old {
+			return ports.ErrorValueChanged
+		}
+	}
+
+	data, err := json.Marshal(writes)
+	if err != nil {
+		return err
+	}
+	if err := a.writeFile(a.journal(), data); err != nil {
+		return err
+	}
+	return a.applyJournal(writes)
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, err := a.Get(ctx, key)
+	if errors.Is(err, ErrorKeyDoesNotExist) || (err == nil && current != old) {
+		return ports.ErrorValueChanged
+	}
+	if err != nil {
+		return err
+	}
+	return a.remove(key)
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, err := a.Get(ctx, key)
+	exists := !errors.Is(err, ErrorKeyDoesNotExist)
+	if exists && err != nil {
+		return err
+	}
+	if exists != (old != "") || current != old {
+		return ports.ErrorValueChanged
+	}
+	return a.write(key, value)
+}
+
+// Delete removes a key and its associated value from the store.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.remove(key)
+}
+
+// Get retrieves the value associated with the given key.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	data, err := os.ReadFile(a.filename(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return value, ErrorKeyDoesNotExist
+	}
+	if err != nil {
+		return value, err
+	}
+
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return value, ports.Permanent(err)
+	}
+
+	return obj.Value, nil
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.write(key, value)
+}
+
+// Scan returns the keys selected by the range in ascending order.
+// The index is searched for the first key of the range and at most one key more than the limit
+// is taken from it, thus the cost of a page does not grow with the number of keys in the store.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	a.index.RLock()
+	defer a.index.RUnlock()
+
+	var keys []string
+	i, _ := slices.BinarySearch(a.keys, max(r.After, r.Prefix, r.Start))
+	for _, key := range a.keys[i:] {
+		if (r.End != "" && key >= r.End) || !strings.HasPrefix(key, r.Prefix) {
+			break
+		}
+		if !r.Contains(key) {
+			continue
+		}
+		keys = append(keys, key)
+		// Only one key more than the limit is needed to tell whether there is a next page.
+		if r.Limit > 0 && len(keys) > r.Limit {
+			break
+		}
+	}
+
+	return r.Paginate(keys), nil
+}
+
+// applyJournal applies the writes of the journal and removes it afterwards.
+// The writes do not depend on the current values, thus they can be applied again after a crash.
+// The caller must hold the mutex.
+func (a *ObjectStore) applyJournal(writes []ports.Write[string, string]) (err error) {
+	for _, w := range writes {
+		if w.Value == "" {
+			err = a.remove(w.Key)
+		} else {
+			err = a.write(w.Key, w.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(a.journal()); err != nil {
+		return err
+	}
+	return a.syncDir()
+}
+
+// filename returns the path of the file holding the given key.
+// The key is hashed to get a file name of fixed length without special characters.
+func (a *ObjectStore) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(a.path, hex.EncodeToString(sum[:])+objectSuffix)
+}
+
+// indexKey adds the key to the index, if it does not contain it yet.
+func (a *ObjectStore) indexKey(key string) {
+	a.index.Lock()
+	defer a.index.Unlock()
+	if i, found := slices.BinarySearch(a.keys, key); !found {
+		a.keys = slices.Insert(a.keys, i, key)
+	}
+}
+
+// journal returns the path of the journal of the current batch.
+func (a *ObjectStore) journal() string {
+	return filepath.Join(a.path, journalName)
+}
+
+// loadKeys reads the keys of all objects into the index.
+// The keys are stored inside of the files, thus every object has to be read once.
+func (a *ObjectStore) loadKeys() (err error) {
+	entries, err := os.ReadDir(a.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !isObject(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(a.path, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var obj object
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return ports.Permanent(err)
+		}
+		a.keys = append(a.keys, obj.Key)
+	}
+	slices.Sort(a.keys)
+	return nil
+}
+
+// recoverJournal completes a batch which was committed before a crash.
+func (a *ObjectStore) recoverJournal() (err error) {
+	data, err := os.ReadFile(a.journal())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var writes []ports.Write[string, string]
+	if err := json.Unmarshal(data, &writes); err != nil {
+		return err
+	}
+	return a.applyJournal(writes)
+}
+
+// remove deletes the file of the key. The caller must hold the mutex.
+func (a *ObjectStore) remove(key string) (err error) {
+	if err := os.Remove(a.filename(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	a.unindexKey(key)
+	return a.syncDir()
+}
+
+// syncDir flushes the directory entries to disk, which makes renames and removals durable.
+func (a *ObjectStore) syncDir() (err error) {
+	dir, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// write stores the key and its value in the file of the key. The caller must hold the mutex.
+func (a *ObjectStore) write(key, value string) (err error) {
+	data, err := json.Marshal(object{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	if err := a.writeFile(a.filename(key), data); err != nil {
+		return err
+	}
+	a.indexKey(key)
+	return nil
+}
+
+// writeFile atomically replaces the file with the given data.
+func (a *ObjectStore) writeFile(filename string, data []byte) (err error) {
+	temp, err := os.CreateTemp(a.path, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file if it could not be committed.
+	defer func() {
+		if err != nil {
+			_ = os.Remove(temp.Name())
+		}
+	}()
+
+	if _, err = temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+
+	// Make sure that the data is on disk before the file becomes visible.
+	if err = temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(temp.Name(), filename); err != nil {
+		return err
+	}
+
+	return a.syncDir()
+}
+
+// unindexKey removes the key from the index, if it contains it.
+func (a *ObjectStore) unindexKey(key string) {
+	a.index.Lock()
+	defer a.index.Unlock()
+	if i, found := slices.BinarySearch(a.keys, key); found {
+		a.keys = slices.Delete(a.keys, i, i+1)
+	}
+}
+
+// isObject reports whether the file name belongs to a committed object.
+func isObject(name string) bool {
+	return strings.HasSuffix(name, objectSuffix) && !strings.HasPrefix(name, tempPrefix)
+}
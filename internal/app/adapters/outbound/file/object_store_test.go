@@ -0,0 +1,169 @@
+package file_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/file"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.NewObjectStore(t.TempDir())
+	assert.That(t, "err must be nil", err, nil)
+
+	err = store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, file.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Delete_Missing_Key(t *testing.T) {
+	store, _ := file.NewObjectStore(t.TempDir())
+
+	err := store.Delete(context.Background(), "foo")
+	assert.That(t, "err must be nil", err, nil)
+}
+
+func TestObjectStore_Reopen_Keeps_Values(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store, _ := file.NewObjectStore(path)
+	_ = store.Put(ctx, "foo", "bar")
+	_ = store.Put(ctx, "foo", "baz")
+
+	reopened, err := file.NewObjectStore(path)
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := reopened.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'baz'", value, "baz")
+}
+
+func TestObjectStore_Reopen_Removes_Uncommitted_Writes(t *testing.T) {
+	path := t.TempDir()
+	store, _ := file.NewObjectStore(path)
+	_ = store.Put(context.Background(), "foo", "bar")
+
+	// Simulate a crash in the middle of a write.
+	temp := filepath.Join(path, ".tmp-123")
+	_ = os.WriteFile(temp, []byte(`{"key":"fo`), 0600)
+
+	_, err := file.NewObjectStore(path)
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = os.Stat(temp)
+	assert.That(t, "temporary file must be removed", os.IsNotExist(err), true)
+
+	entries, _ := os.ReadDir(path)
+	assert.That(t, "only the committed object must remain", len(entries), 1)
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	store, _ := file.NewObjectStore(t.TempDir())
+	for _, key := range []string{"c", "a", "b"} {
+		_ = store.Put(ctx, key, "value")
+	}
+
+	page, err := store.Scan(ctx, ports.Range[string]{Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "keys must be sorted", page.Keys, []string{"a", "b"})
+	assert.That(t, "cursor must be the last key", page.Next, "b")
+}
+
+func TestObjectStore_Reopen_Scan(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store, _ := file.NewObjectStore(path)
+	for _, key := range []string{"c", "a", "d", "b"} {
+		_ = store.Put(ctx, key, "value")
+	}
+	_ = store.Delete(ctx, "c")
+
+	reopened, err := file.NewObjectStore(path)
+	assert.That(t, "err must be nil", err, nil)
+	_ = reopened.Put(ctx, "e", "value")
+	_ = reopened.Delete(ctx, "a")
+
+	page, err := reopened.Scan(ctx, ports.Range[string]{Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be read from the index", page, ports.Page[string]{Keys: []string{"b", "d"}, Next: "d"})
+	page, _ = reopened.Scan(ctx, ports.Range[string]{After: page.Next, Limit: 2})
+	assert.That(t, "last page must be read from the index", page, ports.Page[string]{Keys: []string{"e"}})
+}
+
+func TestObjectStore_CompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	store, _ := file.NewObjectStore(t.TempDir())
+
+	err := store.CompareAndSwap(ctx, "foo", "", "v1")
+	assert.That(t, "create must succeed", err, nil)
+
+	err = store.CompareAndSwap(ctx, "foo", "other", "v2")
+	assert.That(t, "swap of changed value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndSwap(ctx, "foo", "v1", "v2")
+	assert.That(t, "swap must succeed", err, nil)
+
+	err = store.CompareAndDelete(ctx, "foo", "v1")
+	assert.That(t, "delete of changed value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "v2")
+	assert.That(t, "delete must succeed", err, nil)
+}
+
+func TestObjectStore_Apply(t *testing.T) {
+	ctx := context.Background()
+	store, _ := file.NewObjectStore(t.TempDir())
+	batch := store.(ports.BatchPort[string, string])
+	_ = store.Put(ctx, "a", "v1")
+
+	err := batch.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "v1", Value: ""},
+		{Key: "b", Old: "other", Value: "v1"},
+	})
+	assert.That(t, "batch with changed value must fail", err, ports.ErrorValueChanged)
+
+	err = batch.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "v1", Value: ""},
+		{Key: "b", Old: "", Value: "v1"},
+	})
+	assert.That(t, "batch must succeed", err, nil)
+
+	page, _ := store.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "keys must be changed", page.Keys, []string{"b"})
+}
+
+func TestObjectStore_Reopen_Completes_Batch(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store, _ := file.NewObjectStore(path)
+	_ = store.Put(ctx, "a", "v1")
+
+	// Simulate a crash after the batch has been committed to the journal.
+	journal := `[{"key":"a","old":"v1","value":""},{"key":"b","old":"","value":"v1"}]`
+	err := os.WriteFile(filepath.Join(path, "batch.journal"), []byte(journal), 0600)
+	assert.That(t, "err must be nil", err, nil)
+
+	store, err = file.NewObjectStore(path)
+	assert.That(t, "err must be nil", err, nil)
+
+	page, _ := store.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "batch must be completed", page.Keys, []string{"b"})
+	_, err = os.Stat(filepath.Join(path, "batch.journal"))
+	assert.That(t, "journal must be removed", errors.Is(err, os.ErrNotExist), true)
+}
@@ -0,0 +1,82 @@
+package inmemory
+
+import "math/rand/v2"
+
+// maxLevel is the maximum number of levels of the skip list of an index.
+const maxLevel = 16
+
+// keyIndex is a skip list of the keys of a shard in ascending order, which lets a scan start at its
+// cursor instead of sorting every key of the shard. It is protected by the lock of the shard.
+type keyIndex struct {
+	head  *node
+	level int
+}
+
+// node is a node of the skip list with its successors on every level of the node.
+type node struct {
+	key  string
+	next []*node
+}
+
+// newKeyIndex creates an empty index.
+func newKeyIndex() *keyIndex {
+	return &keyIndex{head: &node{next: make([]*node, maxLevel)}, level: 1}
+}
+
+// insert adds the key, if the index does not contain it yet.
+func (x *keyIndex) insert(key string) {
+	var update [maxLevel]*node
+	n := x.find(key, &update)
+	if n != nil && n.key == key {
+		return
+	}
+
+	level := 1
+	for level < maxLevel && rand.Uint32()&3 == 0 {
+		level++
+	}
+	for i := x.level; i < level; i++ {
+		update[i] = x.head
+	}
+	x.level = max(x.level, level)
+	n = &node{key: key, next: make([]*node, level)}
+	for i := range level {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+}
+
+// remove removes the key, if the index contains it.
+func (x *keyIndex) remove(key string) {
+	var update [maxLevel]*node
+	n := x.find(key, &update)
+	if n == nil || n.key != key {
+		return
+	}
+	for i := range n.next {
+		update[i].next[i] = n.next[i]
+	}
+	for x.level > 1 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+}
+
+// seek returns the node of the first key greater than or equal to the key or nil, if there is none.
+func (x *keyIndex) seek(key string) *node {
+	return x.find(key, nil)
+}
+
+// find returns the first node whose key is greater than or equal to the key.
+// The predecessors of the node on each level are stored in update, if it is not nil.
+func (x *keyIndex) find(key string, update *[maxLevel]*node) *node {
+	n := x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if update != nil {
+			update[i] = n
+		}
+	}
+	return n.next[0]
+}
@@ -2,42 +2,135 @@ package inmemory
 
 import (
 	"context"
-	"errors"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
-	"github.com/andygeiss/cloud-native-utils/efficiency"
 )
 
 var (
 	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
-	ErrorKeyDoesNotExist = errors.New("key does not exist")
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
 )
 
+// shard is a part of the object store with its own lock.
+type shard struct {
+	expiries map[string]time.Time
+	items    map[string]string
+	keys     *keyIndex // Keys of the items in ascending order.
+	mutex    sync.RWMutex
+}
+
 // ObjectStore is an in-memory object storage that uses sharding for efficient access.
-// K represents the type of the keys (comparable) and V represents the type of the values (any).
+// Each key is assigned to a shard by its hash, so that operations on different shards do not block each other.
+// Keys with an expiry are removed by a background sweeper.
 type ObjectStore struct {
-	shards efficiency.Sharding[string, string]
+	shards []*shard
 }
 
 // NewObjectStore initializes a new ObjectStore with the given number of shards.
 // It returns an implementation of the ports.ObjectPort interface.
 func NewObjectStore(numShards int) ports.ObjectPort[string, string] {
+	shards := make([]*shard, max(numShards, 1))
+	for i := range shards {
+		shards[i] = &shard{expiries: make(map[string]time.Time), items: make(map[string]string), keys: newKeyIndex()}
+	}
 	return &ObjectStore{
-		shards: efficiency.NewSharding[string, string](numShards),
+		shards: shards,
+	}
+}
+
+// Apply applies either all writes or none of them.
+// The shards of all keys are locked in a fixed order, which prevents deadlocks between concurrent batches.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	var indexes []int
+	for _, w := range writes {
+		indexes = append(indexes, a.index(w.Key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	for _, i := range indexes {
+		a.shards[i].mutex.Lock()
+		defer a.shards[i].mutex.Unlock()
+	}
+
+	// Check every write before the first one is applied.
+	for _, w := range writes {
+		s := a.shards[a.index(w.Key)]
+		if current, exists := s.items[w.Key]; exists != (w.Old != "") || current != w.Old {
+			return ports.ErrorValueChanged
+		}
+	}
+	for _, w := range writes {
+		s := a.shards[a.index(w.Key)]
+		if w.Value == "" {
+			s.remove(w.Key)
+		} else {
+			s.set(w.Key, w.Value)
+		}
+	}
+	return nil
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+// The comparison and the removal are atomic within the shard of the key.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	s := a.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.items[key]; !exists || current != old {
+		return ports.ErrorValueChanged
+	}
+	s.remove(key)
+	return nil
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+// The comparison and the update are atomic within the shard of the key.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	s := a.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.items[key]; exists != (old != "") || current != old {
+		return ports.ErrorValueChanged
 	}
+	s.set(key, value)
+	return nil
 }
 
 // Delete removes a key and its associated value from the store.
 // If the key does not exist, it silently returns without an error.
 func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
-	a.shards.Delete(key)
+	s := a.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(key)
+	return nil
+}
+
+// Expire sets the time at which the key is removed by the sweeper, if its current value equals value.
+func (a *ObjectStore) Expire(ctx context.Context, key, value string, at time.Time) (err error) {
+	s := a.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.items[key]; !exists || current != value {
+		return ports.ErrorValueChanged
+	}
+	s.expiries[key] = at
 	return nil
 }
 
 // Get retrieves the value associated with the given key.
 // If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
 func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
-	value, exists := a.shards.Get(key)
+	s := a.shard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	value, exists := s.items[key]
 	if !exists {
 		return value, ErrorKeyDoesNotExist
 	}
@@ -46,6 +139,113 @@ func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err er
 
 // Put inserts or updates the value associated with the given key in the store.
 func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
-	a.shards.Put(key, value)
+	s := a.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set(key, value)
 	return nil
 }
+
+// Scan returns the keys of all shards which are selected by the range in ascending order.
+// The index of each shard is read from the first key of the range and at most one key more than
+// the limit is taken from it, thus the cost of a page does not grow with the number of keys in the store.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	var keys []string
+	for _, s := range a.shards {
+		s.mutex.RLock()
+		keys = append(keys, s.scan(r)...)
+		s.mutex.RUnlock()
+	}
+	slices.Sort(keys)
+	return r.Paginate(keys), nil
+}
+
+// ShardSizes returns the number of keys in each shard, which shows how evenly the keys are distributed.
+func (a *ObjectStore) ShardSizes() []int {
+	sizes := make([]int, len(a.shards))
+	for i, s := range a.shards {
+		s.mutex.RLock()
+		sizes[i] = len(s.items)
+		s.mutex.RUnlock()
+	}
+	return sizes
+}
+
+// Sweep removes the expired keys of all shards every interval until the context is done.
+// The shards are locked one after another, so that the sweeper does not block the whole store.
+// fn is called while the shard is locked, thus a logged deletion precedes any later write of the key.
+func (a *ObjectStore) Sweep(ctx context.Context, interval time.Duration, fn func(key string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, s := range a.shards {
+				s.sweep(now, fn)
+			}
+		}
+	}
+}
+
+// index returns the index of the shard which is responsible for the key.
+func (a *ObjectStore) index(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(a.shards)))
+}
+
+// shard returns the shard which is responsible for the key.
+func (a *ObjectStore) shard(key string) *shard {
+	return a.shards[a.index(key)]
+}
+
+// sweep removes the keys of the shard which expired before now and calls fn with each of them,
+// before the shard is unlocked.
+func (s *shard) sweep(now time.Time, fn func(key string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, at := range s.expiries {
+		if at.After(now) {
+			continue
+		}
+		s.remove(key)
+		fn(key)
+	}
+}
+
+// remove removes the key together with its expiry. It must be called with the lock held.
+func (s *shard) remove(key string) {
+	delete(s.items, key)
+	delete(s.expiries, key)
+	s.keys.remove(key)
+}
+
+// scan returns the keys of the shard which are selected by the range in ascending order.
+// Only one key more than the limit is taken, which is enough to tell whether there is a next page.
+// It must be called with the lock held.
+func (s *shard) scan(r ports.Range[string]) (keys []string) {
+	for n := s.keys.seek(max(r.After, r.Prefix, r.Start)); n != nil; n = n.next[0] {
+		if (r.End != "" && n.key >= r.End) || !strings.HasPrefix(n.key, r.Prefix) {
+			break
+		}
+		if !r.Contains(n.key) {
+			continue
+		}
+		keys = append(keys, n.key)
+		if r.Limit > 0 && len(keys) > r.Limit {
+			break
+		}
+	}
+	return keys
+}
+
+// set sets the value of the key and removes its expiry. It must be called with the lock held.
+func (s *shard) set(key, value string) {
+	if _, exists := s.items[key]; !exists {
+		s.keys.insert(key)
+	}
+	s.items[key] = value
+	delete(s.expiries, key)
+}
@@ -0,0 +1,252 @@
+package inmemory_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// newStore creates a store with several shards which contains the given keys.
+func newStore(keys ...string) ports.ObjectPort[string, string] {
+	store := inmemory.NewObjectStore(4)
+	for _, key := range keys {
+		_ = store.Put(context.Background(), key, "value")
+	}
+	return store
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore()
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, inmemory.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Scan_All_Shards_In_Order(t *testing.T) {
+	store := newStore("d", "b", "a", "e", "c")
+
+	page, err := store.Scan(context.Background(), ports.Range[string]{})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "keys must be sorted", page.Keys, []string{"a", "b", "c", "d", "e"})
+	assert.That(t, "next must be empty", page.Next, "")
+}
+
+func TestObjectStore_Scan_Prefix_And_Range(t *testing.T) {
+	store := newStore("user/1", "user/2", "user/3", "group/1")
+
+	page, _ := store.Scan(context.Background(), ports.Range[string]{Prefix: "user/"})
+	assert.That(t, "keys must have the prefix", page.Keys, []string{"user/1", "user/2", "user/3"})
+
+	page, _ = store.Scan(context.Background(), ports.Range[string]{Start: "user/2", End: "user/3"})
+	assert.That(t, "keys must be in the range", page.Keys, []string{"user/2"})
+}
+
+func TestObjectStore_Scan_Pages(t *testing.T) {
+	ctx := context.Background()
+	store := newStore("a", "b", "c", "d", "e")
+
+	page, _ := store.Scan(ctx, ports.Range[string]{Limit: 2})
+	assert.That(t, "first page must have 2 keys", page.Keys, []string{"a", "b"})
+	assert.That(t, "cursor must be the last key", page.Next, "b")
+
+	page, _ = store.Scan(ctx, ports.Range[string]{After: page.Next, Limit: 2})
+	assert.That(t, "second page must have 2 keys", page.Keys, []string{"c", "d"})
+
+	page, _ = store.Scan(ctx, ports.Range[string]{After: page.Next, Limit: 2})
+	assert.That(t, "last page must have 1 key", page.Keys, []string{"e"})
+	assert.That(t, "cursor must be empty", page.Next, "")
+}
+
+func TestObjectStore_Scan_Removed_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore("a", "b", "c", "d", "e", "f")
+	_ = store.Delete(ctx, "b")
+	_ = store.CompareAndDelete(ctx, "d", "value")
+	_ = store.(ports.BatchPort[string, string]).Apply(ctx, []ports.Write[string, string]{{Key: "e", Old: "value"}})
+	// Writing a key again must not add it to the index twice.
+	_ = store.Put(ctx, "a", "other")
+
+	page, _ := store.Scan(ctx, ports.Range[string]{Limit: 2})
+	assert.That(t, "first page must skip the removed keys", page.Keys, []string{"a", "c"})
+	page, _ = store.Scan(ctx, ports.Range[string]{After: page.Next, Limit: 2})
+	assert.That(t, "last page must skip the removed keys", page, ports.Page[string]{Keys: []string{"f"}})
+}
+
+func TestObjectStore_Scan_Pages_Of_Many_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore()
+	var want []string
+	for i := range 1000 {
+		key := fmt.Sprintf("key/%04d", i)
+		_ = store.Put(ctx, key, "value")
+		want = append(want, key)
+	}
+
+	var keys []string
+	r := ports.Range[string]{Prefix: "key/", Limit: 7}
+	for {
+		page, err := store.Scan(ctx, r)
+		assert.That(t, "err must be nil", err, nil)
+		keys = append(keys, page.Keys...)
+		if page.Next == "" {
+			break
+		}
+		r.After = page.Next
+	}
+	assert.That(t, "pages must contain every key once in order", keys, want)
+}
+
+func TestObjectStore_CompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	store := newStore()
+
+	err := store.CompareAndSwap(ctx, "foo", "", "v1")
+	assert.That(t, "create must succeed", err, nil)
+
+	err = store.CompareAndSwap(ctx, "foo", "", "v2")
+	assert.That(t, "create of existing key must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndSwap(ctx, "foo", "other", "v2")
+	assert.That(t, "swap of changed value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndSwap(ctx, "foo", "v1", "v2")
+	assert.That(t, "swap must succeed", err, nil)
+
+	value, _ := store.Get(ctx, "foo")
+	assert.That(t, "value must be 'v2'", value, "v2")
+}
+
+func TestObjectStore_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore()
+	_ = store.Put(ctx, "foo", "v1")
+
+	err := store.CompareAndDelete(ctx, "foo", "other")
+	assert.That(t, "delete of changed value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "v1")
+	assert.That(t, "delete must succeed", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "key must not exist", err, ports.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Expire_Sweep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := inmemory.NewObjectStore(2)
+	expiring := store.(ports.ExpiringPort[string, string])
+	_ = store.Put(ctx, "expired", "v1")
+	_ = store.Put(ctx, "rewritten", "v1")
+	_ = store.Put(ctx, "later", "v1")
+
+	err := expiring.Expire(ctx, "expired", "other", time.Now())
+	assert.That(t, "expire of changed value must fail", err, ports.ErrorValueChanged)
+
+	_ = expiring.Expire(ctx, "expired", "v1", time.Now())
+	_ = expiring.Expire(ctx, "rewritten", "v1", time.Now())
+	_ = expiring.Expire(ctx, "later", "v1", time.Now().Add(time.Hour))
+
+	// Writing a key removes its expiry.
+	_ = store.Put(ctx, "rewritten", "v2")
+
+	removed := make(chan string, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		expiring.Sweep(ctx, time.Millisecond, func(key string) { removed <- key })
+	}()
+	assert.That(t, "expired key must be removed", <-removed, "expired")
+	cancel()
+	<-done
+
+	page, _ := store.Scan(context.Background(), ports.Range[string]{})
+	assert.That(t, "other keys must remain", page.Keys, []string{"later", "rewritten"})
+}
+
+func TestObjectStore_Sweep_Before_Write(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := inmemory.NewObjectStore(1)
+	expiring := store.(ports.ExpiringPort[string, string])
+	_ = store.Put(ctx, "foo", "v1")
+	_ = expiring.Expire(ctx, "foo", "v1", time.Now())
+
+	// A concurrent write of the swept key must not be completed before the deletion is reported.
+	var mutex sync.Mutex
+	var events []string
+	written := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		expiring.Sweep(ctx, time.Millisecond, func(key string) {
+			go func() {
+				_ = store.Put(context.Background(), key, "v2")
+				mutex.Lock()
+				events = append(events, "put")
+				mutex.Unlock()
+				close(written)
+			}()
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			events = append(events, "delete")
+			mutex.Unlock()
+		})
+	}()
+	<-written
+	cancel()
+	<-done
+
+	assert.That(t, "deletion must be reported before the write", events, []string{"delete", "put"})
+	value, _ := store.Get(context.Background(), "foo")
+	assert.That(t, "value must be the new one", value, "v2")
+}
+
+func TestObjectStore_Apply_All_Or_Nothing(t *testing.T) {
+	ctx := context.Background()
+	store := inmemory.NewObjectStore(4)
+	batch := store.(ports.BatchPort[string, string])
+	_ = store.Put(ctx, "a", "v1")
+	_ = store.Put(ctx, "b", "v1")
+
+	err := batch.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "v1", Value: "v2"},
+		{Key: "b", Old: "other", Value: ""},
+	})
+	assert.That(t, "batch with changed value must fail", err, ports.ErrorValueChanged)
+	value, _ := store.Get(ctx, "a")
+	assert.That(t, "value must be unchanged", value, "v1")
+
+	err = batch.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "v1", Value: "v2"},
+		{Key: "b", Old: "v1", Value: ""},
+		{Key: "c", Old: "", Value: "v1"},
+	})
+	assert.That(t, "batch must succeed", err, nil)
+
+	page, _ := store.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "keys must be changed", page.Keys, []string{"a", "c"})
+	value, _ = store.Get(ctx, "a")
+	assert.That(t, "value must be 'v2'", value, "v2")
+}
+
+func TestObjectStore_ShardSizes(t *testing.T) {
+	store := newStore("a", "b", "c", "d", "e").(*inmemory.ObjectStore)
+
+	sizes := store.ShardSizes()
+	assert.That(t, "must have a size per shard", len(sizes), 4)
+	assert.That(t, "sizes must add up to the keys", sizes[0]+sizes[1]+sizes[2]+sizes[3], 5)
+}
@@ -0,0 +1,85 @@
+package keys
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+var (
+	// ErrorInsecureKeyFile is returned when the key file can be accessed by other users.
+	ErrorInsecureKeyFile = errors.New("key file must only be accessible by its owner")
+)
+
+// keyFile is the content of the file which holds the master keys.
+// The keys are hex encoded. Retired keys are kept to unwrap existing data keys.
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// FileProvider is a key provider which reads its master keys from a local file.
+// It implements the ports.KeyProvider interface.
+type FileProvider struct {
+	active string
+	keys   map[string][32]byte
+}
+
+// NewFileProvider reads the master keys from the JSON file at the given path, e.g.
+// {"active": "k2", "keys": {"k1": "<hex>", "k2": "<hex>"}}.
+// The file must not be accessible by other users than its owner.
+func NewFileProvider(path string) (ports.KeyProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, ErrorInsecureKeyFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	a := &FileProvider{active: file.Active, keys: make(map[string][32]byte)}
+	for id, encoded := range file.Keys {
+		key, err := hex.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid master key %q", id)
+		}
+		a.keys[id] = [32]byte(key)
+	}
+	if _, ok := a.keys[a.active]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownKey, a.active)
+	}
+	return a, nil
+}
+
+// KeyID returns the ID of the active master key.
+func (a *FileProvider) KeyID() string {
+	return a.active
+}
+
+// Unwrap decrypts a data key with the master key of the given ID.
+func (a *FileProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) (dataKey []byte, err error) {
+	masterKey, ok := a.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownKey, keyID)
+	}
+	return unwrap(masterKey, keyID, wrapped)
+}
+
+// Wrap encrypts a data key with the active master key.
+func (a *FileProvider) Wrap(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	return wrap(a.keys[a.active], a.active, dataKey)
+}
@@ -0,0 +1,81 @@
+package keys_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/keys"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+const (
+	hexKey1 = "0a0375de7bd186c2f8d80ef94e5f3d357462f594ca6785d4779f52bcb2b65b85"
+	hexKey2 = "1b1486ef8ce297d3f9e91ffa5f604e468573f6a5db7896e5880f63cdc3c76c96"
+)
+
+// writeKeyFile writes the content to a key file with the given permissions and returns its path.
+func writeKeyFile(t *testing.T, content string, perm os.FileMode) string {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileProvider_Wrap_Unwrap(t *testing.T) {
+	ctx := context.Background()
+	path := writeKeyFile(t, `{"active":"k1","keys":{"k1":"`+hexKey1+`"}}`, 0600)
+	provider, err := keys.NewFileProvider(path)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "key ID must be k1", provider.KeyID(), "k1")
+
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := provider.Wrap(ctx, dataKey)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "wrapped key must differ from the data key", string(wrapped) != string(dataKey), true)
+
+	unwrapped, err := provider.Unwrap(ctx, "k1", wrapped)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "unwrapped key must be the data key", string(unwrapped), string(dataKey))
+}
+
+func TestFileProvider_Rotation(t *testing.T) {
+	ctx := context.Background()
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	old, _ := keys.NewFileProvider(writeKeyFile(t, `{"active":"k1","keys":{"k1":"`+hexKey1+`"}}`, 0600))
+	wrapped, _ := old.Wrap(ctx, dataKey)
+
+	provider, err := keys.NewFileProvider(writeKeyFile(t, `{"active":"k2","keys":{"k1":"`+hexKey1+`","k2":"`+hexKey2+`"}}`, 0600))
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "key ID must be k2", provider.KeyID(), "k2")
+
+	unwrapped, err := provider.Unwrap(ctx, "k1", wrapped)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "unwrapped key must be the data key", string(unwrapped), string(dataKey))
+
+	_, err = provider.Unwrap(ctx, "k2", wrapped)
+	assert.That(t, "err must be ErrorUnwrapFailed", errors.Is(err, keys.ErrorUnwrapFailed), true)
+
+	_, err = provider.Unwrap(ctx, "k3", wrapped)
+	assert.That(t, "err must be ErrorUnknownKey", errors.Is(err, keys.ErrorUnknownKey), true)
+}
+
+func TestFileProvider_Insecure_Permissions(t *testing.T) {
+	path := writeKeyFile(t, `{"active":"k1","keys":{"k1":"`+hexKey1+`"}}`, 0644)
+
+	_, err := keys.NewFileProvider(path)
+	assert.That(t, "err must be ErrorInsecureKeyFile", err, keys.ErrorInsecureKeyFile)
+}
+
+func TestFileProvider_Unknown_Active_Key(t *testing.T) {
+	path := writeKeyFile(t, `{"active":"k2","keys":{"k1":"`+hexKey1+`"}}`, 0600)
+
+	_, err := keys.NewFileProvider(path)
+	assert.That(t, "err must be ErrorUnknownKey", errors.Is(err, keys.ErrorUnknownKey), true)
+}
@@ -0,0 +1,109 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// kmsRequest is the body of a wrap or unwrap request of the KMS API.
+// The data is base64 encoded by encoding/json.
+type kmsRequest struct {
+	Data []byte `json:"data"`
+}
+
+// kmsResponse is the body of a successful wrap or unwrap response of the KMS API.
+type kmsResponse struct {
+	Data []byte `json:"data"`
+}
+
+// KMSProvider is a key provider which lets a KMS-style HTTP service wrap and unwrap the data keys.
+// The master keys never leave the service. It uses the endpoints
+// POST {url}/v1/keys/{id}/wrap and POST {url}/v1/keys/{id}/unwrap.
+// It implements the ports.KeyProvider interface.
+type KMSProvider struct {
+	client *http.Client
+	keyID  string
+	token  string
+	url    string
+}
+
+// NewKMSProvider creates a provider for the KMS at the given base URL,
+// which wraps new data keys with the master key of the given ID.
+func NewKMSProvider(url, keyID string) *KMSProvider {
+	return &KMSProvider{
+		client: http.DefaultClient,
+		keyID:  keyID,
+		url:    strings.TrimSuffix(url, "/"),
+	}
+}
+
+// KeyID returns the ID of the master key which wraps new data keys.
+func (a *KMSProvider) KeyID() string {
+	return a.keyID
+}
+
+// Unwrap lets the KMS decrypt a data key with the master key of the given ID.
+func (a *KMSProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) (dataKey []byte, err error) {
+	return a.call(ctx, keyID, "unwrap", wrapped)
+}
+
+// Wrap lets the KMS encrypt a data key with the master key returned by KeyID.
+func (a *KMSProvider) Wrap(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	return a.call(ctx, a.keyID, "wrap", dataKey)
+}
+
+// WithClient sets the HTTP client which is used to call the KMS.
+func (a *KMSProvider) WithClient(client *http.Client) *KMSProvider {
+	a.client = client
+	return a
+}
+
+// WithToken sets the bearer token which authenticates the calls to the KMS.
+func (a *KMSProvider) WithToken(token string) *KMSProvider {
+	a.token = token
+	return a
+}
+
+// call sends the data to the operation endpoint of the master key and returns the result.
+func (a *KMSProvider) call(ctx context.Context, keyID, operation string, data []byte) ([]byte, error) {
+	body, err := json.Marshal(kmsRequest{Data: data})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/v1/keys/%s/%s", a.url, url.PathEscape(keyID), operation)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownKey, keyID)
+	case http.StatusUnprocessableEntity:
+		return nil, ErrorUnwrapFailed
+	default:
+		return nil, fmt.Errorf("kms %s failed with status %d", operation, res.StatusCode)
+	}
+
+	var result kmsResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
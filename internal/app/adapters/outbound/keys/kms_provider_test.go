@@ -0,0 +1,51 @@
+package keys_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/keys"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// newKMS starts a stand-in KMS with a single master key, which requires the token.
+func newKMS(t *testing.T, token string) *httptest.Server {
+	var masterKey [32]byte
+	copy(masterKey[:], "master key of the stand-in kms!!")
+	server := httptest.NewServer(keys.NewKMSServer(map[string][32]byte{"kms-1": masterKey}).WithToken(token))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestKMSProvider_Wrap_Unwrap(t *testing.T) {
+	ctx := context.Background()
+	server := newKMS(t, "secret")
+	provider := keys.NewKMSProvider(server.URL, "kms-1").WithClient(server.Client()).WithToken("secret")
+
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := provider.Wrap(ctx, dataKey)
+	assert.That(t, "err must be nil", err, nil)
+
+	unwrapped, err := provider.Unwrap(ctx, "kms-1", wrapped)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "unwrapped key must be the data key", string(unwrapped), string(dataKey))
+}
+
+func TestKMSProvider_Errors(t *testing.T) {
+	ctx := context.Background()
+	server := newKMS(t, "secret")
+	provider := keys.NewKMSProvider(server.URL, "kms-1").WithClient(server.Client()).WithToken("secret")
+	wrapped, _ := provider.Wrap(ctx, []byte("0123456789abcdef0123456789abcdef"))
+
+	_, err := provider.Unwrap(ctx, "kms-2", wrapped)
+	assert.That(t, "err must be ErrorUnknownKey", errors.Is(err, keys.ErrorUnknownKey), true)
+
+	wrapped[len(wrapped)-1] ^= 0xff
+	_, err = provider.Unwrap(ctx, "kms-1", wrapped)
+	assert.That(t, "err must be ErrorUnwrapFailed", errors.Is(err, keys.ErrorUnwrapFailed), true)
+
+	_, err = keys.NewKMSProvider(server.URL, "kms-1").WithClient(server.Client()).Wrap(ctx, []byte("key"))
+	assert.That(t, "err must not be nil without token", err != nil, true)
+}
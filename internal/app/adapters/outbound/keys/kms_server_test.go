@@ -0,0 +1,69 @@
+package keys
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// KMSServer is a local stand-in of a KMS-style HTTP service, which holds the master keys
+// and wraps and unwraps data keys with them. It serves the API used by the KMSProvider
+// and is only compiled into the tests of the package.
+type KMSServer struct {
+	keys  map[string][32]byte
+	token string
+}
+
+// NewKMSServer creates a stand-in KMS with the given master keys by ID.
+func NewKMSServer(keys map[string][32]byte) *KMSServer {
+	return &KMSServer{keys: keys}
+}
+
+// ServeHTTP handles the wrap and unwrap requests.
+func (a *KMSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/keys/{id}/unwrap", a.handle(unwrap))
+	mux.HandleFunc("POST /v1/keys/{id}/wrap", a.handle(wrap))
+	mux.ServeHTTP(w, r)
+}
+
+// WithToken requires the bearer token in every request.
+func (a *KMSServer) WithToken(token string) *KMSServer {
+	a.token = token
+	return a
+}
+
+// handle returns a handler which applies the operation with the master key in the path.
+func (a *KMSServer) handle(operation func(masterKey [32]byte, keyID string, data []byte) ([]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req kmsRequest
+		var res kmsResponse
+
+		if a.token != "" && r.Header.Get("Authorization") != "Bearer "+a.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		keyID := r.PathValue("id")
+		masterKey, ok := a.keys[keyID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := operation(masterKey, keyID, req.Data)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		res.Data = data
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
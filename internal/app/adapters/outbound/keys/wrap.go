@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+var (
+	// ErrorUnknownKey is returned when a master key is not known by the provider.
+	ErrorUnknownKey = ports.ErrorUnknownMasterKey
+	// ErrorUnwrapFailed is returned when a wrapped data key cannot be authenticated.
+	ErrorUnwrapFailed = ports.ErrorUnwrapFailed
+)
+
+// wrap encrypts the data key with the master key using AES-GCM.
+// The ID of the master key is authenticated as well, so that the wrapped key cannot be
+// unwrapped with another master key. The random nonce is prepended to the result.
+func wrap(masterKey [32]byte, keyID string, dataKey []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// unwrap decrypts a data key which was wrapped by wrap.
+func unwrap(masterKey [32]byte, keyID string, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrorUnwrapFailed
+	}
+	nonce, ciphertext := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, ErrorUnwrapFailed
+	}
+	return dataKey, nil
+}
+
+// newGCM returns an AES-256 cipher in Galois/Counter Mode.
+func newGCM(key [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,118 @@
+package lsm
+
+import "math/rand/v2"
+
+// maxLevel is the maximum number of levels of the skip list of a memtable.
+const maxLevel = 16
+
+// entry is the value of a key or a tombstone, which hides the values of the key in older tables.
+type entry struct {
+	deleted bool
+	value   string
+}
+
+// record is an entry together with its key.
+type record struct {
+	entry
+	key string
+}
+
+// iterator returns records in ascending order of their keys.
+type iterator interface {
+	// err returns the error, which ended the iteration early.
+	err() error
+	// next returns the next record or false, if there are no more records or an error occurred.
+	next() (record, bool)
+}
+
+// memtable is a skip list of the latest writes, which are also in the write-ahead log.
+// It is not safe for concurrent use, thus it is protected by the lock of the store.
+type memtable struct {
+	head  *node
+	level int
+	size  int // Approximate number of bytes of the keys and values.
+}
+
+// memtableIterator iterates over the nodes of a memtable.
+type memtableIterator struct {
+	node *node
+}
+
+// node is a node of the skip list with its successors on every level of the node.
+type node struct {
+	entry
+	key  string
+	next []*node
+}
+
+// newMemtable creates an empty memtable.
+func newMemtable() *memtable {
+	return &memtable{head: &node{next: make([]*node, maxLevel)}, level: 1}
+}
+
+// get returns the entry of the key and whether the memtable contains the key.
+func (m *memtable) get(key string) (entry, bool) {
+	n := m.find(key, nil)
+	if n == nil || n.key != key {
+		return entry{}, false
+	}
+	return n.entry, true
+}
+
+// put sets the entry of the key.
+func (m *memtable) put(key string, e entry) {
+	var update [maxLevel]*node
+	n := m.find(key, &update)
+	m.size += len(key) + len(e.value)
+	if n != nil && n.key == key {
+		n.entry = e
+		return
+	}
+
+	level := 1
+	for level < maxLevel && rand.Uint32()&3 == 0 {
+		level++
+	}
+	for i := m.level; i < level; i++ {
+		update[i] = m.head
+	}
+	m.level = max(m.level, level)
+	n = &node{entry: e, key: key, next: make([]*node, level)}
+	for i := range level {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+}
+
+// seek returns an iterator, which starts at the first key greater than or equal to the key.
+func (m *memtable) seek(key string) *memtableIterator {
+	return &memtableIterator{node: m.find(key, nil)}
+}
+
+// find returns the first node whose key is greater than or equal to the key.
+// The predecessors of the node on each level are stored in update, if it is not nil.
+func (m *memtable) find(key string, update *[maxLevel]*node) *node {
+	n := m.head
+	for i := m.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if update != nil {
+			update[i] = n
+		}
+	}
+	return n.next[0]
+}
+
+func (it *memtableIterator) err() error {
+	return nil
+}
+
+func (it *memtableIterator) next() (record, bool) {
+	if it.node == nil {
+		return record{}, false
+	}
+	r := record{entry: it.node.entry, key: it.node.key}
+	it.node = it.node.next[0]
+	return r, true
+}
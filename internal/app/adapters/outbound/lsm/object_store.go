@@ -0,0 +1,631 @@
+package lsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+const (
+	// compactionThreshold is the number of adjacent tables of the same tier, which are merged into a single table
+	// by the compaction. It is also the factor by which the size of a table grows from one tier to the next one.
+	compactionThreshold = 4
+	// defaultMemtableSize is the number of bytes of the memtable, after which it is flushed to a table.
+	defaultMemtableSize = 4 << 20
+	// manifestName is the name of the file, which lists the tables of the store.
+	manifestName = "MANIFEST"
+)
+
+var (
+	// ErrorClosed is returned when the store is used after it has been closed.
+	ErrorClosed = errors.New("store is closed")
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+)
+
+// ObjectStore is an embedded storage engine, which is organized as a log-structured merge tree.
+// Every write is appended to a write-ahead log and applied to a sorted memtable. A full memtable
+// is flushed to an immutable table file in the background. The tables are compacted by size tiers
+// in another background task, which merges adjacent tables of a similar size, thus every write
+// is only rewritten once per tier. A read checks the memtables and then the tables from the newest
+// to the oldest one. The conditional writes are atomic, because all writes are serialized by the
+// lock of the store.
+// It implements the ports.ObjectPort and ports.BatchPort interfaces.
+type ObjectStore struct {
+	buf          []byte     // Buffer of the frames of the write-ahead log.
+	cond         *sync.Cond // Signals that the immutable memtable has been flushed.
+	done         chan struct{}
+	err          error     // Error of the background work or the log, which fails all further writes.
+	imm          *memtable // Full memtable, which is flushed to a table in the background.
+	immLog       string    // Path of the log of the immutable memtable.
+	log          *os.File
+	mem          *memtable
+	memtableSize int
+	mutex        sync.RWMutex
+	next         int // Number of the next file.
+	path         string
+	sync         bool
+	tables       []*table // Tables from the newest to the oldest one.
+	work         chan struct{}
+}
+
+// manifest lists the tables of the store from the newest to the oldest one.
+// It is replaced atomically, thus a table is either completely part of the store or not at all.
+type manifest struct {
+	Next   int      `json:"next"`
+	Tables []string `json:"tables"`
+}
+
+// mergeIterator merges the records of several iterators, which are ordered from the newest to the oldest one.
+// If several iterators contain a key, the record of the newest one is returned.
+type mergeIterator struct {
+	heads []*record
+	iters []iterator
+}
+
+// NewObjectStore opens the store in the configured directory, which is created if it does not exist.
+// The writes in the logs of a previous run are recovered and flushed to a table.
+// Each write is synced to the disk before it is acknowledged, unless the configuration disables it.
+func NewObjectStore(cfg config.PortLSM) (*ObjectStore, error) {
+	if err := os.MkdirAll(cfg.Path, 0700); err != nil {
+		return nil, err
+	}
+	a := &ObjectStore{
+		done:         make(chan struct{}),
+		mem:          newMemtable(),
+		memtableSize: cfg.MemtableSize,
+		path:         cfg.Path,
+		sync:         cfg.Sync,
+		work:         make(chan struct{}, 1),
+	}
+	a.cond = sync.NewCond(&a.mutex)
+	if a.memtableSize <= 0 {
+		a.memtableSize = defaultMemtableSize
+	}
+	if err := a.recover(); err != nil {
+		for _, t := range a.tables {
+			_ = t.close()
+		}
+		return nil, err
+	}
+	go a.run()
+	return a, nil
+}
+
+// Apply applies either all writes or none of them.
+// Every write is checked before the first one is applied and all of them are written in a single frame of the log.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	records := make([]record, 0, len(writes))
+	for _, w := range writes {
+		if err := a.check(w.Key, w.Old); err != nil {
+			return err
+		}
+		records = append(records, record{entry: entry{deleted: w.Value == "", value: w.Value}, key: w.Key})
+	}
+	return a.write(records...)
+}
+
+// Close waits for the background work and closes the files of the store.
+func (a *ObjectStore) Close() error {
+	a.mutex.Lock()
+	if errors.Is(a.err, ErrorClosed) {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.err = ErrorClosed
+	close(a.work)
+	a.cond.Broadcast()
+	a.mutex.Unlock()
+	<-a.done
+
+	err := a.log.Close()
+	for _, t := range a.tables {
+		err = errors.Join(err, t.close())
+	}
+	return err
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if current, exists, err := a.get(key); err != nil {
+		return err
+	} else if !exists || current != old {
+		return ports.ErrorValueChanged
+	}
+	return a.write(record{entry: entry{deleted: true}, key: key})
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.check(key, old); err != nil {
+		return err
+	}
+	return a.write(record{entry: entry{value: value}, key: key})
+}
+
+// Delete removes a key and its associated value from the store by writing a tombstone.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.write(record{entry: entry{deleted: true}, key: key})
+}
+
+// Get retrieves the value associated with the given key.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	value, exists, err := a.get(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrorKeyDoesNotExist
+	}
+	return value, nil
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.write(record{entry: entry{value: value}, key: key})
+}
+
+// Scan returns the keys which are selected by the range in ascending order.
+// It merges the memtables and the tables from the first key of the range, until the range or the page ends.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	from := max(r.Start, r.Prefix, r.After)
+	iters := []iterator{a.mem.seek(from)}
+	if a.imm != nil {
+		iters = append(iters, a.imm.seek(from))
+	}
+	for _, t := range a.tables {
+		iters = append(iters, t.seek(from))
+	}
+	it := newMergeIterator(iters)
+
+	var keys []string
+	for {
+		rec, ok := it.next()
+		if !ok || (r.End != "" && rec.key >= r.End) || !strings.HasPrefix(rec.key, r.Prefix) {
+			break
+		}
+		if rec.deleted || !r.Contains(rec.key) {
+			continue
+		}
+		// Only one key more than the limit is required to know whether there is a next page.
+		keys = append(keys, rec.key)
+		if r.Limit > 0 && len(keys) > r.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return page, err
+		}
+	}
+	if err := it.err(); err != nil {
+		return page, err
+	}
+	return r.Paginate(keys), nil
+}
+
+// check returns ErrorValueChanged, if the current value of the key does not equal old.
+// An empty old value requires the key to not exist. It must be called with the lock held.
+func (a *ObjectStore) check(key, old string) error {
+	current, exists, err := a.get(key)
+	if err != nil {
+		return err
+	}
+	if exists != (old != "") || current != old {
+		return ports.ErrorValueChanged
+	}
+	return nil
+}
+
+// compact merges the runs of adjacent tables of the same tier into a single table of the next tier,
+// until no run has compactionThreshold tables. The tombstones are dropped, if a run contains the oldest table.
+// The tables which are flushed in the meantime are newer than the merged table, thus they are kept in front of it.
+func (a *ObjectStore) compact() error {
+	for {
+		a.mutex.Lock()
+		tables := slices.Clone(a.tables)
+		a.mutex.Unlock()
+		from, to := a.compactionRun(tables)
+		if to-from < compactionThreshold {
+			return nil
+		}
+
+		a.mutex.Lock()
+		number := a.number()
+		a.mutex.Unlock()
+		iters := make([]iterator, 0, to-from)
+		for _, t := range tables[from:to] {
+			iters = append(iters, t.seek(""))
+		}
+		merged, err := writeTable(a.file(number, ".sst"), newMergeIterator(iters), to == len(tables))
+		if err != nil {
+			return err
+		}
+
+		a.mutex.Lock()
+		flushed := len(a.tables) - len(tables)
+		a.tables = slices.Replace(a.tables, flushed+from, flushed+to, merged)
+		err = a.saveManifest()
+		a.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		for _, t := range tables[from:to] {
+			_ = t.close()
+			_ = os.Remove(t.path)
+		}
+	}
+}
+
+// compactionRun returns the first run of adjacent tables of the same tier from the newest to the oldest one,
+// which has at least compactionThreshold tables, or the last run otherwise.
+func (a *ObjectStore) compactionRun(tables []*table) (from, to int) {
+	for from < len(tables) {
+		to = from + 1
+		for to < len(tables) && a.tier(tables[to]) == a.tier(tables[from]) {
+			to++
+		}
+		if to-from >= compactionThreshold {
+			return from, to
+		}
+		from = to
+	}
+	return from, to
+}
+
+// fail stops all further writes, because the background work failed.
+func (a *ObjectStore) fail(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.err == nil {
+		a.err = fmt.Errorf("lsm: %w", err)
+	}
+	a.cond.Broadcast()
+}
+
+// file returns the path of the file with the number and the extension.
+func (a *ObjectStore) file(number int, ext string) string {
+	return filepath.Join(a.path, fmt.Sprintf("%06d%s", number, ext))
+}
+
+// flush writes the immutable memtable to a new table and removes its log afterwards.
+func (a *ObjectStore) flush() error {
+	a.mutex.Lock()
+	imm, immLog, number := a.imm, a.immLog, a.number()
+	a.mutex.Unlock()
+	if imm == nil {
+		return nil
+	}
+
+	t, err := writeTable(a.file(number, ".sst"), imm.seek(""), false)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tables = append([]*table{t}, a.tables...)
+	if err := a.saveManifest(); err != nil {
+		return err
+	}
+	a.imm, a.immLog = nil, ""
+	a.cond.Broadcast()
+	// The removal must be durable before the next table is flushed, because the writes of the log
+	// would otherwise be recovered on top of the newer writes after a crash.
+	if err := os.Remove(immLog); err != nil {
+		return err
+	}
+	return syncDir(a.path)
+}
+
+// get returns the current value of the key from the newest memtable or table, which contains the key.
+func (a *ObjectStore) get(key string) (string, bool, error) {
+	if e, found := a.mem.get(key); found {
+		return e.value, !e.deleted, nil
+	}
+	if a.imm != nil {
+		if e, found := a.imm.get(key); found {
+			return e.value, !e.deleted, nil
+		}
+	}
+	for _, t := range a.tables {
+		e, found, err := t.get(key)
+		if err != nil {
+			return "", false, err
+		}
+		if found {
+			return e.value, !e.deleted, nil
+		}
+	}
+	return "", false, nil
+}
+
+// number returns the number of the next file. It must be called with the lock held.
+func (a *ObjectStore) number() int {
+	a.next++
+	return a.next - 1
+}
+
+// openLog creates a new log for the memtable, whose directory entry is synced before the first write.
+// It must be called with the lock held.
+func (a *ObjectStore) openLog() error {
+	log, err := os.OpenFile(a.file(a.number(), ".log"), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(a.path); err != nil {
+		_ = log.Close()
+		return err
+	}
+	a.log = log
+	return nil
+}
+
+// recover opens the tables of the manifest, removes the files of interrupted flushes and compactions
+// and flushes the writes of the remaining logs to a new table.
+func (a *ObjectStore) recover() error {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(a.path, manifestName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+	}
+	a.next = m.Next
+	for _, name := range m.Tables {
+		t, err := openTable(filepath.Join(a.path, name))
+		if err != nil {
+			return err
+		}
+		a.tables = append(a.tables, t)
+	}
+
+	entries, err := os.ReadDir(a.path)
+	if err != nil {
+		return err
+	}
+	var logs []string
+	for _, e := range entries {
+		name := e.Name()
+		number, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name)))
+		switch {
+		case err == nil && filepath.Ext(name) == ".log":
+			logs = append(logs, filepath.Join(a.path, name))
+			a.next = max(a.next, number+1)
+		case err == nil && filepath.Ext(name) == ".sst" && !slices.Contains(m.Tables, name):
+			// The table of an interrupted flush or the merged table of an interrupted compaction.
+			_ = os.Remove(filepath.Join(a.path, name))
+		case strings.HasSuffix(name, ".tmp"):
+			_ = os.Remove(filepath.Join(a.path, name))
+		}
+	}
+
+	// The names of the logs are sorted by their numbers, which is the order of the writes.
+	for _, log := range logs {
+		if err := replayLog(log, func(r record) { a.mem.put(r.key, r.entry) }); err != nil {
+			return err
+		}
+	}
+	if a.mem.size > 0 {
+		t, err := writeTable(a.file(a.number(), ".sst"), a.mem.seek(""), false)
+		if err != nil {
+			return err
+		}
+		a.tables = append([]*table{t}, a.tables...)
+		a.mem = newMemtable()
+	}
+	if err := a.saveManifest(); err != nil {
+		return err
+	}
+	for _, log := range logs {
+		if err := os.Remove(log); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(a.path); err != nil {
+		return err
+	}
+	return a.openLog()
+}
+
+// rotate replaces the full memtable and its log by new ones, after the previous memtable has been flushed.
+// It must be called with the lock held. If the previous memtable is still being flushed, all writes stall
+// until it is written to its table. The stall is bounded by the size of a memtable, because the flush
+// never waits for a compaction.
+func (a *ObjectStore) rotate() error {
+	for a.imm != nil && a.err == nil {
+		a.cond.Wait()
+	}
+	if a.err != nil {
+		return a.err
+	}
+	full := a.log
+	if err := a.openLog(); err != nil {
+		return err
+	}
+	a.imm, a.immLog, a.mem = a.mem, full.Name(), newMemtable()
+	if err := full.Close(); err != nil {
+		return err
+	}
+	select {
+	case a.work <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run flushes the immutable memtable, whenever a memtable is full, and compacts the tables in another
+// goroutine after each flush, so that a long compaction does not delay the flushes.
+func (a *ObjectStore) run() {
+	defer close(a.done)
+	compactions := make(chan struct{}, 1)
+	compacted := make(chan struct{})
+	go func() {
+		defer close(compacted)
+		for range compactions {
+			if err := a.compact(); err != nil {
+				a.fail(err)
+			}
+		}
+	}()
+
+	// The tables of a previous run may already have to be compacted.
+	compactions <- struct{}{}
+	for range a.work {
+		if err := a.flush(); err != nil {
+			a.fail(err)
+			continue
+		}
+		select {
+		case compactions <- struct{}{}:
+		default:
+		}
+	}
+	close(compactions)
+	<-compacted
+}
+
+// saveManifest replaces the manifest by the current tables. It must be called with the lock held.
+func (a *ObjectStore) saveManifest() error {
+	m := manifest{Next: a.next, Tables: []string{}}
+	for _, t := range a.tables {
+		m.Tables = append(m.Tables, filepath.Base(t.path))
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.path, manifestName)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(a.path)
+}
+
+// tier returns the size class of the table. The tables of the first tier are smaller than two memtables,
+// and each further tier holds tables which are up to compactionThreshold times larger.
+func (a *ObjectStore) tier(t *table) int {
+	tier := 0
+	for limit := 2 * int64(a.memtableSize); t.end >= limit; limit *= compactionThreshold {
+		tier++
+	}
+	return tier
+}
+
+// write appends the records to the log and applies them to the memtable, which is rotated when it is full.
+// A failed write to the log stops all further writes, because the end of the log may be torn.
+// It must be called with the lock held.
+func (a *ObjectStore) write(records ...record) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.buf = appendFrame(a.buf[:0], records)
+	if _, err := a.log.Write(a.buf); err != nil {
+		a.err = err
+		return err
+	}
+	if a.sync {
+		if err := a.log.Sync(); err != nil {
+			a.err = err
+			return err
+		}
+	}
+	for _, r := range records {
+		a.mem.put(r.key, r.entry)
+	}
+	if a.mem.size >= a.memtableSize {
+		// The records are already durable, thus a failed rotation only fails the following writes.
+		if err := a.rotate(); err != nil && a.err == nil {
+			a.err = err
+		}
+	}
+	return nil
+}
+
+// newMergeIterator creates an iterator, which merges the iterators ordered from the newest to the oldest one.
+func newMergeIterator(iters []iterator) *mergeIterator {
+	m := &mergeIterator{heads: make([]*record, len(iters)), iters: iters}
+	for i := range iters {
+		m.advance(i)
+	}
+	return m
+}
+
+// advance reads the next record of the iterator with the index.
+func (m *mergeIterator) advance(i int) {
+	if r, ok := m.iters[i].next(); ok {
+		m.heads[i] = &r
+	} else {
+		m.heads[i] = nil
+	}
+}
+
+func (m *mergeIterator) err() error {
+	for _, it := range m.iters {
+		if err := it.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mergeIterator) next() (record, bool) {
+	newest := -1
+	for i, head := range m.heads {
+		if head != nil && (newest < 0 || head.key < m.heads[newest].key) {
+			newest = i
+		}
+	}
+	if newest < 0 || m.err() != nil {
+		return record{}, false
+	}
+	r := *m.heads[newest]
+	// The older records of the key are hidden by the newest one.
+	for i, head := range m.heads {
+		if head != nil && head.key == r.key {
+			m.advance(i)
+		}
+	}
+	return r, true
+}
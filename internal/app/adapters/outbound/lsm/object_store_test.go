@@ -0,0 +1,260 @@
+package lsm_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/lsm"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// newStore opens a store in the directory, whose memtable is flushed after a few writes.
+func newStore(t *testing.T, path string) *lsm.ObjectStore {
+	store, err := lsm.NewObjectStore(config.PortLSM{MemtableSize: 1 << 10, Path: path})
+	assert.That(t, "err must be nil", err, nil)
+	return store
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, t.TempDir())
+	defer store.Close()
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+	err = store.Put(ctx, "foo", "baz")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'baz'", value, "baz")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, lsm.ErrorKeyDoesNotExist)
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "deleting a missing key must not fail", err, nil)
+}
+
+func TestObjectStore_Reopen_Recovers_The_Log(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store := newStore(t, path)
+	_ = store.Put(ctx, "foo", "bar")
+	_ = store.Put(ctx, "baz", "qux")
+	_ = store.Delete(ctx, "baz")
+	assert.That(t, "err must be nil", store.Close(), nil)
+
+	store = newStore(t, path)
+	defer store.Close()
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+	_, err = store.Get(ctx, "baz")
+	assert.That(t, "deleted key must stay deleted", err, lsm.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Reopen_Drops_Torn_Write(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store := newStore(t, path)
+	_ = store.Put(ctx, "foo", "bar")
+	_ = store.Close()
+
+	// A crash in the middle of a write leaves an incomplete frame at the end of the log.
+	logs, _ := filepath.Glob(filepath.Join(path, "*.log"))
+	assert.That(t, "there must be a single log", len(logs), 1)
+	file, _ := os.OpenFile(logs[0], os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = file.Write([]byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 42, 3, 'b'})
+	_ = file.Close()
+
+	store = newStore(t, path)
+	defer store.Close()
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+	err = store.Put(ctx, "baz", "qux")
+	assert.That(t, "store must accept new writes", err, nil)
+}
+
+func TestObjectStore_Flush_And_Compaction(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	store := newStore(t, path)
+
+	// The memtable is flushed many times, thus the tables are compacted, too.
+	for i := range 2000 {
+		_ = store.Put(ctx, fmt.Sprintf("key-%04d", i%500), fmt.Sprintf("value-%d", i))
+	}
+	for i := range 250 {
+		_ = store.Delete(ctx, fmt.Sprintf("key-%04d", i*2))
+	}
+	assert.That(t, "err must be nil", store.Close(), nil)
+
+	// The memtable has been flushed to about 40 tables, of which at most three of each tier remain.
+	tables, _ := filepath.Glob(filepath.Join(path, "*.sst"))
+	assert.That(t, "tables must be compacted", len(tables) < 10, true)
+
+	store = newStore(t, path)
+	defer store.Close()
+	for i := range 500 {
+		value, err := store.Get(ctx, fmt.Sprintf("key-%04d", i))
+		if i%2 == 0 {
+			assert.That(t, "deleted key must not exist", err, lsm.ErrorKeyDoesNotExist)
+		} else {
+			assert.That(t, "err must be nil", err, nil)
+			assert.That(t, "value must be the latest one", value, fmt.Sprintf("value-%d", 1500+i))
+		}
+	}
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "key-"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "scan must skip the deleted keys", len(page.Keys), 250)
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, t.TempDir())
+	defer store.Close()
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3", "B/1", "b/4"} {
+		_ = store.Put(ctx, key, "value")
+	}
+	_ = store.Delete(ctx, "b/4")
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"b/1", "b/2"}, Next: "b/2"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"b/3"}})
+
+	page, err = store.Scan(ctx, ports.Range[string]{End: "b/2"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "range must be correct", page.Keys, []string{"B/1", "a/1", "b/1"})
+}
+
+func TestObjectStore_CompareAndSwap_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, t.TempDir())
+	defer store.Close()
+
+	err := store.CompareAndSwap(ctx, "foo", "", "bar")
+	assert.That(t, "creating a new key must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "", "baz")
+	assert.That(t, "creating an existing key must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "baz")
+	assert.That(t, "swapping the current value must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "qux")
+	assert.That(t, "swapping an old value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "bar")
+	assert.That(t, "deleting an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting the current value must succeed", err, nil)
+	err = store.CompareAndDelete(ctx, "foo", "")
+	assert.That(t, "deleting a missing key must fail", err, ports.ErrorValueChanged)
+}
+
+func TestObjectStore_Apply_All_Or_Nothing(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, t.TempDir())
+	defer store.Close()
+	_ = store.Put(ctx, "a", "1")
+	_ = store.Put(ctx, "b", "2")
+
+	// The second write expects an old value, thus no write is applied.
+	err := store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "0", Value: "20"},
+	})
+	assert.That(t, "err must be ErrorValueChanged", err, ports.ErrorValueChanged)
+	value, _ := store.Get(ctx, "a")
+	assert.That(t, "a must be unchanged", value, "1")
+
+	err = store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "2"},
+		{Key: "c", Value: "30"},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	value, _ = store.Get(ctx, "a")
+	assert.That(t, "a must be updated", value, "10")
+	_, err = store.Get(ctx, "b")
+	assert.That(t, "b must be deleted", err, lsm.ErrorKeyDoesNotExist)
+	value, _ = store.Get(ctx, "c")
+	assert.That(t, "c must be created", value, "30")
+}
+
+func TestObjectStore_Closed(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	assert.That(t, "err must be nil", store.Close(), nil)
+
+	err := store.Put(context.Background(), "foo", "bar")
+	assert.That(t, "err must be ErrorClosed", err, lsm.ErrorClosed)
+	assert.That(t, "closing twice must not fail", store.Close(), nil)
+}
+
+// BenchmarkObjectStore compares the store with the in-memory store, which both contain 1M keys.
+// Run it by "go test -run=^$ -bench=. ./internal/app/adapters/outbound/lsm".
+func BenchmarkObjectStore(b *testing.B) {
+	const keys = 1 << 20
+	ctx := context.Background()
+	store, err := lsm.NewObjectStore(config.PortLSM{Path: b.TempDir()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+
+	for name, port := range map[string]ports.ObjectPort[string, string]{
+		"inmemory": inmemory.NewObjectStore(16),
+		"lsm":      store,
+	} {
+		batch := port.(ports.BatchPort[string, string])
+		writes := make([]ports.Write[string, string], 0, 1024)
+		for i := range keys {
+			writes = append(writes, ports.Write[string, string]{Key: key(i), Value: "value-" + key(i)})
+			if len(writes) == cap(writes) {
+				if err := batch.Apply(ctx, writes); err != nil {
+					b.Fatal(err)
+				}
+				writes = writes[:0]
+			}
+		}
+
+		b.Run(name+"/Get", func(b *testing.B) {
+			for i := range b.N {
+				if _, err := port.Get(ctx, key(i*7919%keys)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/Put", func(b *testing.B) {
+			for i := range b.N {
+				if err := port.Put(ctx, key(i*7919%keys), "updated"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/Scan", func(b *testing.B) {
+			for i := range b.N {
+				if _, err := port.Scan(ctx, ports.Range[string]{Start: key(i * 7919 % keys), Limit: 100}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// key returns the key with the number, which sorts in the order of the numbers.
+func key(i int) string {
+	return fmt.Sprintf("key-%08d", i)
+}
@@ -0,0 +1,327 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// maxStringLength is the maximum length of a key or value, which protects the decoder from corrupt lengths.
+	maxStringLength = 1 << 30
+	// indexInterval is the number of records between two entries of the sparse index of a table.
+	indexInterval = 16
+	// tableMagic marks the end of a complete table file.
+	tableMagic = 0x4c534d54 // "LSMT"
+)
+
+var (
+	// errCorruptTable is returned when a table file is incomplete or its index does not match its checksum.
+	errCorruptTable = errors.New("corrupt table")
+)
+
+// table is an immutable file of records sorted by their keys. It consists of the records,
+// a sparse index with the key and offset of every indexInterval-th record and a footer:
+//
+//	record: uvarint key length | key | kind (0 = value, 1 = tombstone) | uvarint value length | value
+//	index:  uvarint count | count * (uvarint key length | key | uvarint offset)
+//	footer: uint64 offset of the index | uint32 CRC-32 of the index and the offset | uint32 magic
+//
+// The index is kept in memory, thus a lookup reads the records between two index entries at most.
+type table struct {
+	end   int64 // Offset of the index, which is the end of the records.
+	file  *os.File
+	index []indexEntry
+	path  string
+}
+
+// indexEntry is the key and the offset of a record of a table.
+type indexEntry struct {
+	key    string
+	offset int64
+}
+
+// tableIterator iterates over the records of a table.
+type tableIterator struct {
+	e       error
+	pending *record // The first record of a seek, which has already been read.
+	r       *bufio.Reader
+}
+
+// byteReader is implemented by the readers, from which records are decoded.
+type byteReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+// openTable opens the table file and reads its index.
+func openTable(path string) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readIndex(file, path)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%w: %s: %v", errCorruptTable, path, err)
+	}
+	return t, nil
+}
+
+// writeTable writes the records of the iterator to a new table file, which is only visible under its path when it is complete.
+// Tombstones are dropped, if the table replaces all older tables, because there is no older value which they have to hide.
+func writeTable(path string, it iterator, dropTombstones bool) (*table, error) {
+	temp := path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp)
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 64<<10)
+	var buf []byte
+	var index []indexEntry
+	var offset int64
+	count := 0
+	for {
+		r, ok := it.next()
+		if !ok {
+			break
+		}
+		if r.deleted && dropTombstones {
+			continue
+		}
+		if count%indexInterval == 0 {
+			index = append(index, indexEntry{key: r.key, offset: offset})
+		}
+		buf = appendRecord(buf[:0], r)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		offset += int64(len(buf))
+		count++
+	}
+	if err := it.err(); err != nil {
+		return nil, err
+	}
+
+	buf = binary.AppendUvarint(buf[:0], uint64(len(index)))
+	for _, e := range index {
+		buf = binary.AppendUvarint(buf, uint64(len(e.key)))
+		buf = append(buf, e.key...)
+		buf = binary.AppendUvarint(buf, uint64(e.offset))
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(offset))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	buf = binary.BigEndian.AppendUint32(buf, tableMagic)
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return openTable(path)
+}
+
+// close closes the file of the table.
+func (t *table) close() error {
+	return t.file.Close()
+}
+
+// get returns the entry of the key and whether the table contains the key.
+func (t *table) get(key string) (entry, bool, error) {
+	i := sort.Search(len(t.index), func(i int) bool { return t.index[i].key > key }) - 1
+	if i < 0 {
+		return entry{}, false, nil
+	}
+	end := t.end
+	if i+1 < len(t.index) {
+		end = t.index[i+1].offset
+	}
+	buf := make([]byte, end-t.index[i].offset)
+	if _, err := t.file.ReadAt(buf, t.index[i].offset); err != nil {
+		return entry{}, false, err
+	}
+	r := bytes.NewReader(buf)
+	for r.Len() > 0 {
+		rec, err := readRecord(r)
+		if err != nil {
+			return entry{}, false, err
+		}
+		if rec.key == key {
+			return rec.entry, true, nil
+		}
+		if rec.key > key {
+			break
+		}
+	}
+	return entry{}, false, nil
+}
+
+// seek returns an iterator, which starts at the first key greater than or equal to the key.
+func (t *table) seek(key string) *tableIterator {
+	i := max(sort.Search(len(t.index), func(i int) bool { return t.index[i].key > key })-1, 0)
+	var offset int64
+	if len(t.index) > 0 {
+		offset = t.index[i].offset
+	}
+	it := &tableIterator{r: bufio.NewReader(io.NewSectionReader(t.file, offset, t.end-offset))}
+	for {
+		r, ok := it.next()
+		if !ok {
+			return it
+		}
+		if r.key >= key {
+			it.pending = &r
+			return it
+		}
+	}
+}
+
+func (it *tableIterator) err() error {
+	return it.e
+}
+
+func (it *tableIterator) next() (record, bool) {
+	if it.pending != nil {
+		r := *it.pending
+		it.pending = nil
+		return r, true
+	}
+	if it.e != nil {
+		return record{}, false
+	}
+	r, err := readRecord(it.r)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			it.e = err
+		}
+		return record{}, false
+	}
+	return r, true
+}
+
+// appendRecord appends the encoded record to the buffer.
+func appendRecord(buf []byte, r record) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(r.key)))
+	buf = append(buf, r.key...)
+	if r.deleted {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(r.value)))
+	return append(buf, r.value...)
+}
+
+// readIndex reads the footer and the index of the table file.
+func readIndex(file *os.File, path string) (*table, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < 16 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	footer := make([]byte, 16)
+	if _, err := file.ReadAt(footer, info.Size()-16); err != nil {
+		return nil, err
+	}
+	end := int64(binary.BigEndian.Uint64(footer))
+	if binary.BigEndian.Uint32(footer[12:]) != tableMagic || end < 0 || end > info.Size()-16 {
+		return nil, errors.New("invalid footer")
+	}
+	data := make([]byte, info.Size()-end-8)
+	if _, err := file.ReadAt(data, end); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(footer[8:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	r := bytes.NewReader(data[:len(data)-8])
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{end: end, file: file, path: path}
+	for range count {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		t.index = append(t.index, indexEntry{key: key, offset: int64(offset)})
+	}
+	return t, nil
+}
+
+// readRecord decodes the next record. It returns io.EOF only if there are no more records.
+func readRecord(r byteReader) (rec record, err error) {
+	if rec.key, err = readString(r); err != nil {
+		return rec, err
+	}
+	kind, err := r.ReadByte()
+	if err != nil {
+		return rec, io.ErrUnexpectedEOF
+	}
+	rec.deleted = kind == 1
+	if rec.value, err = readString(r); err != nil {
+		return rec, noEOF(err)
+	}
+	return rec, nil
+}
+
+// readString decodes a string, which is prefixed by its length.
+func readString(r byteReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxStringLength {
+		return "", fmt.Errorf("invalid length %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", noEOF(err)
+	}
+	return string(buf), nil
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF, because the data ends in the middle of a record.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// syncDir flushes the directory entries to disk, which makes renames and removals durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
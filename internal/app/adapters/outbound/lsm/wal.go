@@ -0,0 +1,75 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The write-ahead log consists of frames, each of which contains the records of a single write:
+//
+//	frame: uint32 CRC-32 of the payload | uint32 length of the payload | payload (records)
+//
+// A frame which is incomplete or does not match its checksum is the torn end of the log,
+// which was written when the process crashed. Its write has not been acknowledged, thus it is dropped.
+
+// appendFrame appends the frame of the records to the buffer.
+func appendFrame(buf []byte, records []record) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, 8)...)
+	for _, r := range records {
+		buf = appendRecord(buf, r)
+	}
+	payload := buf[start+8:]
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(buf[start+4:], uint32(len(payload)))
+	return buf
+}
+
+// replayLog calls the function with the records of each complete frame of the log in the order of the writes.
+func replayLog(path string, fn func(r record)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return ignoreTornFrame(err)
+		}
+		length := binary.BigEndian.Uint32(header[4:])
+		if length > maxStringLength {
+			return nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return ignoreTornFrame(err)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header) {
+			return nil
+		}
+		records := bytes.NewReader(payload)
+		for records.Len() > 0 {
+			rec, err := readRecord(records)
+			if err != nil {
+				return err
+			}
+			fn(rec)
+		}
+	}
+}
+
+// ignoreTornFrame returns nil if the error is caused by the end of the log.
+func ignoreTornFrame(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
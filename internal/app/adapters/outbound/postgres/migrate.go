@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+)
+
+// migrationLock is the key of the advisory lock, which serializes the migrations of concurrently starting services.
+const migrationLock = 0x636e73
+
+// migrations are the SQL scripts which create and change the schema of the store.
+// A script must never be changed after it has been released, instead a new script is added.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrate applies the migrations, which have not been applied yet, in the order of their names.
+// The applied migrations are recorded in the table "store_migrations".
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS store_migrations (name TEXT PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil {
+		return err
+	}
+
+	// The names are sorted by fs.Glob.
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var applied bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM store_migrations WHERE name = $1)", name).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO store_migrations (name) VALUES ($1)", name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
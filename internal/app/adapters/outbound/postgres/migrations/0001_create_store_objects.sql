@@ -0,0 +1,6 @@
+-- A key may contain bytes which TEXT rejects, e.g. the NUL byte which separates the namespace from the key.
+-- BYTEA keeps any byte and is compared bytewise like the keys of the other stores, which keeps the order of the scans.
+CREATE TABLE store_objects (
+    key   BYTEA PRIMARY KEY,
+    value TEXT NOT NULL
+);
@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+)
+
+// ObjectStore is an object store in the table "store_objects" of a PostgreSQL database,
+// which is created by the embedded migrations. The keys are stored as BYTEA, because TEXT rejects the NUL byte,
+// which separates a namespace from its keys. The connections are pooled by database/sql.
+// Every statement is canceled when its context is done, e.g. by the timeout of the service.
+// It implements the ports.ObjectPort and ports.BatchPort interfaces.
+type ObjectStore struct {
+	db *sql.DB
+}
+
+// executor is implemented by the pool and by a transaction.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// NewObjectStore connects to the configured database and applies the migrations, which have not been applied yet.
+func NewObjectStore(ctx context.Context, cfg config.PortPostgres) (*ObjectStore, error) {
+	db, err := sql.Open("postgres", cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if err := migrate(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &ObjectStore{db: db}, nil
+}
+
+// Apply applies either all writes or none of them in a single serializable transaction,
+// thus a key which must not exist cannot be inserted concurrently. The database aborts
+// conflicting transactions with a serialization failure, which is a transient error.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	tx, err := a.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return classify(ctx, err)
+	}
+	defer tx.Rollback()
+	for _, w := range writes {
+		if err := write(ctx, tx, w); err != nil {
+			return err
+		}
+	}
+	return classify(ctx, tx.Commit())
+}
+
+// Close closes the connections of the pool.
+func (a *ObjectStore) Close() error {
+	return a.db.Close()
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	// An empty old value would require the key to not exist, which never equals a stored value.
+	if old == "" {
+		return ports.ErrorValueChanged
+	}
+	return write(ctx, a.db, ports.Write[string, string]{Key: key, Old: old})
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	return write(ctx, a.db, ports.Write[string, string]{Key: key, Old: old, Value: value})
+}
+
+// Delete removes a key and its associated value from the store.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	_, err = a.db.ExecContext(ctx, "DELETE FROM store_objects WHERE key = $1", []byte(key))
+	return classify(ctx, err)
+}
+
+// Get retrieves the value associated with the given key.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	err = a.db.QueryRowContext(ctx, "SELECT value FROM store_objects WHERE key = $1", []byte(key)).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return value, ErrorKeyDoesNotExist
+	}
+	return value, classify(ctx, err)
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	_, err = a.db.ExecContext(ctx,
+		"INSERT INTO store_objects (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value",
+		[]byte(key), value)
+	return classify(ctx, err)
+}
+
+// Scan returns the keys which are selected by the range in ascending order.
+// The range is evaluated by the database, which only returns one key more than the limit of the page.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	// A limit of NULL does not restrict the number of rows.
+	limit := sql.NullInt64{Int64: int64(r.Limit + 1), Valid: r.Limit > 0}
+	rows, err := a.db.QueryContext(ctx, `SELECT key FROM store_objects
+		WHERE key >= $1 AND key > $2 AND ($3 = ''::bytea OR key < $3) AND key >= $4 AND substring(key from 1 for length($4)) = $4
+		ORDER BY key LIMIT $5`, []byte(r.Start), []byte(r.After), []byte(r.End), []byte(r.Prefix), limit)
+	if err != nil {
+		return page, classify(ctx, err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return page, classify(ctx, err)
+		}
+		keys = append(keys, string(key))
+	}
+	if err := rows.Err(); err != nil {
+		return page, classify(ctx, err)
+	}
+	return r.Paginate(keys), nil
+}
+
+// write applies the write, if the current value of its key equals the old value of the write.
+// Otherwise no row is affected and it returns ErrorValueChanged.
+func write(ctx context.Context, db executor, w ports.Write[string, string]) error {
+	var res sql.Result
+	var err error
+	switch {
+	case w.Old == "" && w.Value == "":
+		res, err = db.ExecContext(ctx, "SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM store_objects WHERE key = $1)", []byte(w.Key))
+	case w.Old == "":
+		res, err = db.ExecContext(ctx, "INSERT INTO store_objects (key, value) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING", []byte(w.Key), w.Value)
+	case w.Value == "":
+		res, err = db.ExecContext(ctx, "DELETE FROM store_objects WHERE key = $1 AND value = $2", []byte(w.Key), w.Old)
+	default:
+		res, err = db.ExecContext(ctx, "UPDATE store_objects SET value = $3 WHERE key = $1 AND value = $2", []byte(w.Key), w.Old, w.Value)
+	}
+	if err != nil {
+		return classify(ctx, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ports.ErrorValueChanged
+	}
+	return nil
+}
+
+// classify returns the error of the context, if it is done, so that a canceled statement is reported as
+// the timeout or cancellation of the call. Errors of the database which are not caused by the connection,
+// a conflict of transactions or the load of the server are permanent, e.g. a syntax error.
+func classify(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code.Class() {
+	case "08", "40", "53", "57": // Connection exception, transaction rollback, insufficient resources, operator intervention.
+		return err
+	default:
+		return ports.Permanent(err)
+	}
+}
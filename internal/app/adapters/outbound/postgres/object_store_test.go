@@ -0,0 +1,187 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/postgres"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// newStore connects to the local database at POSTGRES_TEST_URL and removes all keys of the store.
+// The test is skipped if the database is not available.
+func newStore(t *testing.T) *postgres.ObjectStore {
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store, err := postgres.NewObjectStore(ctx, config.PortPostgres{MaxOpenConns: 4, URL: url})
+	if err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	db, _ := sql.Open("postgres", url)
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "TRUNCATE store_objects")
+	assert.That(t, "err must be nil", err, nil)
+	return store
+}
+
+func TestNewObjectStore_Unavailable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := postgres.NewObjectStore(ctx, config.PortPostgres{URL: "postgres://store@127.0.0.1:1/store?sslmode=disable"})
+	assert.That(t, "err must not be nil", err == nil, false)
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+	err = store.Put(ctx, "foo", "baz")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'baz'", value, "baz")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, postgres.ErrorKeyDoesNotExist)
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "deleting a missing key must not fail", err, nil)
+}
+
+func TestObjectStore_Migrations_Are_Applied_Once(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	_ = store.Put(ctx, "foo", "bar")
+
+	// A second store must not create the table again.
+	again, err := postgres.NewObjectStore(ctx, config.PortPostgres{URL: os.Getenv("POSTGRES_TEST_URL")})
+	assert.That(t, "err must be nil", err, nil)
+	defer again.Close()
+
+	value, err := again.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3", "B/1"} {
+		_ = store.Put(ctx, key, "value")
+	}
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"b/1", "b/2"}, Next: "b/2"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"b/3"}})
+
+	// The keys are sorted by their bytes, thus upper case letters come first.
+	page, err = store.Scan(ctx, ports.Range[string]{End: "b/2"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "range must be correct", page.Keys, []string{"B/1", "a/1", "b/1"})
+}
+
+func TestObjectStore_Namespaced_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	// The NUL byte separates a namespace from its keys, e.g. of the API tokens.
+	for _, key := range []string{"team-a\x00foo", "team-a\x00bar", "_tokens\x00id", "team-b\x00foo"} {
+		err := store.Put(ctx, key, "value")
+		assert.That(t, "err must be nil", err, nil)
+	}
+
+	value, err := store.Get(ctx, "team-a\x00foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'value'", value, "value")
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "team-a\x00"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "keys of the namespace must be correct", page.Keys, []string{"team-a\x00bar", "team-a\x00foo"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{End: "team-a\x00foo", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "page must be correct", page, ports.Page[string]{Keys: []string{"_tokens\x00id", "team-a\x00bar"}})
+}
+
+func TestObjectStore_CompareAndSwap_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	err := store.CompareAndSwap(ctx, "foo", "", "bar")
+	assert.That(t, "creating a new key must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "", "baz")
+	assert.That(t, "creating an existing key must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "baz")
+	assert.That(t, "swapping the current value must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "qux")
+	assert.That(t, "swapping an old value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "bar")
+	assert.That(t, "deleting an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting the current value must succeed", err, nil)
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "key must be deleted", err, postgres.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Apply_All_Or_Nothing(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	_ = store.Put(ctx, "a", "1")
+	_ = store.Put(ctx, "b", "2")
+
+	// The second write expects an old value, thus no write is applied.
+	err := store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "0", Value: "20"},
+	})
+	assert.That(t, "err must be ErrorValueChanged", err, ports.ErrorValueChanged)
+	value, _ := store.Get(ctx, "a")
+	assert.That(t, "a must be unchanged", value, "1")
+
+	err = store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "2"},
+		{Key: "c", Value: "30"},
+		{Key: "d"},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	value, _ = store.Get(ctx, "a")
+	assert.That(t, "a must be updated", value, "10")
+	_, err = store.Get(ctx, "b")
+	assert.That(t, "b must be deleted", err, postgres.ErrorKeyDoesNotExist)
+	value, _ = store.Get(ctx, "c")
+	assert.That(t, "c must be created", value, "30")
+}
+
+func TestObjectStore_Canceled_Context(t *testing.T) {
+	store := newStore(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	// The deadline has passed before the call, which is reported as a timeout of the service.
+	time.Sleep(2 * time.Millisecond)
+	_, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be the error of the context", err, context.DeadlineExceeded)
+	assert.That(t, "timeout must be transient", ports.IsTransient(err), true)
+}
@@ -0,0 +1,446 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+const (
+	// DefaultAddress is the address of a local Redis server.
+	DefaultAddress = "localhost:6379"
+	// expiriesSuffix is appended to the prefix to get the name of the index of the expiries.
+	// The byte 0xff never occurs in the UTF-8 encoded keys of the store, thus the index never collides with them.
+	expiriesSuffix = "\xffexpiries"
+	// keysSuffix is appended to the prefix to get the name of the index of the keys, like expiriesSuffix.
+	keysSuffix = "\xffkeys"
+	// scanCount is the number of keys, which are read from the index of the keys for each call of ZRANGEBYLEX.
+	scanCount = 1000
+)
+
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+)
+
+// ObjectStore is an object store in Redis, which stores the value of each key as a string under
+// the configured prefix followed by the key. It speaks RESP over a pool of plain TCP connections.
+// The conditional writes watch their keys, compare the current values and apply the changes in a
+// transaction (MULTI/EXEC), which Redis discards if a watched key has been changed in the meantime.
+// Expired keys are removed by Redis itself. Their expiries are also kept in a sorted set,
+// so that the sweeper is able to report the removed keys. Redis does not keep the keys in order,
+// thus every key is also a member of a sorted set, which is written in the same transaction as the key
+// and lets a scan read the keys in order from its cursor on.
+// It implements the ports.ObjectPort, ports.BatchPort and ports.ExpiringPort interfaces.
+type ObjectStore struct {
+	address  string
+	database int
+	dialer   net.Dialer
+	idle     []*conn // Connections, which are not used by a call.
+	mutex    sync.Mutex
+	password string
+	prefix   string
+	username string
+}
+
+// NewObjectStore creates an object store for the configured Redis server.
+// The address defaults to DefaultAddress. The connections are opened when they are needed.
+func NewObjectStore(cfg config.PortRedis) *ObjectStore {
+	a := &ObjectStore{
+		address:  cfg.Address,
+		database: cfg.Database,
+		password: cfg.Password,
+		prefix:   cfg.Prefix,
+		username: cfg.Username,
+	}
+	if a.address == "" {
+		a.address = DefaultAddress
+	}
+	return a
+}
+
+// Apply applies either all writes or none of them in a single transaction.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	return a.transact(ctx, writes)
+}
+
+// Close closes the idle connections.
+func (a *ObjectStore) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var err error
+	for _, c := range a.idle {
+		err = errors.Join(err, c.Close())
+	}
+	a.idle = nil
+	return err
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	// An empty old value would require the key to not exist, which never equals a stored value.
+	if old == "" {
+		return ports.ErrorValueChanged
+	}
+	return a.transact(ctx, []ports.Write[string, string]{{Key: key, Old: old}})
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	return a.transact(ctx, []ports.Write[string, string]{{Key: key, Old: old, Value: value}})
+}
+
+// Delete removes a key and its associated value from the store.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	return a.withConn(ctx, func(c *conn) error {
+		return a.exec(c, [][]string{
+			{"DEL", a.key(key)},
+			{"ZREM", a.prefix + keysSuffix, key},
+		})
+	})
+}
+
+// Expire sets the time at which Redis removes the key, if its current value equals value.
+func (a *ObjectStore) Expire(ctx context.Context, key, value string, at time.Time) (err error) {
+	ms := strconv.FormatInt(at.UnixMilli(), 10)
+	return a.withConn(ctx, func(c *conn) error {
+		if err := a.watch(c, []ports.Write[string, string]{{Key: key, Old: value}}); err != nil {
+			return err
+		}
+		return a.exec(c, [][]string{
+			{"PEXPIREAT", a.key(key), ms},
+			{"ZADD", a.prefix + expiriesSuffix, ms, key},
+		})
+	})
+}
+
+// Get retrieves the value associated with the given key.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	err = a.withConn(ctx, func(c *conn) error {
+		reply, err := c.do("GET", a.key(key))
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return ErrorKeyDoesNotExist
+		}
+		value, _ = reply.(string)
+		return nil
+	})
+	return value, err
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+// It removes the expiry of the key.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	return a.withConn(ctx, func(c *conn) error {
+		return a.exec(c, [][]string{
+			{"SET", a.key(key), value},
+			{"ZADD", a.prefix + keysSuffix, "0", key},
+		})
+	})
+}
+
+// Scan returns the keys which are selected by the range in ascending order.
+// The keys are read from the index of the keys from the first key of the range on, until one key more than the limit
+// has been found. A key which Redis has removed because it expired stays in the index until it is swept, thus it is skipped.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	from, to := "-", "+"
+	if start := max(r.Prefix, r.Start); start != "" {
+		from = "[" + start
+	}
+	if r.After != "" && r.After >= max(r.Prefix, r.Start) {
+		from = "(" + r.After
+	}
+	if r.End != "" {
+		to = "(" + r.End
+	}
+	count := scanCount
+	if r.Limit > 0 {
+		count = r.Limit + 1
+	}
+
+	var keys []string
+	err = a.withConn(ctx, func(c *conn) error {
+		for {
+			reply, err := c.do("ZRANGEBYLEX", a.prefix+keysSuffix, from, to, "LIMIT", "0", strconv.Itoa(count))
+			if err != nil {
+				return err
+			}
+			members, _ := reply.([]any)
+			if len(members) == 0 {
+				return nil
+			}
+			commands := make([][]string, 0, len(members))
+			for _, member := range members {
+				key, _ := member.(string)
+				commands = append(commands, []string{"EXISTS", a.key(key)})
+			}
+			exists, err := c.pipeline(commands)
+			if err != nil {
+				return err
+			}
+			for i, member := range members {
+				key, _ := member.(string)
+				if !strings.HasPrefix(key, r.Prefix) {
+					return nil
+				}
+				if n, _ := exists[i].(int64); n == 0 || !r.Contains(key) {
+					continue
+				}
+				keys = append(keys, key)
+				// Only one key more than the limit is needed to tell whether there is a next page.
+				if r.Limit > 0 && len(keys) > r.Limit {
+					return nil
+				}
+			}
+			if len(members) < count {
+				return nil
+			}
+			last, _ := members[len(members)-1].(string)
+			from = "(" + last
+		}
+	})
+	if err != nil {
+		return page, err
+	}
+	return r.Paginate(keys), nil
+}
+
+// Sweep reports the keys, which Redis has removed because they expired, every interval until the context is done.
+// If a sweep fails, its keys are reported by the next one.
+func (a *ObjectStore) Sweep(ctx context.Context, interval time.Duration, fn func(key string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			keys, _ := a.sweep(ctx, now)
+			for _, key := range keys {
+				fn(key)
+			}
+		}
+	}
+}
+
+// acquire returns an idle connection or opens a new one, which is authenticated and uses the database.
+func (a *ObjectStore) acquire(ctx context.Context) (*conn, error) {
+	a.mutex.Lock()
+	if n := len(a.idle); n > 0 {
+		c := a.idle[n-1]
+		a.idle = a.idle[:n-1]
+		a.mutex.Unlock()
+		return c, nil
+	}
+	a.mutex.Unlock()
+
+	nc, err := a.dialer.DialContext(ctx, "tcp", a.address)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(nc)
+	var commands [][]string
+	switch {
+	case a.password != "" && a.username != "":
+		commands = append(commands, []string{"AUTH", a.username, a.password})
+	case a.password != "":
+		commands = append(commands, []string{"AUTH", a.password})
+	}
+	if a.database != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(a.database)})
+	}
+	if len(commands) > 0 {
+		if _, err := c.pipeline(commands); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// exec applies the commands in a transaction. It returns ErrorValueChanged,
+// if Redis discards the transaction because a watched key has been changed.
+func (a *ObjectStore) exec(c *conn, commands [][]string) error {
+	commands = append(append([][]string{{"MULTI"}}, commands...), []string{"EXEC"})
+	replies, err := c.pipeline(commands)
+	if err != nil {
+		return err
+	}
+	if replies[len(replies)-1] == nil {
+		return ports.ErrorValueChanged
+	}
+	return nil
+}
+
+// key returns the name of the key in Redis.
+func (a *ObjectStore) key(key string) string {
+	return a.prefix + key
+}
+
+// release returns the connection to the idle connections, unless it failed.
+// A connection which failed by anything but an error reply may be in the middle of a reply, thus it is closed.
+// After an error reply, the keys which may still be watched are unwatched.
+func (a *ObjectStore) release(c *conn, err error) {
+	var reply respError
+	switch {
+	case err == nil, errors.Is(err, ErrorKeyDoesNotExist), errors.Is(err, ports.ErrorValueChanged):
+	case errors.As(err, &reply):
+		if _, err := c.do("UNWATCH"); err != nil {
+			_ = c.Close()
+			return
+		}
+	default:
+		_ = c.Close()
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.idle = append(a.idle, c)
+}
+
+// sweep removes the keys from the index of the expiries, which expired before now, and returns the keys which Redis has removed.
+// The removed keys are also removed from the index of the keys. A key which has been written without an expiry in the meantime
+// is not returned. A key which Redis has not removed yet, e.g. because its clock is behind, stays in the index.
+// The index and the due keys are watched, so that concurrent sweepers do not report a key twice and a key which is written
+// again during the sweep is not removed from the index of the keys.
+func (a *ObjectStore) sweep(ctx context.Context, now time.Time) (keys []string, err error) {
+	index := a.prefix + expiriesSuffix
+	err = a.withConn(ctx, func(c *conn) error {
+		if _, err := c.do("WATCH", index); err != nil {
+			return err
+		}
+		reply, err := c.do("ZRANGEBYSCORE", index, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		if err != nil {
+			return err
+		}
+		due, _ := reply.([]any)
+		if len(due) == 0 {
+			_, err := c.do("UNWATCH")
+			return err
+		}
+		watch := []string{"WATCH"}
+		commands := make([][]string, 0, len(due))
+		for _, key := range due {
+			key, _ := key.(string)
+			watch = append(watch, a.key(key))
+			commands = append(commands, []string{"PTTL", a.key(key)})
+		}
+		replies, err := c.pipeline(append([][]string{watch}, commands...))
+		if err != nil {
+			return err
+		}
+		ttls := replies[1:]
+		var removals [][]string
+		for i, key := range due {
+			key, _ := key.(string)
+			switch ttl, _ := ttls[i].(int64); ttl {
+			case -2:
+				keys = append(keys, key)
+				removals = append(removals, []string{"ZREM", index, key}, []string{"ZREM", a.prefix + keysSuffix, key})
+			case -1:
+				removals = append(removals, []string{"ZREM", index, key})
+			}
+		}
+		if len(removals) == 0 {
+			_, err := c.do("UNWATCH")
+			return err
+		}
+		return a.exec(c, removals)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// transact watches the keys of the writes and applies them in a transaction, if every key still has its old value.
+func (a *ObjectStore) transact(ctx context.Context, writes []ports.Write[string, string]) error {
+	return a.withConn(ctx, func(c *conn) error {
+		if err := a.watch(c, writes); err != nil {
+			return err
+		}
+		commands := make([][]string, 0, len(writes))
+		for _, w := range writes {
+			if w.Value == "" {
+				commands = append(commands, []string{"DEL", a.key(w.Key)}, []string{"ZREM", a.prefix + keysSuffix, w.Key})
+			} else {
+				commands = append(commands, []string{"SET", a.key(w.Key), w.Value}, []string{"ZADD", a.prefix + keysSuffix, "0", w.Key})
+			}
+		}
+		return a.exec(c, commands)
+	})
+}
+
+// watch watches the keys of the writes and checks that every key has its old value.
+// Otherwise, it stops watching the keys and returns ErrorValueChanged.
+func (a *ObjectStore) watch(c *conn, writes []ports.Write[string, string]) error {
+	watch, get := []string{"WATCH"}, []string{"MGET"}
+	for _, w := range writes {
+		watch = append(watch, a.key(w.Key))
+		get = append(get, a.key(w.Key))
+	}
+	replies, err := c.pipeline([][]string{watch, get})
+	if err != nil {
+		return err
+	}
+	values, _ := replies[1].([]any)
+	if len(values) != len(writes) {
+		return errProtocol
+	}
+	for i, w := range writes {
+		if current, exists := values[i].(string); exists != (w.Old != "") || current != w.Old {
+			if _, err := c.do("UNWATCH"); err != nil {
+				return err
+			}
+			return ports.ErrorValueChanged
+		}
+	}
+	return nil
+}
+
+// withConn calls the function with an idle connection, which is released afterwards.
+// If the context is done, the pending reads and writes of the connection are interrupted.
+func (a *ObjectStore) withConn(ctx context.Context, fn func(c *conn) error) error {
+	c, err := a.acquire(ctx)
+	if err != nil {
+		return classify(err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = c.SetDeadline(time.Now()) })
+	err = fn(c)
+	if !stop() {
+		// The deadline has been set, thus the connection cannot be used anymore.
+		_ = c.Close()
+		if err != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+	a.release(c, err)
+	return classify(err)
+}
+
+// classify marks error replies as permanent, unless Redis is temporarily unable to serve the command.
+func classify(err error) error {
+	var reply respError
+	if !errors.As(err, &reply) {
+		return err
+	}
+	switch reply.kind() {
+	case "BUSY", "CLUSTERDOWN", "LOADING", "MASTERDOWN", "READONLY", "TRYAGAIN":
+		return err
+	default:
+		return ports.Permanent(err)
+	}
+}
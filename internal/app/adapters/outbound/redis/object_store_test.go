@@ -0,0 +1,250 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/redis"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// newServer starts the stand-in Redis on a free local port and returns its address.
+func newServer(t *testing.T, server *redis.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.That(t, "err must be nil", err, nil)
+	go func() { _ = server.Serve(l) }()
+	t.Cleanup(func() { _ = l.Close() })
+	return l.Addr().String()
+}
+
+// newStore creates a store for the keys with the prefix in the stand-in at the address.
+func newStore(t *testing.T, address, prefix string) *redis.ObjectStore {
+	store := redis.NewObjectStore(config.PortRedis{Address: address, Prefix: prefix})
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, newServer(t, redis.NewServer()), "store:")
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, redis.ErrorKeyDoesNotExist)
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "deleting a missing key must not fail", err, nil)
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	address := newServer(t, redis.NewServer())
+	// The keys of another prefix are in another index.
+	store := newStore(t, address, "store[*]:")
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3"} {
+		_ = store.Put(ctx, key, "value")
+	}
+	_ = newStore(t, address, "store[x]:").Put(ctx, "b/0", "value")
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"b/1", "b/2"}, Next: "b/2"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"b/3"}})
+
+	page, err = store.Scan(ctx, ports.Range[string]{Start: "a/1", End: "c/1"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "range must be correct", page.Keys, []string{"a/1", "b/1", "b/2", "b/3"})
+}
+
+func TestObjectStore_CompareAndSwap_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, newServer(t, redis.NewServer()), "")
+
+	err := store.CompareAndSwap(ctx, "foo", "", "bar")
+	assert.That(t, "creating a new key must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "", "baz")
+	assert.That(t, "creating an existing key must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "baz")
+	assert.That(t, "swapping the current value must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "qux")
+	assert.That(t, "swapping an old value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "bar")
+	assert.That(t, "deleting an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting the current value must succeed", err, nil)
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "key must be deleted", err, redis.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_CompareAndSwap_Concurrently(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, newServer(t, redis.NewServer()), "")
+	_ = store.Put(ctx, "counter", "0")
+
+	// Every increment is retried until its swap succeeds, thus no increment is lost.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				for {
+					old, _ := store.Get(ctx, "counter")
+					n, _ := strconv.Atoi(old)
+					err := store.CompareAndSwap(ctx, "counter", old, strconv.Itoa(n+1))
+					if !errors.Is(err, ports.ErrorValueChanged) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _ := store.Get(ctx, "counter")
+	assert.That(t, "no increment must be lost", value, "200")
+}
+
+func TestObjectStore_Apply_All_Or_Nothing(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, newServer(t, redis.NewServer()), "")
+	_ = store.Put(ctx, "a", "1")
+	_ = store.Put(ctx, "b", "2")
+
+	// The second write expects an old value, thus no write is applied.
+	err := store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "0", Value: "20"},
+	})
+	assert.That(t, "err must be ErrorValueChanged", err, ports.ErrorValueChanged)
+	value, _ := store.Get(ctx, "a")
+	assert.That(t, "a must be unchanged", value, "1")
+
+	err = store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "2"},
+		{Key: "c", Value: "30"},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	value, _ = store.Get(ctx, "a")
+	assert.That(t, "a must be updated", value, "10")
+	_, err = store.Get(ctx, "b")
+	assert.That(t, "b must be deleted", err, redis.ErrorKeyDoesNotExist)
+	value, _ = store.Get(ctx, "c")
+	assert.That(t, "c must be created", value, "30")
+}
+
+func TestObjectStore_Expire_Sweep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newStore(t, newServer(t, redis.NewServer()), "store:")
+	at := time.Now().Add(50 * time.Millisecond)
+	_ = store.Put(ctx, "foo", "bar")
+	_ = store.Put(ctx, "baz", "qux")
+
+	err := store.Expire(ctx, "foo", "old", at)
+	assert.That(t, "expiring an old value must fail", err, ports.ErrorValueChanged)
+	err = store.Expire(ctx, "foo", "bar", at)
+	assert.That(t, "err must be nil", err, nil)
+	err = store.Expire(ctx, "baz", "qux", at)
+	assert.That(t, "err must be nil", err, nil)
+	// Writing the key removes its expiry.
+	_ = store.Put(ctx, "baz", "quux")
+
+	swept := make(chan string, 2)
+	go store.Sweep(ctx, 10*time.Millisecond, func(key string) { swept <- key })
+	select {
+	case key := <-swept:
+		assert.That(t, "expired key must be swept", key, "foo")
+	case <-time.After(time.Second):
+		t.Fatal("expired key must be swept")
+	}
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "expired key must not exist", err, redis.ErrorKeyDoesNotExist)
+	value, _ := store.Get(ctx, "baz")
+	assert.That(t, "rewritten key must not expire", value, "quux")
+	page, _ := store.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "scan must not return the index of the expiries", page.Keys, []string{"baz"})
+	select {
+	case key := <-swept:
+		t.Fatalf("key %q must not be swept", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestObjectStore_Scan_Skips_Expired_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, newServer(t, redis.NewServer()), "store:")
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_ = store.Put(ctx, key, "value")
+	}
+	_ = store.Delete(ctx, "c")
+	// Redis removes the expired key, but it stays in the index of the keys until it is swept.
+	_ = store.Expire(ctx, "b", "value", time.Now())
+
+	page, err := store.Scan(ctx, ports.Range[string]{Limit: 1})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"a"}, Next: "a"})
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Limit: 1})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must skip the removed keys", page, ports.Page[string]{Keys: []string{"d"}})
+}
+
+func TestObjectStore_Password_And_Database(t *testing.T) {
+	ctx := context.Background()
+	address := newServer(t, redis.NewServer().WithPassword("store", "secret"))
+
+	store := redis.NewObjectStore(config.PortRedis{Address: address, Password: "wrong", Username: "store"})
+	defer store.Close()
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "wrong password must not be transient", ports.IsTransient(err), false)
+
+	first := redis.NewObjectStore(config.PortRedis{Address: address, Database: 1, Password: "secret", Username: "store"})
+	defer first.Close()
+	second := redis.NewObjectStore(config.PortRedis{Address: address, Database: 2, Password: "secret", Username: "store"})
+	defer second.Close()
+	err = first.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+	_, err = second.Get(ctx, "foo")
+	assert.That(t, "key must not exist in another database", err, redis.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := l.Addr().String()
+	_ = l.Close()
+
+	store := newStore(t, address, "")
+	_, err := store.Get(ctx, "foo")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "unavailable server must be transient", ports.IsTransient(err), true)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	store = newStore(t, newServer(t, redis.NewServer()), "")
+	_, err = store.Get(canceled, "foo")
+	assert.That(t, "canceled call must not be transient", ports.IsTransient(err), false)
+}
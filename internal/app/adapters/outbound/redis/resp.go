@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The client and the stand-in server speak RESP2, see https://redis.io/docs/latest/develop/reference/protocol-spec/.
+// A command is an array of bulk strings. A reply is decoded into one of the following values:
+//
+//	simple string: simpleString | error: respError | integer: int64
+//	bulk string:   string       | null:  nil       | array:   []any
+
+// maxBulkLength is the maximum length of a bulk string, which is also the limit of Redis.
+const maxBulkLength = 512 << 20
+
+// errProtocol is returned when a reply does not conform to the protocol.
+var errProtocol = errors.New("redis: protocol error")
+
+// conn is a connection to Redis with buffers for the commands and the replies.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// respError is an error reply, which starts with the kind of the error, e.g. "WRONGTYPE".
+type respError string
+
+// simpleString is a simple string reply, e.g. "OK".
+type simpleString string
+
+// newConn wraps the network connection.
+func newConn(c net.Conn) *conn {
+	return &conn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (e respError) Error() string {
+	return "redis: " + string(e)
+}
+
+// kind returns the first word of the error, e.g. "WRONGTYPE".
+func (e respError) kind() string {
+	kind, _, _ := strings.Cut(string(e), " ")
+	return kind
+}
+
+// do sends the command and returns its reply. An error reply is returned as the error.
+func (c *conn) do(args ...string) (any, error) {
+	replies, err := c.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends the commands at once and returns their replies.
+// If any reply is an error, the first one is returned as the error after all replies have been read.
+func (c *conn) pipeline(commands [][]string) ([]any, error) {
+	for _, args := range commands {
+		if err := writeValue(c.w, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(commands))
+	var replyErr error
+	for i := range commands {
+		reply, err := readValue(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(respError); ok && replyErr == nil {
+			replyErr = e
+		}
+		replies[i] = reply
+	}
+	return replies, replyErr
+}
+
+// readValue decodes the next value.
+func readValue(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	kind, text := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return simpleString(text), nil
+	case '-':
+		return respError(text), nil
+	case ':':
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(text)
+		if err != nil || n < -1 || n > maxBulkLength {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(text)
+		if err != nil || n < -1 || n > maxBulkLength {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, errProtocol
+	}
+}
+
+// writeValue encodes the value. A slice of strings is encoded as an array of bulk strings, i.e. as a command.
+func writeValue(w *bufio.Writer, value any) (err error) {
+	switch v := value.(type) {
+	case nil:
+		_, err = w.WriteString("$-1\r\n")
+	case simpleString:
+		_, err = w.WriteString("+" + string(v) + "\r\n")
+	case respError:
+		_, err = w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		_, err = w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		_, err = w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []string:
+		values := make([]any, len(v))
+		for i, s := range v {
+			values[i] = s
+		}
+		return writeValue(w, values)
+	case []any:
+		if _, err = w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n"); err != nil {
+			return err
+		}
+		for _, value := range v {
+			if err = writeValue(w, value); err != nil {
+				return err
+			}
+		}
+	default:
+		err = fmt.Errorf("redis: cannot encode %T", value)
+	}
+	return err
+}
@@ -0,0 +1,498 @@
+package redis
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDatabases is the number of databases of the stand-in, which is also the default of Redis.
+const maxDatabases = 16
+
+// Server is a local stand-in of a Redis server, which holds the keys of its databases in memory.
+// It serves the commands used by the ObjectStore over RESP, i.e. strings, sorted sets, expiries
+// and optimistic transactions by WATCH/MULTI/EXEC. It is only compiled into the tests of the package.
+type Server struct {
+	databases [maxDatabases]database
+	mutex     sync.Mutex
+	password  string
+	username  string
+}
+
+// database is a key space of the stand-in. Each key is either a string or a sorted set.
+type database struct {
+	expiries map[string]time.Time
+	strings  map[string]string
+	versions map[string]uint64 // Number of changes of each key, which discard the transactions watching it.
+	zsets    map[string]map[string]float64
+}
+
+// session is the state of a connection to the stand-in.
+type session struct {
+	aborted       bool       // A command could not be queued, thus the transaction is discarded by EXEC.
+	authenticated bool       // Only required if the stand-in has a password.
+	db            int        // Index of the selected database.
+	queued        [][]string // Commands of the transaction, which is started by MULTI if not nil.
+	watched       map[watchedKey]uint64
+}
+
+// watchedKey is a key of a database, which is watched by a session.
+type watchedKey struct {
+	db  int
+	key string
+}
+
+// NewServer creates an empty stand-in Redis, which does not require authentication.
+func NewServer() *Server {
+	a := &Server{}
+	for i := range a.databases {
+		a.databases[i] = database{
+			expiries: make(map[string]time.Time),
+			strings:  make(map[string]string),
+			versions: make(map[string]uint64),
+			zsets:    make(map[string]map[string]float64),
+		}
+	}
+	return a
+}
+
+// Serve accepts connections on the listener and serves their commands until the listener is closed.
+func (a *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go a.serve(c)
+	}
+}
+
+// WithPassword requires every connection to authenticate by AUTH with the password of the user.
+// An empty username is the default user, which is also used by AUTH without a username.
+func (a *Server) WithPassword(username, password string) *Server {
+	a.username, a.password = cmp.Or(username, "default"), password
+	return a
+}
+
+// serve reads the commands of the connection and writes their replies until the connection is closed.
+func (a *Server) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	s := &session{authenticated: a.password == "", watched: make(map[watchedKey]uint64)}
+	for {
+		value, err := readValue(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		values, _ := value.([]any)
+		for _, v := range values {
+			arg, _ := v.(string)
+			args = append(args, arg)
+		}
+		var reply any = respError("ERR Protocol error: expected a command")
+		if len(args) > 0 {
+			args[0] = strings.ToUpper(args[0])
+			a.mutex.Lock()
+			reply = a.handle(s, args)
+			a.mutex.Unlock()
+		}
+		if err := writeValue(w, reply); err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle executes or queues the command of the session. It must be called with the lock held.
+func (a *Server) handle(s *session, args []string) any {
+	switch {
+	case args[0] == "AUTH":
+		return a.auth(s, args)
+	case !s.authenticated:
+		return respError("NOAUTH Authentication required.")
+	case s.queued == nil:
+		return a.execute(s, args)
+	}
+
+	// The transaction is started, thus the commands are queued until EXEC or DISCARD.
+	switch args[0] {
+	case "DISCARD":
+		s.aborted, s.queued = false, nil
+		clear(s.watched)
+		return simpleString("OK")
+	case "EXEC":
+		return a.exec(s)
+	case "MULTI":
+		return respError("ERR MULTI calls can not be nested")
+	case "WATCH":
+		return respError("ERR WATCH inside MULTI is not allowed")
+	}
+	if reply, ok := a.validate(args); !ok {
+		s.aborted = true
+		return reply
+	}
+	s.queued = append(s.queued, args)
+	return simpleString("QUEUED")
+}
+
+// auth authenticates the session by the password of the user.
+func (a *Server) auth(s *session, args []string) any {
+	username, password := "default", ""
+	switch len(args) {
+	case 2:
+		password = args[1]
+	case 3:
+		username, password = args[1], args[2]
+	default:
+		return wrongArity(args[0])
+	}
+	if a.password == "" {
+		return respError("ERR AUTH called without any password configured for the default user.")
+	}
+	if username != a.username || password != a.password {
+		return respError("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	s.authenticated = true
+	return simpleString("OK")
+}
+
+// exec executes the queued commands, unless the transaction is aborted or a watched key has been changed.
+func (a *Server) exec(s *session) any {
+	defer func() {
+		s.aborted, s.queued = false, nil
+		clear(s.watched)
+	}()
+	if s.aborted {
+		return respError("EXECABORT Transaction discarded because of previous errors.")
+	}
+	for w, version := range s.watched {
+		a.databases[w.db].expire(w.key)
+		if a.databases[w.db].versions[w.key] != version {
+			return nil
+		}
+	}
+	replies := make([]any, 0, len(s.queued))
+	for _, args := range s.queued {
+		replies = append(replies, a.execute(s, args))
+	}
+	return replies
+}
+
+// execute executes the command outside of a transaction.
+func (a *Server) execute(s *session, args []string) any {
+	if reply, ok := a.validate(args); !ok {
+		return reply
+	}
+	db := &a.databases[s.db]
+	keys := args[1:]
+	switch args[0] {
+	case "DEL":
+		var n int64
+		for _, key := range keys {
+			if db.exists(key) {
+				db.remove(key)
+				n++
+			}
+		}
+		return n
+	case "DISCARD":
+		return respError("ERR DISCARD without MULTI")
+	case "EXEC":
+		return respError("ERR EXEC without MULTI")
+	case "EXISTS":
+		var n int64
+		for _, key := range keys {
+			if db.exists(key) {
+				n++
+			}
+		}
+		return n
+	case "GET":
+		return db.get(args[1])
+	case "MGET":
+		values := make([]any, 0, len(keys))
+		for _, key := range keys {
+			value := db.get(key)
+			if _, ok := value.(respError); ok {
+				value = nil
+			}
+			values = append(values, value)
+		}
+		return values
+	case "MULTI":
+		s.queued = [][]string{}
+		return simpleString("OK")
+	case "PEXPIREAT":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return respError("ERR value is not an integer or out of range")
+		}
+		if !db.exists(args[1]) {
+			return int64(0)
+		}
+		db.expiries[args[1]] = time.UnixMilli(ms)
+		db.versions[args[1]]++
+		db.expire(args[1])
+		return int64(1)
+	case "PING":
+		return simpleString("PONG")
+	case "PTTL":
+		if !db.exists(args[1]) {
+			return int64(-2)
+		}
+		at, exists := db.expiries[args[1]]
+		if !exists {
+			return int64(-1)
+		}
+		return int64(time.Until(at) / time.Millisecond)
+	case "SELECT":
+		index, err := strconv.Atoi(args[1])
+		if err != nil || index < 0 || index >= maxDatabases {
+			return respError("ERR DB index is out of range")
+		}
+		s.db = index
+		return simpleString("OK")
+	case "SET":
+		db.remove(args[1])
+		db.strings[args[1]] = args[2]
+		return simpleString("OK")
+	case "UNWATCH":
+		clear(s.watched)
+		return simpleString("OK")
+	case "WATCH":
+		for _, key := range keys {
+			db.expire(key)
+			s.watched[watchedKey{db: s.db, key: key}] = db.versions[key]
+		}
+		return simpleString("OK")
+	case "ZADD":
+		return db.zadd(args[1], args[2:])
+	case "ZRANGEBYLEX":
+		return db.zrangeByLex(args[1], args[2], args[3], args[4:])
+	case "ZRANGEBYSCORE":
+		return db.zrangeByScore(args[1], args[2], args[3])
+	case "ZREM":
+		return db.zrem(args[1], args[2:])
+	}
+	return nil
+}
+
+// validate checks that the command is known and has the required number of arguments.
+// Otherwise, it returns the error reply.
+func (a *Server) validate(args []string) (any, bool) {
+	arities := map[string]int{
+		"DEL": -2, "DISCARD": 1, "EXEC": 1, "EXISTS": -2, "GET": 2, "MGET": -2, "MULTI": 1, "PEXPIREAT": 3, "PING": 1,
+		"PTTL": 2, "SELECT": 2, "SET": 3, "UNWATCH": 1, "WATCH": -2, "ZADD": -4, "ZRANGEBYLEX": -4, "ZRANGEBYSCORE": 4, "ZREM": -3,
+	}
+	// A negative arity is the minimum number of arguments.
+	arity, known := arities[args[0]]
+	switch {
+	case !known:
+		return respError("ERR unknown command '" + strings.ToLower(args[0]) + "'"), false
+	case arity >= 0 && len(args) != arity, arity < 0 && len(args) < -arity:
+		return wrongArity(args[0]), false
+	case args[0] == "ZADD" && len(args)%2 != 0:
+		return respError("ERR syntax error"), false
+	}
+	return nil, true
+}
+
+// exists reports whether the key exists and has not expired.
+func (db *database) exists(key string) bool {
+	db.expire(key)
+	_, isString := db.strings[key]
+	_, isZSet := db.zsets[key]
+	return isString || isZSet
+}
+
+// expire removes the key, if it has expired.
+func (db *database) expire(key string) {
+	if at, exists := db.expiries[key]; exists && !time.Now().Before(at) {
+		db.remove(key)
+	}
+}
+
+// get returns the value of the string key or nil, if the key does not exist.
+func (db *database) get(key string) any {
+	if !db.exists(key) {
+		return nil
+	}
+	value, isString := db.strings[key]
+	if !isString {
+		return respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return value
+}
+
+// remove removes the key and its expiry, which is a change of the key.
+func (db *database) remove(key string) {
+	delete(db.expiries, key)
+	delete(db.strings, key)
+	delete(db.zsets, key)
+	db.versions[key]++
+}
+
+// zadd adds the members with their scores to the sorted set and returns the number of new members.
+func (db *database) zadd(key string, pairs []string) any {
+	if _, isString := db.strings[key]; isString && db.exists(key) {
+		return respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	scores := make(map[string]float64, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseScore(pairs[i])
+		if err != nil {
+			return respError("ERR value is not a valid float")
+		}
+		scores[pairs[i+1]] = score
+	}
+	if !db.exists(key) {
+		db.zsets[key] = make(map[string]float64)
+	}
+	var added int64
+	for member, score := range scores {
+		if _, exists := db.zsets[key][member]; !exists {
+			added++
+		}
+		db.zsets[key][member] = score
+	}
+	db.versions[key]++
+	return added
+}
+
+// zrangeByLex returns the members of the sorted set between min and max in lexicographical order, which is the order
+// of a sorted set whose members have the same score. A bound is "-", "+" or a member prefixed by "[" if it is included
+// or "(" if it is excluded. The members may be limited by "LIMIT offset count".
+func (db *database) zrangeByLex(key, from, to string, limit []string) any {
+	lower, ok1 := parseLexBound(from)
+	upper, ok2 := parseLexBound(to)
+	if !ok1 || !ok2 {
+		return respError("ERR min or max not valid string range item")
+	}
+	offset, count := 0, -1
+	if len(limit) > 0 {
+		var err1, err2 error
+		if len(limit) != 3 || strings.ToUpper(limit[0]) != "LIMIT" {
+			return respError("ERR syntax error")
+		}
+		offset, err1 = strconv.Atoi(limit[1])
+		count, err2 = strconv.Atoi(limit[2])
+		if err1 != nil || err2 != nil {
+			return respError("ERR value is not an integer or out of range")
+		}
+	}
+	members := []string{}
+	if db.exists(key) {
+		for member := range db.zsets[key] {
+			if lower(member, true) && upper(member, false) {
+				members = append(members, member)
+			}
+		}
+	}
+	slices.Sort(members)
+	members = members[min(max(offset, 0), len(members)):]
+	if count >= 0 {
+		members = members[:min(count, len(members))]
+	}
+	values := make([]any, 0, len(members))
+	for _, member := range members {
+		values = append(values, member)
+	}
+	return values
+}
+
+// zrangeByScore returns the members of the sorted set with a score between min and max in the order of their scores.
+func (db *database) zrangeByScore(key, from, to string) any {
+	lower, err1 := parseScore(from)
+	upper, err2 := parseScore(to)
+	if err1 != nil || err2 != nil {
+		return respError("ERR min or max is not a float")
+	}
+	members := []string{}
+	if db.exists(key) {
+		for member, score := range db.zsets[key] {
+			if lower <= score && score <= upper {
+				members = append(members, member)
+			}
+		}
+	}
+	zset := db.zsets[key]
+	slices.SortFunc(members, func(a, b string) int {
+		return cmp.Or(cmp.Compare(zset[a], zset[b]), strings.Compare(a, b))
+	})
+	values := make([]any, 0, len(members))
+	for _, member := range members {
+		values = append(values, member)
+	}
+	return values
+}
+
+// zrem removes the members from the sorted set and returns the number of removed members.
+func (db *database) zrem(key string, members []string) any {
+	if !db.exists(key) {
+		return int64(0)
+	}
+	var removed int64
+	for _, member := range members {
+		if _, exists := db.zsets[key][member]; exists {
+			delete(db.zsets[key], member)
+			removed++
+		}
+	}
+	if len(db.zsets[key]) == 0 {
+		db.remove(key)
+	} else if removed > 0 {
+		db.versions[key]++
+	}
+	return removed
+}
+
+// parseLexBound parses a bound of a lexicographical range. The returned function reports whether
+// a member is on the inner side of the bound, which is the lower one if lower is true.
+func parseLexBound(s string) (func(member string, lower bool) bool, bool) {
+	switch {
+	case s == "-":
+		return func(_ string, lower bool) bool { return lower }, true
+	case s == "+":
+		return func(_ string, lower bool) bool { return !lower }, true
+	case strings.HasPrefix(s, "["):
+		return func(member string, lower bool) bool {
+			return (lower && member >= s[1:]) || (!lower && member <= s[1:])
+		}, true
+	case strings.HasPrefix(s, "("):
+		return func(member string, lower bool) bool {
+			return (lower && member > s[1:]) || (!lower && member < s[1:])
+		}, true
+	}
+	return nil, false
+}
+
+// parseScore parses a score of a sorted set, which may be "-inf" or "+inf".
+func parseScore(s string) (float64, error) {
+	switch s {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// wrongArity returns the error reply of a command with a wrong number of arguments.
+func wrongArity(command string) respError {
+	return respError("ERR wrong number of arguments for '" + strings.ToLower(command) + "' command")
+}
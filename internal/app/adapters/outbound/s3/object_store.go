@@ -0,0 +1,314 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+const (
+	// DefaultRegion is the region of the signatures, if no region is configured.
+	DefaultRegion = "us-east-1"
+	// maxKeys is the maximum number of names, which S3 returns in a page of a listing.
+	maxKeys = 1000
+)
+
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+)
+
+// ObjectStore is an object store in a bucket of an S3-compatible object storage, which stores the value
+// of each key as an object, thus the values are not limited by the size of a database row.
+// The name of an object is the configured prefix followed by the key. The requests use the path-style
+// addressing of the bucket and are signed by AWS Signature Version 4.
+// The conditional writes read the current value and write the object only if its ETag has not changed
+// in the meantime (If-Match), or if it does not exist (If-None-Match), which the storage must support.
+// It implements the ports.ObjectPort interface.
+type ObjectStore struct {
+	bucket      string
+	client      *http.Client
+	credentials credentials
+	endpoint    string
+	prefix      string
+}
+
+// errorResponse is the body of a failed request.
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// listBucketResult is the body of a page of a listing by ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	EncodingType          string `xml:"EncodingType"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// NewObjectStore creates an object store for the configured bucket.
+// The region defaults to DefaultRegion and the endpoint to the endpoint of AWS in the region.
+func NewObjectStore(cfg config.PortS3) *ObjectStore {
+	a := &ObjectStore{
+		bucket: cfg.Bucket,
+		client: http.DefaultClient,
+		credentials: credentials{
+			accessKeyID:     cfg.AccessKeyID,
+			region:          cfg.Region,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+		},
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		prefix:   cfg.Prefix,
+	}
+	if a.credentials.region == "" {
+		a.credentials.region = DefaultRegion
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://s3." + a.credentials.region + ".amazonaws.com"
+	}
+	return a
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	// An empty old value would require the key to not exist, which never equals a stored value.
+	if old == "" {
+		return ports.ErrorValueChanged
+	}
+	etag, err := a.match(ctx, key, old)
+	if err != nil {
+		return err
+	}
+	res, err := a.do(ctx, http.MethodDelete, key, nil, http.Header{"If-Match": {etag}}, nil)
+	if errors.Is(err, ErrorKeyDoesNotExist) {
+		return ports.ErrorValueChanged
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	header := http.Header{"If-None-Match": {"*"}}
+	if old != "" {
+		etag, err := a.match(ctx, key, old)
+		if err != nil {
+			return err
+		}
+		header = http.Header{"If-Match": {etag}}
+	}
+	return a.put(ctx, key, value, header)
+}
+
+// Delete removes a key and its associated value from the store.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	res, err := a.do(ctx, http.MethodDelete, key, nil, http.Header{}, nil)
+	if errors.Is(err, ErrorKeyDoesNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Get retrieves the value associated with the given key.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	value, _, err = a.get(ctx, key)
+	return value, err
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	return a.put(ctx, key, value, http.Header{})
+}
+
+// Scan returns the keys which are selected by the range in ascending order.
+// The keys are listed page by page by ListObjectsV2, until the range ends or one key more than the limit is found.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	query := url.Values{
+		// The keys are URL encoded, because XML cannot carry every byte, e.g. the NUL byte of a namespace.
+		"encoding-type": {"url"},
+		"list-type":     {"2"},
+		"max-keys":      {strconv.Itoa(maxKeys)},
+		"prefix":        {a.prefix + r.Prefix},
+	}
+	if r.Limit > 0 && r.Limit < maxKeys {
+		query.Set("max-keys", strconv.Itoa(r.Limit+1))
+	}
+	if after := startAfter(r); after != "" {
+		query.Set("start-after", a.prefix+after)
+	}
+
+	var keys []string
+	for {
+		var result listBucketResult
+		if err := a.list(ctx, query, &result); err != nil {
+			return page, err
+		}
+		for _, content := range result.Contents {
+			key := strings.TrimPrefix(content.Key, a.prefix)
+			if r.End != "" && key >= r.End {
+				return r.Paginate(keys), nil
+			}
+			if !r.Contains(key) {
+				continue
+			}
+			// Only one key more than the limit is required to know whether there is a next page.
+			keys = append(keys, key)
+			if r.Limit > 0 && len(keys) > r.Limit {
+				return r.Paginate(keys), nil
+			}
+		}
+		if !result.IsTruncated {
+			return r.Paginate(keys), nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// WithClient sets the HTTP client which is used to call the object storage.
+func (a *ObjectStore) WithClient(client *http.Client) *ObjectStore {
+	a.client = client
+	return a
+}
+
+// do sends the signed request for the object of the key or, if the key is empty, for the bucket.
+// It returns the response of a successful request, whose body must be closed by the caller.
+// Rejected requests are permanent errors, because they are not resolved by repeating the call.
+func (a *ObjectStore) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	path := "/" + a.bucket
+	if key != "" {
+		path += "/" + a.prefix + key
+	}
+	target := a.endpoint + uriEncode(path, false)
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, ports.Permanent(err)
+	}
+	req.Header = header
+	a.credentials.sign(req, hashPayload(body), time.Now())
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	var status errorResponse
+	_ = xml.NewDecoder(res.Body).Decode(&status)
+	err = fmt.Errorf("s3 %s %s failed with status %d: %s %s", method, path, res.StatusCode, status.Code, status.Message)
+	switch {
+	case res.StatusCode == http.StatusNotFound && status.Code != "NoSuchBucket" && key != "":
+		return nil, ErrorKeyDoesNotExist
+	case res.StatusCode == http.StatusPreconditionFailed, res.StatusCode == http.StatusConflict:
+		// A conflict is returned, if a concurrent conditional write of the object has not been completed yet.
+		return nil, ports.ErrorValueChanged
+	case res.StatusCode < 500:
+		return nil, ports.Permanent(err)
+	default:
+		return nil, err
+	}
+}
+
+// get returns the value and the ETag of the object of the key.
+func (a *ObjectStore) get(ctx context.Context, key string) (value, etag string, err error) {
+	res, err := a.do(ctx, http.MethodGet, key, nil, http.Header{}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), res.Header.Get("ETag"), nil
+}
+
+// list decodes a page of the listing of the objects of the bucket into the result.
+func (a *ObjectStore) list(ctx context.Context, query url.Values, result *listBucketResult) error {
+	res, err := a.do(ctx, http.MethodGet, "", query, http.Header{}, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := xml.NewDecoder(res.Body).Decode(result); err != nil {
+		return err
+	}
+	// A server which does not support the encoding returns the keys as they are.
+	if result.EncodingType != "url" {
+		return nil
+	}
+	for i, content := range result.Contents {
+		key, err := url.QueryUnescape(content.Key)
+		if err != nil {
+			return ports.Permanent(err)
+		}
+		result.Contents[i].Key = key
+	}
+	return nil
+}
+
+// match returns the ETag of the object of the key, if its value equals old.
+// Otherwise, it returns ErrorValueChanged.
+func (a *ObjectStore) match(ctx context.Context, key, old string) (string, error) {
+	current, etag, err := a.get(ctx, key)
+	switch {
+	case errors.Is(err, ErrorKeyDoesNotExist):
+		return "", ports.ErrorValueChanged
+	case err != nil:
+		return "", err
+	case current != old || etag == "":
+		return "", ports.ErrorValueChanged
+	default:
+		return etag, nil
+	}
+}
+
+// put writes the value as the object of the key, if the conditions of the header are met.
+func (a *ObjectStore) put(ctx context.Context, key, value string, header http.Header) error {
+	header.Set("Content-Type", "application/octet-stream")
+	res, err := a.do(ctx, http.MethodPut, key, nil, header, []byte(value))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// startAfter returns the key after which the listing starts. S3 only supports an exclusive bound,
+// thus an inclusive start is replaced by the start without its last byte, which is less than the start.
+// The keys in between are skipped by the range.
+func startAfter(r ports.Range[string]) string {
+	if r.Start == "" || r.After >= r.Start {
+		return r.After
+	}
+	return max(r.After, r.Start[:len(r.Start)-1])
+}
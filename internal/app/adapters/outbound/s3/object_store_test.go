@@ -0,0 +1,200 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/s3"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// newServer starts a stand-in S3 with the bucket "store", which requires the test credentials.
+func newServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(s3.NewServer("store").WithCredentials(testAccessKeyID, testSecretAccessKey))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newStore creates a store for the objects with the prefix in the bucket of the stand-in.
+func newStore(server *httptest.Server, prefix string) *s3.ObjectStore {
+	return s3.NewObjectStore(config.PortS3{
+		AccessKeyID:     testAccessKeyID,
+		Bucket:          "store",
+		Endpoint:        server.URL,
+		Prefix:          prefix,
+		SecretAccessKey: testSecretAccessKey,
+	}).WithClient(server.Client())
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(newServer(t), "objects/")
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, s3.ErrorKeyDoesNotExist)
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "deleting a missing key must not fail", err, nil)
+}
+
+func TestObjectStore_Large_Value_And_Special_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(newServer(t), "")
+
+	// The value is larger than a row of most databases and the keys contain characters, which must be encoded.
+	large := strings.Repeat("0123456789abcdef", 1<<20)
+	for _, key := range []string{"users/1/avatar", "a b+c", "ä/ö?x=1&y=%20", "dir//../file"} {
+		err := store.Put(ctx, key, large)
+		assert.That(t, "err must be nil", err, nil)
+		value, err := store.Get(ctx, key)
+		assert.That(t, "err must be nil", err, nil)
+		assert.That(t, "value must be complete", len(value), len(large))
+	}
+}
+
+func TestObjectStore_Prefix_Separates_Stores(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t)
+	a, b := newStore(server, "a/"), newStore(server, "b/")
+	_ = a.Put(ctx, "foo", "bar")
+
+	_, err := b.Get(ctx, "foo")
+	assert.That(t, "key must not exist in the other store", err, s3.ErrorKeyDoesNotExist)
+	page, err := b.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "other store must be empty", page.Keys, []string{})
+	page, err = a.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "keys must not contain the prefix", page.Keys, []string{"foo"})
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(newServer(t), "objects/")
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3"} {
+		_ = store.Put(ctx, key, "value")
+	}
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"b/1", "b/2"}, Next: "b/2"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"b/3"}})
+
+	// The listing starts before the inclusive start, thus a second listing page is required.
+	page, err = store.Scan(ctx, ports.Range[string]{Start: "b/2", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "page from the start must be correct", page, ports.Page[string]{Keys: []string{"b/2", "b/3"}, Next: "b/3"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{Start: "a/1", End: "c/1"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "range must be correct", page.Keys, []string{"a/1", "b/1", "b/2", "b/3"})
+}
+
+func TestObjectStore_Scan_Namespaced_Keys(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(newServer(t), "")
+	// The NUL byte separates a namespace from its keys and cannot be carried by XML without an encoding.
+	for _, key := range []string{"team-a\x00b", "team-a\x00a+b%20", "team-a\x00c", "team-b\x00a"} {
+		_ = store.Put(ctx, key, "value")
+	}
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "team-a\x00", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"team-a\x00a+b%20", "team-a\x00b"}, Next: "team-a\x00b"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "team-a\x00", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"team-a\x00c"}})
+
+	// The listing continues after a full page of S3 with a key containing the NUL byte.
+	for i := range 1001 {
+		_ = store.Put(ctx, fmt.Sprintf("team-c\x00%04d", i), "value")
+	}
+	page, err = store.Scan(ctx, ports.Range[string]{Prefix: "team-c\x00"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "all keys must be listed", len(page.Keys), 1001)
+	assert.That(t, "last key must be correct", page.Keys[1000], "team-c\x001000")
+}
+
+func TestObjectStore_CompareAndSwap_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(newServer(t), "objects/")
+
+	err := store.CompareAndSwap(ctx, "foo", "", "bar")
+	assert.That(t, "creating a new key must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "", "baz")
+	assert.That(t, "creating an existing key must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "baz")
+	assert.That(t, "swapping the current value must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "qux")
+	assert.That(t, "swapping an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "missing", "bar", "qux")
+	assert.That(t, "swapping a missing key must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "bar")
+	assert.That(t, "deleting an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting the current value must succeed", err, nil)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting a missing key must fail", err, ports.ErrorValueChanged)
+}
+
+func TestObjectStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t)
+
+	// A wrong secret is rejected by the signature, which is not resolved by repeating the call.
+	store := s3.NewObjectStore(config.PortS3{
+		AccessKeyID:     testAccessKeyID,
+		Bucket:          "store",
+		Endpoint:        server.URL,
+		SecretAccessKey: "wrong",
+	}).WithClient(server.Client())
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "invalid signature must not be transient", ports.IsTransient(err), false)
+
+	// A missing bucket is not a missing key.
+	store = s3.NewObjectStore(config.PortS3{
+		AccessKeyID:     testAccessKeyID,
+		Bucket:          "missing",
+		Endpoint:        server.URL,
+		SecretAccessKey: testSecretAccessKey,
+	}).WithClient(server.Client())
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must not be ErrorKeyDoesNotExist", err == s3.ErrorKeyDoesNotExist, false)
+	assert.That(t, "missing bucket must not be transient", ports.IsTransient(err), false)
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`))
+	}))
+	defer unavailable.Close()
+	_, err = newStore(unavailable, "").Get(ctx, "foo")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "unavailable service must be transient", ports.IsTransient(err), true)
+}
@@ -0,0 +1,229 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a local stand-in of an S3-compatible object storage, which holds the objects of its buckets
+// in memory. It serves the requests used by the ObjectStore, i.e. GetObject, PutObject and DeleteObject
+// with the conditions If-Match and If-None-Match and ListObjectsV2. It is only compiled into the tests of the package.
+type Server struct {
+	buckets     map[string]map[string][]byte
+	credentials map[string]string // Secret access keys by access key ID.
+	mutex       sync.Mutex
+}
+
+// listResult is the body of a page of a listing, which is returned by the stand-in.
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Contents              []listContent
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	KeyCount              int
+	MaxKeys               int
+	Name                  string
+	NextContinuationToken string `xml:",omitempty"`
+	Prefix                string
+}
+
+// listContent is an object of a page of a listing.
+type listContent struct {
+	XMLName xml.Name `xml:"Contents"`
+	ETag    string
+	Key     string
+	Size    int
+}
+
+// NewServer creates a stand-in S3 with the given empty buckets, which accepts unsigned requests.
+func NewServer(buckets ...string) *Server {
+	a := &Server{buckets: make(map[string]map[string][]byte)}
+	for _, bucket := range buckets {
+		a.buckets[bucket] = make(map[string][]byte)
+	}
+	return a
+}
+
+// ServeHTTP handles the requests for a bucket at "/{bucket}" and for its objects at "/{bucket}/{key}".
+// The path is not cleaned like by a http.ServeMux, because the name of an object may contain any characters.
+func (a *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if code := a.authenticate(r, body); code != "" {
+		writeError(w, http.StatusForbidden, code)
+		return
+	}
+
+	bucket, key, isObject := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	objects, exists := a.buckets[bucket]
+	switch {
+	case !exists:
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+	case !isObject && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		a.list(w, r, bucket, objects)
+	case isObject && r.Method == http.MethodGet:
+		data, exists := objects[key]
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case isObject && r.Method == http.MethodPut:
+		if !precondition(w, r, objects, key) {
+			return
+		}
+		objects[key] = body
+		w.Header().Set("ETag", etag(body))
+		w.WriteHeader(http.StatusOK)
+	case isObject && r.Method == http.MethodDelete:
+		if !precondition(w, r, objects, key) {
+			return
+		}
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// WithCredentials requires every request to be signed by the secret access key of the access key ID.
+func (a *Server) WithCredentials(accessKeyID, secretAccessKey string) *Server {
+	if a.credentials == nil {
+		a.credentials = make(map[string]string)
+	}
+	a.credentials[accessKeyID] = secretAccessKey
+	return a
+}
+
+// authenticate verifies the signature and the payload hash of the request, if credentials are required.
+// It returns the code of the error or an empty string, if the request is authentic.
+func (a *Server) authenticate(r *http.Request, body []byte) string {
+	if a.credentials == nil {
+		return ""
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), algorithm+" ")
+	if !ok {
+		return "AccessDenied"
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+	accessKeyID, scope, _ := strings.Cut(fields["Credential"], "/")
+	secret, exists := a.credentials[accessKeyID]
+	if !exists {
+		return "InvalidAccessKeyId"
+	}
+	date := r.Header.Get("X-Amz-Date")
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 || len(date) != len(dateFormat) || parts[0] != date[:8] || parts[2] != service {
+		return "AuthorizationHeaderMalformed"
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != hashPayload(body) {
+		return "XAmzContentSHA256Mismatch"
+	}
+
+	c := credentials{region: parts[1], secretAccessKey: secret}
+	signature := c.signature(canonicalRequest(r, strings.Split(fields["SignedHeaders"], ";")), date, scope)
+	if !hmac.Equal([]byte(signature), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+// list writes a page of the names of the objects of the bucket, which are selected by the query, in ascending order.
+// The continuation token is the encoded last name of the previous page. Like S3, the names are URL encoded
+// on request, because XML cannot carry every byte, e.g. the NUL byte.
+func (a *Server) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string][]byte) {
+	query := r.URL.Query()
+	token, err := base64.RawURLEncoding.DecodeString(query.Get("continuation-token"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	after := max(query.Get("start-after"), string(token))
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	if err != nil || maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		if strings.HasPrefix(name, query.Get("prefix")) && name > after {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	encode := func(name string) string { return name }
+	result := listResult{MaxKeys: maxKeys, Name: bucket}
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+		result.EncodingType = "url"
+	}
+	result.Prefix = encode(query.Get("prefix"))
+	if len(names) > maxKeys {
+		names = names[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(names[maxKeys-1]))
+	}
+	for _, name := range names {
+		result.Contents = append(result.Contents, listContent{ETag: etag(objects[name]), Key: encode(name), Size: len(objects[name])})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// etag returns the ETag of the data, which is its quoted MD5 hash like that of a single part upload.
+func etag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// precondition checks the conditions of a write of the object and writes the error, if they are not met.
+func precondition(w http.ResponseWriter, r *http.Request, objects map[string][]byte, key string) bool {
+	data, exists := objects[key]
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return false
+		}
+		if match != etag(data) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return false
+		}
+	}
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return false
+	}
+	return true
+}
+
+// writeError writes the error response with the code.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: http.StatusText(status)})
+}
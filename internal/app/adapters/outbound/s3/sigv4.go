@@ -0,0 +1,144 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Requests are signed by AWS Signature Version 4 in the Authorization header,
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+// The signature covers the method, the path, the query, the payload and every header which is set
+// before the request is signed, thus the conditions of a write cannot be changed on the way.
+
+const (
+	// algorithm is the name of the signing algorithm.
+	algorithm = "AWS4-HMAC-SHA256"
+	// dateFormat is the format of the X-Amz-Date header.
+	dateFormat = "20060102T150405Z"
+	// service is the name of the service in the scope of a signature.
+	service = "s3"
+)
+
+// credentials are the keys, which sign the requests for a region.
+type credentials struct {
+	accessKeyID     string
+	region          string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// sign adds the headers of the signature to the request with the SHA-256 hash of its payload.
+func (c credentials) sign(req *http.Request, payloadHash string, now time.Time) {
+	date := now.UTC().Format(dateFormat)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", date)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		if name = strings.ToLower(name); name != "authorization" {
+			signed = append(signed, name)
+		}
+	}
+	slices.Sort(signed)
+
+	scope := date[:8] + "/" + c.region + "/" + service + "/aws4_request"
+	signature := c.signature(canonicalRequest(req, signed), date, scope)
+	req.Header.Set("Authorization", algorithm+" Credential="+c.accessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+}
+
+// signature returns the hex encoded signature of the canonical request at the date within the scope.
+func (c credentials) signature(canonical, date, scope string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := algorithm + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	// The signing key is derived from the secret by the date, the region and the service of the scope.
+	key := []byte("AWS4" + c.secretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalRequest returns the canonical form of the request with the signed headers, which must be sorted.
+func canonicalRequest(req *http.Request, signed []string) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(uriEncode(req.URL.Path, false) + "\n")
+	b.WriteString(canonicalQuery(req.URL.Query()) + "\n")
+
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		b.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signed, ";") + "\n")
+	b.WriteString(req.Header.Get("X-Amz-Content-Sha256"))
+	return b.String()
+}
+
+// canonicalQuery returns the parameters of the query sorted by their names and values,
+// which are encoded by uriEncode.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var params []string
+	for _, name := range names {
+		values := slices.Clone(query[name])
+		slices.Sort(values)
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// hashPayload returns the hex encoded SHA-256 hash of the payload.
+func hashPayload(payload []byte) string {
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode percent-encodes every byte of the string except the unreserved characters.
+// A slash is only encoded if the string is a part of the query.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,349 @@
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+const (
+	// DefaultTable is the name of the table, which is created by the "cloud-spanner-setup" recipe.
+	DefaultTable = "KeyValueStore"
+	// DefaultURL is the base URL of the REST API of Cloud Spanner.
+	DefaultURL = "https://spanner.googleapis.com"
+)
+
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = ports.ErrorKeyDoesNotExist
+	// errSessionNotFound is returned when Spanner has deleted a session, e.g. because it was idle for too long.
+	errSessionNotFound = errors.New("session not found")
+)
+
+// ObjectStore is an object store in a table of Cloud Spanner with the columns Key and Value.
+// It uses the REST API of Spanner, thus it works with the local emulator as well.
+// The calls of Spanner are authorized by the refreshed access tokens of the metadata server,
+// unless a static token is configured, which expires after about an hour and is only meant for tests.
+// The conditional writes read and write the keys in a read-write transaction, which Spanner aborts
+// if a concurrent transaction changes the keys. An aborted transaction is a transient error.
+// It implements the ports.ObjectPort and ports.BatchPort interfaces.
+type ObjectStore struct {
+	client   *http.Client
+	database string   // Name of the database, e.g. "projects/p/instances/i/databases/d".
+	idle     []string // Names of the sessions, which are not used by a call.
+	mutex    sync.Mutex
+	table    string
+	token    string          // Static access token, which is never refreshed.
+	tokens   *metadataTokens // Refreshed access tokens, if no static token is configured.
+	url      string
+}
+
+// NewObjectStore creates an object store for the table of the configured database.
+// The URL defaults to DefaultURL and the table to DefaultTable. Without a static token,
+// the access tokens are requested from the metadata server at the configured URL, which
+// defaults to DefaultMetadataURL for Spanner itself. The emulator requires no token at all.
+func NewObjectStore(cfg config.PortCloudSpanner) *ObjectStore {
+	a := &ObjectStore{
+		client:   http.DefaultClient,
+		database: fmt.Sprintf("projects/%s/instances/%s/databases/%s", cfg.ProjectID, cfg.InstanceID, cfg.DatabaseID),
+		table:    cfg.Table,
+		token:    cfg.Token,
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+	}
+	if a.table == "" {
+		a.table = DefaultTable
+	}
+	if a.url == "" {
+		a.url = DefaultURL
+	}
+	metadataURL := cfg.MetadataURL
+	if metadataURL == "" && a.url == DefaultURL {
+		metadataURL = DefaultMetadataURL
+	}
+	if a.token == "" && metadataURL != "" {
+		a.tokens = &metadataTokens{url: strings.TrimSuffix(metadataURL, "/")}
+	}
+	return a
+}
+
+// Apply applies either all writes or none of them in a single read-write transaction.
+func (a *ObjectStore) Apply(ctx context.Context, writes []ports.Write[string, string]) (err error) {
+	return a.transact(ctx, writes)
+}
+
+// CompareAndDelete removes the key only if its current value equals old.
+func (a *ObjectStore) CompareAndDelete(ctx context.Context, key, old string) (err error) {
+	// An empty old value would require the key to not exist, which never equals a stored value.
+	if old == "" {
+		return ports.ErrorValueChanged
+	}
+	return a.transact(ctx, []ports.Write[string, string]{{Key: key, Old: old}})
+}
+
+// CompareAndSwap sets the value of the key only if its current value equals old.
+// An empty old value requires the key to not exist.
+func (a *ObjectStore) CompareAndSwap(ctx context.Context, key, old, value string) (err error) {
+	return a.transact(ctx, []ports.Write[string, string]{{Key: key, Old: old, Value: value}})
+}
+
+// Delete removes a key and its associated value from the store.
+// If the key does not exist, it silently returns without an error.
+func (a *ObjectStore) Delete(ctx context.Context, key string) (err error) {
+	return a.withSession(ctx, func(session string) error {
+		return a.commit(ctx, session, commitRequest{
+			Mutations:            []mutation{a.delete(key)},
+			SingleUseTransaction: &transactionOptions{ReadWrite: &struct{}{}},
+		})
+	})
+}
+
+// Get retrieves the value associated with the given key by a strong read.
+// If the key does not exist, it returns an error (ErrorKeyDoesNotExist).
+func (a *ObjectStore) Get(ctx context.Context, key string) (value string, err error) {
+	err = a.withSession(ctx, func(session string) error {
+		rows, err := a.query(ctx, session, strongRead(), executeSQLRequest{
+			Params:     map[string]any{"key": key},
+			ParamTypes: map[string]paramType{"key": typeString},
+			SQL:        fmt.Sprintf("SELECT `Value` FROM `%s` WHERE `Key` = @key", a.table),
+		})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return ErrorKeyDoesNotExist
+		}
+		value = rows[0].at(0)
+		return nil
+	})
+	return value, err
+}
+
+// Put inserts or updates the value associated with the given key in the store.
+func (a *ObjectStore) Put(ctx context.Context, key, value string) (err error) {
+	return a.withSession(ctx, func(session string) error {
+		return a.commit(ctx, session, commitRequest{
+			Mutations:            []mutation{a.insertOrUpdate(key, value)},
+			SingleUseTransaction: &transactionOptions{ReadWrite: &struct{}{}},
+		})
+	})
+}
+
+// Scan returns the keys which are selected by the range in ascending order.
+// The range is evaluated by Spanner, which only returns one key more than the limit of the page.
+func (a *ObjectStore) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	req := executeSQLRequest{
+		Params:     map[string]any{"prefix": r.Prefix, "start": r.Start},
+		ParamTypes: map[string]paramType{"prefix": typeString, "start": typeString},
+		SQL:        fmt.Sprintf("SELECT `Key` FROM `%s` WHERE STARTS_WITH(`Key`, @prefix) AND `Key` >= @start", a.table),
+	}
+	if r.After != "" {
+		req.Params["after"], req.ParamTypes["after"] = r.After, typeString
+		req.SQL += " AND `Key` > @after"
+	}
+	if r.End != "" {
+		req.Params["end"], req.ParamTypes["end"] = r.End, typeString
+		req.SQL += " AND `Key` < @end"
+	}
+	req.SQL += " ORDER BY `Key`"
+	if r.Limit > 0 {
+		// INT64 values are encoded as strings by the REST API.
+		req.Params["limit"], req.ParamTypes["limit"] = strconv.Itoa(r.Limit+1), typeInt64
+		req.SQL += " LIMIT @limit"
+	}
+
+	var keys []string
+	err = a.withSession(ctx, func(session string) error {
+		rows, err := a.query(ctx, session, strongRead(), req)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			keys = append(keys, row.at(0))
+		}
+		return nil
+	})
+	if err != nil {
+		return page, err
+	}
+	return r.Paginate(keys), nil
+}
+
+// WithClient sets the HTTP client which is used to call Spanner.
+func (a *ObjectStore) WithClient(client *http.Client) *ObjectStore {
+	a.client = client
+	return a
+}
+
+// acquire returns an idle session or creates a new one, because a session only runs one transaction at a time.
+func (a *ObjectStore) acquire(ctx context.Context) (string, error) {
+	a.mutex.Lock()
+	if n := len(a.idle); n > 0 {
+		session := a.idle[n-1]
+		a.idle = a.idle[:n-1]
+		a.mutex.Unlock()
+		return session, nil
+	}
+	a.mutex.Unlock()
+
+	var session sessionResponse
+	if err := a.call(ctx, a.database+"/sessions", struct{}{}, &session); err != nil {
+		return "", err
+	}
+	return session.Name, nil
+}
+
+// call sends the request to the method of the REST API and decodes the response into the result, if it is not nil.
+// Errors of the request itself are permanent, because they are not resolved by repeating the call.
+func (a *ObjectStore) call(ctx context.Context, method string, request, result any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/v1/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token := a.token
+	if a.tokens != nil {
+		if token, err = a.tokens.get(ctx, a.client); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var status errorResponse
+		_ = json.NewDecoder(res.Body).Decode(&status)
+		err := fmt.Errorf("spanner %s failed with status %d: %s", method, res.StatusCode, status.Error.Message)
+		switch res.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			return ports.Permanent(err)
+		case http.StatusNotFound:
+			if strings.Contains(method, "/sessions/") {
+				return fmt.Errorf("%w: %v", errSessionNotFound, err)
+			}
+		}
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// commit commits the mutations either in the transaction of the request or in a single-use transaction.
+func (a *ObjectStore) commit(ctx context.Context, session string, req commitRequest) error {
+	return a.call(ctx, session+":commit", req, nil)
+}
+
+// delete returns the mutation which deletes the key.
+func (a *ObjectStore) delete(key string) mutation {
+	return mutation{Delete: &deleteMutation{KeySet: keySet{Keys: [][]string{{key}}}, Table: a.table}}
+}
+
+// insertOrUpdate returns the mutation which sets the value of the key.
+func (a *ObjectStore) insertOrUpdate(key, value string) mutation {
+	return mutation{InsertOrUpdate: &writeMutation{Columns: []string{"Key", "Value"}, Table: a.table, Values: [][]string{{key, value}}}}
+}
+
+// query executes the SQL query of the request in the transaction and returns the rows of the result.
+func (a *ObjectStore) query(ctx context.Context, session string, tx transactionSelector, req executeSQLRequest) ([]row, error) {
+	req.Transaction = tx
+	var result resultSet
+	if err := a.call(ctx, session+":executeSql", req, &result); err != nil {
+		return nil, err
+	}
+	return result.Rows, nil
+}
+
+// release returns the session to the idle sessions, unless Spanner has deleted it.
+func (a *ObjectStore) release(session string, err error) {
+	if errors.Is(err, errSessionNotFound) {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.idle = append(a.idle, session)
+}
+
+// transact reads the current values of the keys of the writes in a read-write transaction
+// and commits the writes, if every key still has its old value. Otherwise the transaction is rolled back.
+func (a *ObjectStore) transact(ctx context.Context, writes []ports.Write[string, string]) error {
+	return a.withSession(ctx, func(session string) error {
+		var tx transaction
+		err := a.call(ctx, session+":beginTransaction", beginTransactionRequest{
+			Options: transactionOptions{ReadWrite: &struct{}{}},
+		}, &tx)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(writes))
+		for _, w := range writes {
+			keys = append(keys, w.Key)
+		}
+		rows, err := a.query(ctx, session, transactionSelector{ID: tx.ID}, executeSQLRequest{
+			Params:     map[string]any{"keys": keys},
+			ParamTypes: map[string]paramType{"keys": {ArrayElementType: &typeString, Code: "ARRAY"}},
+			SQL:        fmt.Sprintf("SELECT `Key`, `Value` FROM `%s` WHERE `Key` IN UNNEST(@keys)", a.table),
+		})
+		if err != nil {
+			a.rollback(ctx, session, tx.ID)
+			return err
+		}
+		values := make(map[string]string, len(rows))
+		for _, row := range rows {
+			values[row.at(0)] = row.at(1)
+		}
+
+		// Check every write before the first one is applied.
+		mutations := make([]mutation, 0, len(writes))
+		for _, w := range writes {
+			if current, exists := values[w.Key]; exists != (w.Old != "") || current != w.Old {
+				a.rollback(ctx, session, tx.ID)
+				return ports.ErrorValueChanged
+			}
+			if w.Value == "" {
+				mutations = append(mutations, a.delete(w.Key))
+			} else {
+				mutations = append(mutations, a.insertOrUpdate(w.Key, w.Value))
+			}
+		}
+		return a.commit(ctx, session, commitRequest{Mutations: mutations, TransactionID: tx.ID})
+	})
+}
+
+// rollback releases the locks of the transaction. It is not required for the consistency,
+// because an uncommitted transaction has no effect, thus its error is ignored.
+func (a *ObjectStore) rollback(ctx context.Context, session, id string) {
+	_ = a.call(ctx, session+":rollback", rollbackRequest{TransactionID: id}, nil)
+}
+
+// withSession calls the function with an idle session, which is released afterwards.
+func (a *ObjectStore) withSession(ctx context.Context, fn func(session string) error) error {
+	session, err := a.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(session)
+	a.release(session, err)
+	return err
+}
@@ -0,0 +1,245 @@
+package spanner_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/spanner"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+const (
+	testInstance = "test-instance"
+	testProject  = "test-project"
+)
+
+// newStore creates a new database with the table of the "cloud-spanner-setup" recipe in the local emulator,
+// whose REST API is expected at SPANNER_EMULATOR_URL or http://localhost:9020.
+// The test is skipped if the emulator is not available.
+func newStore(t *testing.T) *spanner.ObjectStore {
+	url := os.Getenv("SPANNER_EMULATOR_URL")
+	if url == "" {
+		url = "http://localhost:9020"
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(url + "/v1/projects/" + testProject + "/instanceConfigs")
+	if err != nil {
+		t.Skipf("spanner emulator is not available: %v", err)
+	}
+	res.Body.Close()
+
+	// The instance is shared by the tests, thus it may already exist.
+	status := emulatorCall(t, client, url+"/v1/projects/"+testProject+"/instances", map[string]any{
+		"instanceId": testInstance,
+		"instance": map[string]any{
+			"config":      "projects/" + testProject + "/instanceConfigs/emulator-config",
+			"displayName": "Test Instance",
+			"nodeCount":   1,
+		},
+	}, nil)
+	if status != http.StatusOK && status != http.StatusConflict {
+		t.Fatalf("instance creation failed with status %d", status)
+	}
+
+	database := fmt.Sprintf("test-%d", time.Now().UnixNano()%1e12)
+	var op struct {
+		Done bool   `json:"done"`
+		Name string `json:"name"`
+	}
+	status = emulatorCall(t, client, url+"/v1/projects/"+testProject+"/instances/"+testInstance+"/databases", map[string]any{
+		"createStatement": "CREATE DATABASE `" + database + "`",
+		"extraStatements": []string{"CREATE TABLE KeyValueStore (Key STRING(MAX) NOT NULL, Value STRING(MAX)) PRIMARY KEY (Key)"},
+	}, &op)
+	if status != http.StatusOK {
+		t.Fatalf("database creation failed with status %d", status)
+	}
+	for !op.Done {
+		time.Sleep(50 * time.Millisecond)
+		res, err := client.Get(url + "/v1/" + op.Name)
+		if err != nil {
+			t.Fatalf("operation failed: %v", err)
+		}
+		_ = json.NewDecoder(res.Body).Decode(&op)
+		res.Body.Close()
+	}
+
+	return spanner.NewObjectStore(config.PortCloudSpanner{
+		DatabaseID: database,
+		InstanceID: testInstance,
+		ProjectID:  testProject,
+		URL:        url,
+	}).WithClient(client)
+}
+
+// emulatorCall posts the request to the admin API of the emulator and decodes the result.
+func emulatorCall(t *testing.T, client *http.Client, url string, request, result any) int {
+	body, _ := json.Marshal(request)
+	res, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	defer res.Body.Close()
+	if result != nil {
+		_ = json.NewDecoder(res.Body).Decode(result)
+	}
+	return res.StatusCode
+}
+
+func TestObjectStore_Put_Get_Delete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	err := store.Put(ctx, "foo", "bar")
+	assert.That(t, "err must be nil", err, nil)
+
+	value, err := store.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must be ErrorKeyDoesNotExist", err, spanner.ErrorKeyDoesNotExist)
+
+	err = store.Delete(ctx, "foo")
+	assert.That(t, "deleting a missing key must not fail", err, nil)
+}
+
+func TestObjectStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3"} {
+		_ = store.Put(ctx, key, "value")
+	}
+
+	page, err := store.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "first page must be correct", page, ports.Page[string]{Keys: []string{"b/1", "b/2"}, Next: "b/2"})
+
+	page, err = store.Scan(ctx, ports.Range[string]{After: page.Next, Prefix: "b/", Limit: 2})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "last page must be correct", page, ports.Page[string]{Keys: []string{"b/3"}})
+
+	page, err = store.Scan(ctx, ports.Range[string]{Start: "a/1", End: "c/1"})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "range must be correct", page.Keys, []string{"a/1", "b/1", "b/2", "b/3"})
+}
+
+func TestObjectStore_CompareAndSwap_CompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	err := store.CompareAndSwap(ctx, "foo", "", "bar")
+	assert.That(t, "creating a new key must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "", "baz")
+	assert.That(t, "creating an existing key must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "baz")
+	assert.That(t, "swapping the current value must succeed", err, nil)
+	err = store.CompareAndSwap(ctx, "foo", "bar", "qux")
+	assert.That(t, "swapping an old value must fail", err, ports.ErrorValueChanged)
+
+	err = store.CompareAndDelete(ctx, "foo", "bar")
+	assert.That(t, "deleting an old value must fail", err, ports.ErrorValueChanged)
+	err = store.CompareAndDelete(ctx, "foo", "baz")
+	assert.That(t, "deleting the current value must succeed", err, nil)
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "key must be deleted", err, spanner.ErrorKeyDoesNotExist)
+}
+
+func TestObjectStore_Apply_All_Or_Nothing(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	_ = store.Put(ctx, "a", "1")
+	_ = store.Put(ctx, "b", "2")
+
+	// The second write expects an old value, thus no write is applied.
+	err := store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "0", Value: "20"},
+	})
+	assert.That(t, "err must be ErrorValueChanged", err, ports.ErrorValueChanged)
+	value, _ := store.Get(ctx, "a")
+	assert.That(t, "a must be unchanged", value, "1")
+
+	err = store.Apply(ctx, []ports.Write[string, string]{
+		{Key: "a", Old: "1", Value: "10"},
+		{Key: "b", Old: "2"},
+		{Key: "c", Value: "30"},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	value, _ = store.Get(ctx, "a")
+	assert.That(t, "a must be updated", value, "10")
+	_, err = store.Get(ctx, "b")
+	assert.That(t, "b must be deleted", err, spanner.ErrorKeyDoesNotExist)
+	value, _ = store.Get(ctx, "c")
+	assert.That(t, "c must be created", value, "30")
+}
+
+func TestObjectStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusBadRequest)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		_, _ = w.Write([]byte(`{"error": {"code": 3, "message": "invalid", "status": "INVALID_ARGUMENT"}}`))
+	}))
+	defer server.Close()
+	store := spanner.NewObjectStore(config.PortCloudSpanner{URL: server.URL}).WithClient(server.Client())
+
+	_, err := store.Get(ctx, "foo")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "invalid request must not be transient", ports.IsTransient(err), false)
+
+	status.Store(http.StatusServiceUnavailable)
+	_, err = store.Get(ctx, "foo")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "unavailable service must be transient", ports.IsTransient(err), true)
+}
+
+func TestObjectStore_Metadata_Tokens(t *testing.T) {
+	ctx := context.Background()
+	var issued atomic.Int32
+	var expiresIn atomic.Int32
+	expiresIn.Store(3600)
+	var authorization atomic.Value
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		n := issued.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("token-%d", n), "expires_in": expiresIn.Load()})
+	})
+	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	store := spanner.NewObjectStore(config.PortCloudSpanner{MetadataURL: server.URL, URL: server.URL}).WithClient(server.Client())
+
+	// The token is cached until shortly before it expires.
+	_, _ = store.Get(ctx, "foo")
+	_, _ = store.Get(ctx, "foo")
+	assert.That(t, "one token must be issued", issued.Load(), int32(1))
+	assert.That(t, "calls must be authorized", authorization.Load(), "Bearer token-1")
+
+	expiresIn.Store(30)
+	store = spanner.NewObjectStore(config.PortCloudSpanner{MetadataURL: server.URL, URL: server.URL}).WithClient(server.Client())
+	_, _ = store.Get(ctx, "foo")
+	_, _ = store.Get(ctx, "foo")
+	assert.That(t, "expiring token must be refreshed", issued.Load(), int32(3))
+	assert.That(t, "calls must use the refreshed token", authorization.Load(), "Bearer token-3")
+}
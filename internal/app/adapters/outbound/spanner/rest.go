@@ -0,0 +1,124 @@
+package spanner
+
+// The types of this file are the JSON messages of the REST API of Cloud Spanner,
+// see https://cloud.google.com/spanner/docs/reference/rest.
+
+var (
+	// typeInt64 is the type of an INT64 parameter of a query.
+	typeInt64 = paramType{Code: "INT64"}
+	// typeString is the type of a STRING parameter of a query.
+	typeString = paramType{Code: "STRING"}
+)
+
+// beginTransactionRequest is the body of the beginTransaction method of a session.
+type beginTransactionRequest struct {
+	Options transactionOptions `json:"options"`
+}
+
+// commitRequest is the body of the commit method of a session.
+// Either the ID of a transaction or the options of a single-use transaction must be set.
+type commitRequest struct {
+	Mutations            []mutation          `json:"mutations"`
+	SingleUseTransaction *transactionOptions `json:"singleUseTransaction,omitempty"`
+	TransactionID        string              `json:"transactionId,omitempty"`
+}
+
+// deleteMutation deletes the rows of the keys.
+type deleteMutation struct {
+	KeySet keySet `json:"keySet"`
+	Table  string `json:"table"`
+}
+
+// errorResponse is the body of a failed call.
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// executeSQLRequest is the body of the executeSql method of a session.
+type executeSQLRequest struct {
+	Params      map[string]any       `json:"params,omitempty"`
+	ParamTypes  map[string]paramType `json:"paramTypes,omitempty"`
+	SQL         string               `json:"sql"`
+	Transaction transactionSelector  `json:"transaction"`
+}
+
+// keySet selects rows by their primary keys, which consist of a single column.
+type keySet struct {
+	Keys [][]string `json:"keys"`
+}
+
+// mutation is a change of a commit, which is either a delete or an insert or update.
+type mutation struct {
+	Delete         *deleteMutation `json:"delete,omitempty"`
+	InsertOrUpdate *writeMutation  `json:"insertOrUpdate,omitempty"`
+}
+
+// paramType is the type of a parameter of a query.
+type paramType struct {
+	ArrayElementType *paramType `json:"arrayElementType,omitempty"`
+	Code             string     `json:"code"`
+}
+
+// readOnly are the options of a read-only transaction.
+type readOnly struct {
+	Strong bool `json:"strong"`
+}
+
+// resultSet is the result of the executeSql method.
+type resultSet struct {
+	Rows []row `json:"rows"`
+}
+
+// rollbackRequest is the body of the rollback method of a session.
+type rollbackRequest struct {
+	TransactionID string `json:"transactionId"`
+}
+
+// row is a row of a result, whose STRING columns are encoded as JSON strings or null.
+type row []*string
+
+// sessionResponse is the result of the creation of a session.
+type sessionResponse struct {
+	Name string `json:"name"`
+}
+
+// transaction is the result of the beginTransaction method.
+type transaction struct {
+	ID string `json:"id"`
+}
+
+// transactionOptions are the options of a transaction, which is either read-only or read-write.
+type transactionOptions struct {
+	ReadOnly  *readOnly `json:"readOnly,omitempty"`
+	ReadWrite *struct{} `json:"readWrite,omitempty"`
+}
+
+// transactionSelector selects either an existing transaction by its ID or a new single-use transaction.
+type transactionSelector struct {
+	ID        string              `json:"id,omitempty"`
+	SingleUse *transactionOptions `json:"singleUse,omitempty"`
+}
+
+// writeMutation inserts or updates the rows of the values.
+type writeMutation struct {
+	Columns []string   `json:"columns"`
+	Table   string     `json:"table"`
+	Values  [][]string `json:"values"`
+}
+
+// at returns the value of the column of the row or an empty string, if it is null.
+func (r row) at(column int) string {
+	if column >= len(r) || r[column] == nil {
+		return ""
+	}
+	return *r[column]
+}
+
+// strongRead selects a single-use read-only transaction, which reads the latest committed values.
+func strongRead() transactionSelector {
+	return transactionSelector{SingleUse: &transactionOptions{ReadOnly: &readOnly{Strong: true}}}
+}
@@ -0,0 +1,71 @@
+package spanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+const (
+	// DefaultMetadataURL is the base URL of the metadata server of Google Cloud, e.g. of Compute Engine, GKE or Cloud Run.
+	DefaultMetadataURL = "http://metadata.google.internal"
+	// tokenPath is the path of the access token of the default service account.
+	tokenPath = "/computeMetadata/v1/instance/service-accounts/default/token"
+	// tokenRefreshMargin is the time before the expiry of an access token, at which it is refreshed.
+	tokenRefreshMargin = time.Minute
+)
+
+// metadataTokens are the access tokens of the service account, which are issued by the metadata server.
+// A token is cached until shortly before it expires, which is about an hour after it is issued.
+type metadataTokens struct {
+	expiry time.Time
+	mutex  sync.Mutex
+	token  string
+	url    string
+}
+
+// tokenResponse is the body of an access token of the metadata server.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// get returns the cached access token or requests a new one, if it is about to expire.
+func (a *metadataTokens) get(ctx context.Context, client *http.Client) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+tokenPath, nil)
+	if err != nil {
+		return "", ports.Permanent(err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err := fmt.Errorf("spanner access token failed with status %d", res.StatusCode)
+		if res.StatusCode < 500 {
+			return "", ports.Permanent(err)
+		}
+		return "", err
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	a.token = token.AccessToken
+	a.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenRefreshMargin)
+	return a.token, nil
+}
@@ -0,0 +1,481 @@
+package txlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andygeiss/cloud-native-utils/consistency"
+)
+
+const (
+	// defaultMaxSegmentSize is the size in bytes after which a new segment is started.
+	defaultMaxSegmentSize = 64 << 20
+	// segmentDigits is the length of the sequence number in the name of a segment.
+	segmentDigits = 20
+	// snapshotSuffix is appended to the path of the snapshot of the log.
+	snapshotSuffix = ".snapshot"
+)
+
+// FileLogger is a transactional logger which appends every event to segment files.
+// Each record carries a sequence number and a checksum and is synced to disk before the write returns.
+// A segment is named after the sequence number of its first record and is closed when it
+// reaches its maximum size. A record which was torn by a crash is truncated on open.
+// The history of the log can be compacted into a snapshot, which only contains the latest value of each key.
+// It implements the consistency.Logger interface.
+type FileLogger struct {
+	compactMutex   sync.Mutex
+	err            error
+	file           *os.File
+	maxSegmentSize int64
+	mutex          sync.Mutex
+	path           string
+	recover        bool
+	seq            uint64
+	size           int64
+	skipped        []Corruption
+}
+
+// NewFileLogger opens the transaction log at the given path for appending.
+// The path is used as the prefix of the segments and the snapshot.
+// Parent directories are created if necessary.
+func NewFileLogger(path string) (*FileLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	a := &FileLogger{maxSegmentSize: defaultMaxSegmentSize, path: path}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Close flushes and closes the current segment.
+// It returns the first error which occurred while writing to the log.
+func (a *FileLogger) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return errors.Join(a.err, a.file.Close())
+}
+
+// ReadBatches reads the snapshot followed by all events which were written after it.
+// The events of a batch are sent together, every other event is sent on its own.
+// The batch channel is closed after the last batch. If a record is corrupt, the error is
+// sent to the error channel and no further batches are sent, unless recovery is enabled.
+func (a *FileLogger) ReadBatches() (<-chan []consistency.Event[string, string], <-chan error) {
+	batchCh := make(chan []consistency.Event[string, string])
+	errCh := make(chan error)
+
+	go func() {
+		defer close(errCh)
+		defer close(batchCh)
+		a.read(errCh, func(events []consistency.Event[string, string]) {
+			batchCh <- events
+		})
+	}()
+
+	return batchCh, errCh
+}
+
+// ReadEvents reads the snapshot followed by all events which were written after it.
+// The event channel is closed after the last event. If a record is corrupt, the error is
+// sent to the error channel and no further events are sent, unless recovery is enabled.
+func (a *FileLogger) ReadEvents() (<-chan consistency.Event[string, string], <-chan error) {
+	eventCh := make(chan consistency.Event[string, string])
+	errCh := make(chan error)
+
+	go func() {
+		defer close(errCh)
+		defer close(eventCh)
+		a.read(errCh, func(events []consistency.Event[string, string]) {
+			for _, event := range events {
+				eventCh <- event
+			}
+		})
+	}()
+
+	return eventCh, errCh
+}
+
+// Size returns the size in bytes of the snapshot and all segments of the log.
+func (a *FileLogger) Size() (int64, error) {
+	// Prevent the segments from being removed by a compaction while they are counted.
+	a.compactMutex.Lock()
+	defer a.compactMutex.Unlock()
+
+	segments, err := a.segments()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, filename := range append(segments, a.snapshotPath()) {
+		info, err := os.Stat(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// Skipped returns the corrupt records which were skipped in recovery mode.
+func (a *FileLogger) Skipped() []Corruption {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return slices.Clone(a.skipped)
+}
+
+// WithMaxSegmentSize sets the size in bytes after which a new segment is started.
+func (a *FileLogger) WithMaxSegmentSize(size int64) *FileLogger {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if size > 0 {
+		a.maxSegmentSize = size
+	}
+	return a
+}
+
+// WithRecovery enables the recovery mode, in which corrupt records are skipped
+// and reported by Skipped instead of failing to read the log.
+func (a *FileLogger) WithRecovery(enabled bool) *FileLogger {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.recover = enabled
+	return a
+}
+
+// WriteBatch appends the events as a single record, thus they are either all read or none of them.
+func (a *FileLogger) WriteBatch(events []consistency.Event[string, string]) {
+	rec := record{Type: recordTypeBatch}
+	for _, event := range events {
+		switch event.EventType {
+		case consistency.EventTypeDelete:
+			rec.Batch = append(rec.Batch, record{Type: recordTypeDelete, Key: event.Key})
+		case consistency.EventTypePut:
+			rec.Batch = append(rec.Batch, record{Type: recordTypePut, Key: event.Key, Value: event.Value})
+		}
+	}
+	a.write(rec)
+}
+
+// WriteDelete appends a delete operation to the log.
+func (a *FileLogger) WriteDelete(key string) {
+	a.write(record{Type: recordTypeDelete, Key: key})
+}
+
+// WritePut appends a put operation to the log.
+func (a *FileLogger) WritePut(key, value string) {
+	a.write(record{Type: recordTypePut, Key: key, Value: value})
+}
+
+// open opens the last segment for appending and determines the next sequence number.
+// A torn record at the end of the last segment is the result of a crash during a write.
+// It was never acknowledged, thus it is truncated.
+func (a *FileLogger) open() error {
+	// Remove a snapshot which has not been committed before a crash.
+	temps, err := filepath.Glob(a.snapshotPath() + ".tmp-*")
+	if err != nil {
+		return err
+	}
+	for _, temp := range temps {
+		if err := os.Remove(temp); err != nil {
+			return err
+		}
+	}
+	segments, err := a.segments()
+	if err != nil {
+		return err
+	}
+
+	// Start the first segment right after the snapshot.
+	if len(segments) == 0 {
+		snapshotSeq, err := a.readFile(a.snapshotPath(), 0, func(record) {})
+		if err != nil {
+			return err
+		}
+		return a.create(snapshotSeq + 1)
+	}
+
+	last := segments[len(segments)-1]
+	seq, end, err := scanTail(last)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(last, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if info.Size() > end {
+		log.Printf("transaction log: truncating torn record in %s at offset %d", filepath.Base(last), end)
+		if err := file.Truncate(end); err != nil {
+			_ = file.Close()
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	// An empty segment continues with the sequence number in its name.
+	if seq == 0 {
+		seq = segmentSeq(last) - 1
+	}
+
+	a.file = file
+	a.seq = seq + 1
+	a.size = end
+	return nil
+}
+
+// create starts a new segment, whose first record gets the given sequence number.
+func (a *FileLogger) create(seq uint64) error {
+	file, err := os.OpenFile(a.segmentPath(seq), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(a.path)); err != nil {
+		_ = file.Close()
+		return err
+	}
+	a.file = file
+	a.seq = seq
+	a.size = 0
+	return nil
+}
+
+// read reads the snapshot followed by the segments and calls fn with the events of each record.
+// Errors are sent to the error channel, which stops reading.
+func (a *FileLogger) read(errCh chan<- error, fn func(events []consistency.Event[string, string])) {
+	send := func(rec record) {
+		if events := recordEvents(rec); len(events) > 0 {
+			fn(events)
+		}
+	}
+
+	snapshotSeq, err := a.readFile(a.snapshotPath(), 0, send)
+	if err != nil {
+		errCh <- err
+		return
+	}
+
+	segments, err := a.segments()
+	if err != nil {
+		errCh <- err
+		return
+	}
+
+	// Skip the events which are already part of the snapshot.
+	// They remain on disk, if a compaction was interrupted before the segments were removed.
+	for _, segment := range segments {
+		if _, err := a.readFile(segment, snapshotSeq, send); err != nil {
+			errCh <- err
+			return
+		}
+	}
+}
+
+// readFile reads the records of a segment or snapshot with a sequence number greater than minSeq.
+// It returns the highest sequence number which was read. Corrupt records are either
+// returned as Corruption or skipped in recovery mode. A missing file is treated as an empty one.
+func (a *FileLogger) readFile(filename string, minSeq uint64, fn func(record)) (maxSeq uint64, err error) {
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := newRecordReader(file)
+	for {
+		rec, offset, err := reader.next()
+		if err == io.EOF {
+			return maxSeq, nil
+		}
+		if err != nil {
+			corruption := Corruption{Err: err, File: filepath.Base(filename), Offset: offset}
+			if !a.skip(corruption) {
+				return maxSeq, corruption
+			}
+			// The records after a torn record cannot be located.
+			if errors.Is(err, ErrTornRecord) {
+				return maxSeq, nil
+			}
+			continue
+		}
+		maxSeq = max(maxSeq, rec.Seq)
+		if rec.Seq > minSeq {
+			fn(rec)
+		}
+	}
+}
+
+// roll closes the current segment and starts a new one, if the current segment is not empty.
+// The caller must hold the mutex.
+func (a *FileLogger) roll() error {
+	if a.size == 0 {
+		return nil
+	}
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	return a.create(a.seq)
+}
+
+// segmentPath returns the path of the segment starting with the given sequence number.
+func (a *FileLogger) segmentPath(seq uint64) string {
+	return fmt.Sprintf("%s.%0*d", a.path, segmentDigits, seq)
+}
+
+// segments returns the paths of all segments in the order they were written.
+func (a *FileLogger) segments() ([]string, error) {
+	matches, err := filepath.Glob(a.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, match := range matches {
+		if segmentSeq(match) > 0 {
+			segments = append(segments, match)
+		}
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// skip records the corruption if recovery is enabled and reports whether reading may continue.
+func (a *FileLogger) skip(corruption Corruption) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.recover {
+		return false
+	}
+	log.Printf("transaction log: skipping record in %v", corruption)
+	a.skipped = append(a.skipped, corruption)
+	return true
+}
+
+// snapshotPath returns the path of the snapshot.
+func (a *FileLogger) snapshotPath() string {
+	return a.path + snapshotSuffix
+}
+
+// write appends the record to the current segment and syncs it to disk.
+// The interface does not allow to return an error, thus the first error is kept and returned by Close.
+func (a *FileLogger) write(rec record) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.writeRecord(rec); err != nil {
+		log.Printf("transaction log write error: %v", err)
+		if a.err == nil {
+			a.err = err
+		}
+	}
+}
+
+// writeRecord assigns the next sequence number to the record and appends it.
+// The caller must hold the mutex.
+func (a *FileLogger) writeRecord(rec record) error {
+	rec.Seq = a.seq
+	data, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if a.size+int64(len(data)) > a.maxSegmentSize {
+		if err := a.roll(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := a.file.Write(data); err != nil {
+		// Remove the partial record, so that following records are not written after it.
+		_ = a.file.Truncate(a.size)
+		_, _ = a.file.Seek(a.size, io.SeekStart)
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+
+	a.seq++
+	a.size += int64(len(data))
+	return nil
+}
+
+// recordEvents returns the events of a record. A batch record returns the events of all its records.
+func recordEvents(rec record) (events []consistency.Event[string, string]) {
+	switch rec.Type {
+	case recordTypeBatch:
+		for _, r := range rec.Batch {
+			events = append(events, recordEvents(r)...)
+		}
+	case recordTypeDelete:
+		events = append(events, consistency.Event[string, string]{EventType: consistency.EventTypeDelete, Key: rec.Key})
+	case recordTypePut:
+		events = append(events, consistency.Event[string, string]{EventType: consistency.EventTypePut, Key: rec.Key, Value: rec.Value})
+	}
+	return events
+}
+
+// scanTail returns the sequence number of the last valid record of the segment and the offset after it.
+// Everything after that offset is a torn or corrupt tail, while corrupt records followed
+// by valid ones are kept to be reported when the log is read.
+func scanTail(filename string) (seq uint64, end int64, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := newRecordReader(file)
+	for {
+		rec, _, err := reader.next()
+		switch {
+		case err == io.EOF || errors.Is(err, ErrTornRecord):
+			return seq, end, nil
+		case err != nil:
+			// A corrupt record is only kept if a valid record follows it.
+			continue
+		default:
+			seq = rec.Seq
+			end = reader.offset
+		}
+	}
+}
+
+// segmentSeq returns the sequence number in the name of a segment or zero if it is no segment.
+func segmentSeq(filename string) uint64 {
+	ext := filepath.Ext(filename)
+	if len(ext) != segmentDigits+1 || strings.Trim(ext[1:], "0123456789") != "" {
+		return 0
+	}
+	seq, err := strconv.ParseUint(ext[1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
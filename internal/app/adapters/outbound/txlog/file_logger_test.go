@@ -0,0 +1,181 @@
+package txlog_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/txlog"
+	"github.com/andygeiss/cloud-native-utils/assert"
+	"github.com/andygeiss/cloud-native-utils/consistency"
+)
+
+// readAll collects all events and the first error of the logger.
+func readAll(logger consistency.Logger[string, string]) (events []consistency.Event[string, string], err error) {
+	eventCh, errCh := logger.ReadEvents()
+	for {
+		select {
+		case event, ok := <-eventCh:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, event)
+		case err, ok := <-errCh:
+			if ok && err != nil {
+				return events, err
+			}
+		}
+	}
+}
+
+// segments returns the segment files of the log.
+func segments(t *testing.T, path string) []string {
+	matches, err := filepath.Glob(path + ".0*")
+	assert.That(t, "err must be nil", err, nil)
+	return matches
+}
+
+func TestFileLogger_Write_And_Read_Events(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx", "transactions.log")
+	logger, err := txlog.NewFileLogger(path)
+	assert.That(t, "err must be nil", err, nil)
+
+	logger.WritePut("foo", "bar")
+	logger.WriteDelete("foo")
+	err = logger.Close()
+	assert.That(t, "err must be nil", err, nil)
+
+	reopened, _ := txlog.NewFileLogger(path)
+	defer reopened.Close()
+	events, err := readAll(reopened)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 2 events", len(events), 2)
+	assert.That(t, "first event must be a put", events[0].EventType, consistency.EventTypePut)
+	assert.That(t, "first event value must be 'bar'", events[0].Value, "bar")
+	assert.That(t, "second event must be a delete", events[1].EventType, consistency.EventTypeDelete)
+	assert.That(t, "second event key must be 'foo'", events[1].Key, "foo")
+}
+
+func TestFileLogger_Segments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger = logger.WithMaxSegmentSize(64)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		logger.WritePut(key, "value")
+	}
+	_ = logger.Close()
+	assert.That(t, "each record must be in its own segment", len(segments(t, path)), 4)
+
+	reopened, _ := txlog.NewFileLogger(path)
+	reopened.WritePut("e", "value")
+	events, err := readAll(reopened)
+	_ = reopened.Close()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 5 events", len(events), 5)
+	assert.That(t, "events must be in order", events[0].Key+events[4].Key, "ae")
+}
+
+func TestFileLogger_Truncates_Torn_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("foo", "bar")
+	_ = logger.Close()
+
+	// Simulate a crash in the middle of a write: the header announces 16 bytes, but only 3 were written.
+	segment := segments(t, path)[0]
+	file, _ := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = file.Write([]byte{0, 0, 0, 16, 1, 2, 3, 4, '{', '"', 's'})
+	_ = file.Close()
+
+	reopened, err := txlog.NewFileLogger(path)
+	assert.That(t, "err must be nil", err, nil)
+	reopened.WritePut("baz", "qux")
+	events, err := readAll(reopened)
+	_ = reopened.Close()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 2 events", len(events), 2)
+	assert.That(t, "second event must be written after the truncation", events[1].Key, "baz")
+}
+
+func TestFileLogger_Corrupt_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("foo", "bar")
+	logger.WritePut("baz", "qux")
+	_ = logger.Close()
+
+	// Flip a byte in the payload of the first record.
+	segment := segments(t, path)[0]
+	data, _ := os.ReadFile(segment)
+	data[10] ^= 0xff
+	_ = os.WriteFile(segment, data, 0600)
+
+	reopened, _ := txlog.NewFileLogger(path)
+	events, err := readAll(reopened)
+	assert.That(t, "must not have any events", len(events), 0)
+	var corruption txlog.Corruption
+	assert.That(t, "err must be a corruption", errors.As(err, &corruption), true)
+	assert.That(t, "err must be a checksum mismatch", errors.Is(err, txlog.ErrChecksumMismatch), true)
+	assert.That(t, "corruption must be at the first record", corruption.Offset, int64(0))
+
+	events, err = readAll(reopened.WithRecovery(true))
+	_ = reopened.Close()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 1 event", len(events), 1)
+	assert.That(t, "event must be the second record", events[0].Key, "baz")
+	assert.That(t, "must have skipped 1 record", len(reopened.Skipped()), 1)
+}
+
+func TestFileLogger_Batch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("a", "1")
+	logger.WriteBatch([]consistency.Event[string, string]{
+		{EventType: consistency.EventTypeDelete, Key: "a"},
+		{EventType: consistency.EventTypePut, Key: "b", Value: "2"},
+	})
+	assert.That(t, "close err must be nil", logger.Close(), nil)
+
+	logger, _ = txlog.NewFileLogger(path)
+	defer logger.Close()
+
+	var batches [][]consistency.Event[string, string]
+	batchCh, errCh := logger.ReadBatches()
+	for events := range batchCh {
+		batches = append(batches, events)
+	}
+	assert.That(t, "err must be nil", <-errCh, nil)
+	assert.That(t, "batches must be read as written", batches, [][]consistency.Event[string, string]{
+		{{EventType: consistency.EventTypePut, Key: "a", Value: "1"}},
+		{{EventType: consistency.EventTypeDelete, Key: "a"}, {EventType: consistency.EventTypePut, Key: "b", Value: "2"}},
+	})
+
+	events, err := readAll(logger)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "events of the batch must be flattened", len(events), 3)
+}
+
+func TestFileLogger_Size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	defer logger.Close()
+	logger = logger.WithMaxSegmentSize(64)
+
+	size, err := logger.Size()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "empty log must have size 0", size, int64(0))
+
+	logger.WritePut("a", "value")
+	logger.WritePut("b", "value")
+	var total int64
+	for _, segment := range segments(t, path) {
+		info, _ := os.Stat(segment)
+		total += info.Size()
+	}
+	size, err = logger.Size()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "size must be the size of all segments", size, total)
+	assert.That(t, "size must not be 0", size > 0, true)
+}
@@ -0,0 +1,128 @@
+package txlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// headerSize is the size of the length and the checksum in front of each payload.
+	headerSize = 8
+	// maxPayloadSize limits the length of a payload, larger values indicate a corrupt header.
+	maxPayloadSize = 1 << 30
+)
+
+const (
+	// recordTypeBatch marks a record which groups the records of a batch, so that they are read all or not at all.
+	recordTypeBatch = "batch"
+	// recordTypeDelete marks a record of a delete operation.
+	recordTypeDelete = "delete"
+	// recordTypePut marks a record of a put operation.
+	recordTypePut = "put"
+	// recordTypeSnapshot marks the first record of a snapshot, which holds the last sequence it covers.
+	recordTypeSnapshot = "snapshot"
+)
+
+var (
+	// ErrChecksumMismatch is returned when the payload of a record does not match its checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrTornRecord is returned when a record is incomplete or its header is unreadable.
+	// The records following it cannot be located anymore.
+	ErrTornRecord = errors.New("torn record")
+)
+
+// castagnoli is the CRC-32C table, which is used for the checksums of the records.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// record is the payload of an entry in a segment or snapshot.
+// On disk it is prefixed by its length and its CRC-32C checksum, both as big-endian uint32.
+type record struct {
+	Batch []record `json:"batch,omitempty"`
+	Seq   uint64   `json:"seq"`
+	Type  string   `json:"type"`
+	Key   string   `json:"key,omitempty"`
+	Value string   `json:"value,omitempty"`
+}
+
+// Corruption describes a record which could not be read from the log.
+type Corruption struct {
+	Err    error
+	File   string
+	Offset int64
+}
+
+// Error returns a description of the corruption including its location.
+func (a Corruption) Error() string {
+	return fmt.Sprintf("%s at offset %d: %v", a.File, a.Offset, a.Err)
+}
+
+// Unwrap returns the reason of the corruption.
+func (a Corruption) Unwrap() error {
+	return a.Err
+}
+
+// encodeRecord returns the on-disk representation of the record.
+func encodeRecord(rec record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.Checksum(payload, castagnoli))
+	copy(data[headerSize:], payload)
+	return data, nil
+}
+
+// recordReader decodes the records of a segment or snapshot one by one.
+type recordReader struct {
+	offset int64
+	reader *bufio.Reader
+}
+
+// newRecordReader creates a new reader which starts at the beginning of r.
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{reader: bufio.NewReader(r)}
+}
+
+// next returns the next record and the offset it starts at.
+// It returns io.EOF after the last complete record. A record with a checksum
+// mismatch is skipped, thus reading may continue with the following record.
+// After ErrTornRecord no further records can be read.
+func (a *recordReader) next() (rec record, offset int64, err error) {
+	offset = a.offset
+
+	var header [headerSize]byte
+	n, err := io.ReadFull(a.reader, header[:])
+	if err == io.EOF {
+		return rec, offset, io.EOF
+	}
+	if err != nil {
+		return rec, offset, ErrTornRecord
+	}
+	a.offset += int64(n)
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxPayloadSize {
+		return rec, offset, ErrTornRecord
+	}
+	payload := make([]byte, length)
+	n, err = io.ReadFull(a.reader, payload)
+	if err != nil {
+		return rec, offset, ErrTornRecord
+	}
+	a.offset += int64(n)
+
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:8]) {
+		return rec, offset, ErrChecksumMismatch
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, offset, err
+	}
+	return rec, offset, nil
+}
@@ -0,0 +1,136 @@
+package txlog
+
+import (
+	"bufio"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/andygeiss/cloud-native-utils/consistency"
+)
+
+// Compact replaces the closed segments of the log by a snapshot of the current state.
+// The current segment is closed first, so that writes can continue on a new segment
+// while the previous ones are merged into the snapshot. The snapshot records the last
+// sequence number it covers, thus a crash before the merged segments are removed
+// does not apply their events twice.
+func (a *FileLogger) Compact() error {
+	a.compactMutex.Lock()
+	defer a.compactMutex.Unlock()
+
+	// Close the current segment, so that all existing segments can be merged.
+	a.mutex.Lock()
+	err := a.roll()
+	current := a.file.Name()
+	a.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	segments, err := a.segments()
+	if err != nil {
+		return err
+	}
+	closed := slices.DeleteFunc(segments, func(segment string) bool {
+		return segment >= current
+	})
+	if len(closed) == 0 {
+		return nil
+	}
+
+	// Fold the snapshot and the closed segments into the current state.
+	state := make(map[string]string)
+	apply := func(rec record) {
+		for _, event := range recordEvents(rec) {
+			switch event.EventType {
+			case consistency.EventTypeDelete:
+				delete(state, event.Key)
+			case consistency.EventTypePut:
+				state[event.Key] = event.Value
+			}
+		}
+	}
+	snapshotSeq, err := a.readFile(a.snapshotPath(), 0, apply)
+	if err != nil {
+		return err
+	}
+	lastSeq := snapshotSeq
+	for _, segment := range closed {
+		seq, err := a.readFile(segment, snapshotSeq, apply)
+		if err != nil {
+			return err
+		}
+		lastSeq = max(lastSeq, seq)
+	}
+
+	if err := writeSnapshot(a.snapshotPath(), state, lastSeq); err != nil {
+		return err
+	}
+
+	// The closed segments are part of the snapshot now.
+	for _, segment := range closed {
+		if err := os.Remove(segment); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(a.path))
+}
+
+// syncDir flushes the directory entries to disk, which makes renames and removals durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// writeSnapshot atomically replaces the snapshot with put records of the given state.
+// The first record holds the last sequence number covered by the snapshot.
+// The keys are sorted to get a deterministic file.
+func writeSnapshot(filename string, state map[string]string, seq uint64) (err error) {
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file if the snapshot could not be committed.
+	defer func() {
+		if err != nil {
+			_ = temp.Close()
+			_ = os.Remove(temp.Name())
+		}
+	}()
+
+	writer := bufio.NewWriter(temp)
+	records := []record{{Seq: seq, Type: recordTypeSnapshot}}
+	for _, key := range slices.Sorted(maps.Keys(state)) {
+		records = append(records, record{Seq: seq, Type: recordTypePut, Key: key, Value: state[key]})
+	}
+	for _, rec := range records {
+		data, err := encodeRecord(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+
+	// Make sure that the snapshot is on disk before it replaces the previous one.
+	if err = temp.Sync(); err != nil {
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(temp.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(filename))
+}
@@ -0,0 +1,98 @@
+package txlog_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/txlog"
+	"github.com/andygeiss/cloud-native-utils/assert"
+	"github.com/andygeiss/cloud-native-utils/consistency"
+)
+
+func TestFileLogger_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("a", "1")
+	logger.WritePut("a", "2")
+	logger.WritePut("b", "1")
+	logger.WriteDelete("b")
+
+	err := logger.Compact()
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "only the current segment must remain", len(segments(t, path)), 1)
+
+	// Events written after the compaction are read after the snapshot.
+	logger.WritePut("c", "1")
+	_ = logger.Close()
+
+	reopened, _ := txlog.NewFileLogger(path)
+	defer reopened.Close()
+	events, err := readAll(reopened)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 2 events", len(events), 2)
+	assert.That(t, "snapshot must contain the latest value of 'a'", events[0].Value, "2")
+	assert.That(t, "tail must contain 'c'", events[1].Key, "c")
+}
+
+func TestFileLogger_Compact_Twice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("a", "1")
+	_ = logger.Compact()
+	logger.WritePut("b", "1")
+	logger.WriteDelete("a")
+	_ = logger.Compact()
+	_ = logger.Close()
+
+	// The sequence numbers continue after the snapshot.
+	reopened, _ := txlog.NewFileLogger(path)
+	reopened.WritePut("c", "1")
+	events, _ := readAll(reopened)
+	_ = reopened.Close()
+	assert.That(t, "must have 2 events", len(events), 2)
+	assert.That(t, "snapshot must contain 'b'", events[0].Key, "b")
+	assert.That(t, "tail must contain 'c'", events[1].Key, "c")
+}
+
+func TestFileLogger_Compact_Interrupted_Before_Removal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("a", "1")
+	logger.WritePut("a", "2")
+	segment := segments(t, path)[0]
+	data, _ := os.ReadFile(segment)
+
+	_ = logger.Compact()
+	_ = logger.Close()
+
+	// Simulate a crash after the snapshot was written, but before the segment was removed.
+	_ = os.WriteFile(segment, data, 0600)
+
+	reopened, _ := txlog.NewFileLogger(path)
+	defer reopened.Close()
+	events, err := readAll(reopened)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "events of the snapshot must not be read twice", len(events), 1)
+	assert.That(t, "snapshot must contain the latest value of 'a'", events[0].Value, "2")
+}
+
+func TestFileLogger_Compact_Batch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	logger.WritePut("a", "1")
+	logger.WriteBatch([]consistency.Event[string, string]{
+		{EventType: consistency.EventTypeDelete, Key: "a"},
+		{EventType: consistency.EventTypePut, Key: "b", Value: "2"},
+	})
+	assert.That(t, "err must be nil", logger.Compact(), nil)
+	_ = logger.Close()
+
+	reopened, _ := txlog.NewFileLogger(path)
+	defer reopened.Close()
+	events, err := readAll(reopened)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "snapshot must contain the batch", events, []consistency.Event[string, string]{
+		{EventType: consistency.EventTypePut, Key: "b", Value: "2"},
+	})
+}
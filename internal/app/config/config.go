@@ -1,26 +1,144 @@
 package config
 
-import "embed"
+import (
+	"embed"
+	"time"
+)
+
+const (
+	// KeyProviderNameFile selects the key provider which reads the master keys from a local file.
+	KeyProviderNameFile = "file"
+	// KeyProviderNameKMS selects the key provider which calls a KMS-style HTTP service.
+	KeyProviderNameKMS = "kms"
+	// PortNameFile selects the durable file-based object store.
+	PortNameFile = "file"
+	// PortNameInMemory selects the in-memory object store.
+	PortNameInMemory = "inmemory"
+	// PortNameLSM selects the embedded log-structured merge tree on the local disk.
+	PortNameLSM = "lsm"
+	// PortNamePostgres selects the object store in a PostgreSQL database.
+	PortNamePostgres = "postgres"
+	// PortNameRedis selects the object store in a Redis server.
+	PortNameRedis = "redis"
+	// PortNameS3 selects the object store in a bucket of an S3-compatible object storage.
+	PortNameS3 = "s3"
+	// PortNameSpanner selects the object store in a table of Cloud Spanner.
+	PortNameSpanner = "spanner"
+)
 
 type Config struct {
+	KeyProvider      KeyProvider      `json:"key_provider"`
 	PortCloudSpanner PortCloudSpanner `json:"port_cloud_spanner"`
+	PortFile         PortFile         `json:"port_file"`
+	PortInMemory     PortInMemory     `json:"port_inmemory"`
+	PortLSM          PortLSM          `json:"port_lsm"`
+	PortPostgres     PortPostgres     `json:"port_postgres"`
+	PortRedis        PortRedis        `json:"port_redis"`
+	PortS3           PortS3           `json:"port_s3"`
 	Server           Server           `json:"server"`
 	Service          Service          `json:"service"`
+	Stability        Stability        `json:"stability"`
+	TransactionLog   TransactionLog   `json:"transaction_log"`
+}
+
+type KeyProvider struct {
+	KeyID string `json:"key_id"` // ID of the master key of the KMS, which wraps new data keys.
+	Name  string `json:"name"`   // Envelope encryption is disabled if empty.
+	Path  string `json:"path"`   // Path of the key file.
+	Token string `json:"-"`      // Bearer token for the KMS.
+	URL   string `json:"url"`    // Base URL of the KMS.
 }
 
 type PortCloudSpanner struct {
-	DatabaseID string `json:"database_id"`
-	InstanceID string `json:"instance_id"`
-	ProjectID  string `json:"project_id"`
-	Table      string `json:"table"`
+	DatabaseID  string `json:"database_id"`
+	InstanceID  string `json:"instance_id"`
+	MetadataURL string `json:"metadata_url"` // Base URL of the metadata server, which issues refreshed access tokens.
+	ProjectID   string `json:"project_id"`
+	Table       string `json:"table"`
+	Token       string `json:"-"`   // Static OAuth access token for tests, which expires after about an hour.
+	URL         string `json:"url"` // Base URL of the REST API, e.g. of the emulator.
+}
+
+type PortFile struct {
+	Path string `json:"path"`
+}
+
+type PortInMemory struct {
+	Shards int `json:"shards"`
+}
+
+// PortLSM configures the directory and the durability of the embedded storage engine.
+type PortLSM struct {
+	MemtableSize int    `json:"memtable_size"` // Bytes of the memtable, after which it is flushed to a table.
+	Path         string `json:"path"`
+	Sync         bool   `json:"sync"` // Syncs each write to the disk before it is acknowledged.
+}
+
+// PortPostgres configures the PostgreSQL database and the pool of its connections.
+type PortPostgres struct {
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"` // Connections are closed after this duration, if it is not zero.
+	MaxIdleConns    int           `json:"max_idle_conns"`
+	MaxOpenConns    int           `json:"max_open_conns"` // The number of connections is unlimited if zero.
+	SetupTimeout    time.Duration `json:"setup_timeout"`  // Deadline of the connection and the migrations on startup.
+	URL             string        `json:"-"`              // Connection URL, which contains the password.
+}
+
+// PortRedis configures the Redis server and the prefix of the keys of the store.
+type PortRedis struct {
+	Address  string `json:"address"` // Host and port of the server.
+	Database int    `json:"database"`
+	Password string `json:"-"`
+	Prefix   string `json:"prefix"`   // Prefix of the keys, which separates the store from other data of the server.
+	Username string `json:"username"` // ACL user of the password, which is the default user if empty.
+}
+
+// PortS3 configures the bucket of an S3-compatible object storage and the credentials which sign the requests.
+type PortS3 struct {
+	AccessKeyID     string `json:"access_key_id"`
+	Bucket          string `json:"bucket"`
+	Endpoint        string `json:"endpoint"` // Base URL of the S3 API, e.g. of a local S3-compatible server.
+	Prefix          string `json:"prefix"`   // Prefix of the object names, which separates the keys from other objects of the bucket.
+	Region          string `json:"region"`
+	SecretAccessKey string `json:"-"`
+	SessionToken    string `json:"-"` // Only required by temporary credentials.
 }
 
 type Server struct {
-	Efs       embed.FS `json:"-"`
-	Port      string   `json:"port"`
-	Templates string   `json:"templates"`
+	ACLPath      string   `json:"acl_path"` // Path of the ACL, which maps the GitHub logins of the UI to grants.
+	Efs          embed.FS `json:"-"`
+	MaxBodyBytes int64    `json:"max_body_bytes"` // Maximum size of the body of an API request, which is unlimited if zero.
+	Port         string   `json:"port"`
+	Templates    string   `json:"templates"`
 }
 
 type Service struct {
-	Key [32]byte `json:"-"`
+	Key           [32]byte            `json:"-"`      // Active encryption key.
+	KeyID         string              `json:"key_id"` // ID of the active key, which is stored with each value.
+	Port          string              `json:"port"`
+	Reencrypt     bool                `json:"reencrypt"`     // Migrates all values to the active key and the current format on startup.
+	RejectLegacy  bool                `json:"reject_legacy"` // Rejects values which are not bound to their object and its expiry.
+	RetiredKeys   map[string][32]byte `json:"-"`             // Keys by ID, which are only used to decrypt values.
+	SweepInterval time.Duration       `json:"sweep_interval"`
+}
+
+// Stability holds the stability policies of the calls of the port, which are separate for reads and writes.
+type Stability struct {
+	Read  StabilityPolicy `json:"read"`  // Policy of the reads and scans of the port.
+	Write StabilityPolicy `json:"write"` // Policy of the writes and deletes of the port.
+}
+
+// StabilityPolicy configures the stability patterns of a call of the port.
+// A zero value disables the respective pattern.
+type StabilityPolicy struct {
+	BreakerThreshold int           `json:"breaker_threshold"` // Consecutive failures, which open the breaker.
+	RetryDelay       time.Duration `json:"retry_delay"`       // Delay between the attempts of a call.
+	RetryMax         int           `json:"retry_max"`         // Number of retries after the first attempt.
+	Timeout          time.Duration `json:"timeout"`           // Timeout of each attempt.
+}
+
+type TransactionLog struct {
+	MaxSegmentSize   int64         `json:"max_segment_size"`
+	Path             string        `json:"path"`
+	Recover          bool          `json:"recover"`
+	SnapshotInterval time.Duration `json:"snapshot_interval"`
 }
@@ -0,0 +1,18 @@
+package ports
+
+import "context"
+
+// Write is a single change of a batch. It is only applied if the current value of the key equals Old.
+// If Old is the zero value, the key must not exist. If Value is the zero value, the key is removed.
+type Write[K ~string, V any] struct {
+	Key   K `json:"key"`
+	Old   V `json:"old"`
+	Value V `json:"value"`
+}
+
+// BatchPort is implemented by ports which are able to apply several writes atomically.
+type BatchPort[K ~string, V any] interface {
+	// Apply applies either all writes or none of them.
+	// It returns ErrorValueChanged if the value of any key differs from the expected one.
+	Apply(ctx context.Context, writes []Write[K, V]) (err error)
+}
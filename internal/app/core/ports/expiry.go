@@ -0,0 +1,18 @@
+package ports
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiringPort is implemented by ports which are able to remove expired keys by themselves.
+// Writing a key by any other method of the ObjectPort removes its expiry.
+type ExpiringPort[K ~string, V any] interface {
+	// Expire sets the time at which the key is removed, if its current value equals value.
+	// It returns ErrorValueChanged if the key has been written in the meantime.
+	Expire(ctx context.Context, key K, value V, at time.Time) (err error)
+	// Sweep removes the expired keys every interval until the context is done.
+	// It calls fn with each removed key. A port which removes the keys by itself calls fn
+	// before the key can be written again, so that fn is able to log the deletion in order.
+	Sweep(ctx context.Context, interval time.Duration, fn func(key K))
+}
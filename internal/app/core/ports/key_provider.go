@@ -0,0 +1,24 @@
+package ports
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrorUnknownMasterKey is returned when a master key is not known by the key provider.
+	ErrorUnknownMasterKey = errors.New("unknown master key")
+	// ErrorUnwrapFailed is returned when a wrapped data key cannot be authenticated.
+	ErrorUnwrapFailed = errors.New("unwrap failed")
+)
+
+// KeyProvider wraps and unwraps the data keys of the objects with a master key,
+// which never leaves the provider.
+type KeyProvider interface {
+	// KeyID returns the ID of the master key which wraps new data keys.
+	KeyID() string
+	// Unwrap decrypts a data key which was wrapped by the master key with the given ID.
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) (dataKey []byte, err error)
+	// Wrap encrypts a data key with the master key returned by KeyID.
+	Wrap(ctx context.Context, dataKey []byte) (wrapped []byte, err error)
+}
@@ -1,9 +1,61 @@
 package ports
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
-type ObjectPort[K comparable, V any] interface {
+var (
+	// ErrorKeyDoesNotExist is returned when a key is not found in the object store.
+	ErrorKeyDoesNotExist = errors.New("key does not exist")
+	// ErrorValueChanged is returned when a compare-and-swap finds a different value than expected.
+	ErrorValueChanged = errors.New("value has changed")
+)
+
+// permanentError is an error of a port, which will not go away if the call is repeated.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks the error of a port as permanent, e.g. if a stored value cannot be decoded,
+// so that the call is neither retried nor counted as a failure of the port.
+// The error can still be matched by errors.Is and errors.As.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsTransient reports whether a call of a port, which failed with the error, may succeed if it is repeated.
+// A missing key, a changed value, a canceled call and errors marked by Permanent are not transient,
+// because they are the answer of the port to the call and not a failure of the port.
+func IsTransient(err error) bool {
+	var permanent permanentError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrorKeyDoesNotExist), errors.Is(err, ErrorValueChanged):
+		return false
+	case errors.Is(err, context.Canceled), errors.As(err, &permanent):
+		return false
+	default:
+		return true
+	}
+}
+
+type ObjectPort[K ~string, V any] interface {
+	// CompareAndDelete removes the key only if its current value equals old.
+	CompareAndDelete(ctx context.Context, key K, old V) (err error)
+	// CompareAndSwap sets the value of the key only if its current value equals old.
+	// If old is the zero value, the key must not exist.
+	CompareAndSwap(ctx context.Context, key K, old, value V) (err error)
 	Delete(ctx context.Context, key K) (err error)
 	Get(ctx context.Context, key K) (value V, err error)
 	Put(ctx context.Context, key K, value V) (err error)
+	Scan(ctx context.Context, r Range[K]) (page Page[K], err error)
 }
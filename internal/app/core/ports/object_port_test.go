@@ -0,0 +1,20 @@
+package ports_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	assert.That(t, "nil must not be transient", ports.IsTransient(nil), false)
+	assert.That(t, "missing key must not be transient", ports.IsTransient(ports.ErrorKeyDoesNotExist), false)
+	assert.That(t, "changed value must not be transient", ports.IsTransient(ports.ErrorValueChanged), false)
+	assert.That(t, "canceled call must not be transient", ports.IsTransient(context.Canceled), false)
+	assert.That(t, "permanent error must not be transient", ports.IsTransient(ports.Permanent(errors.New("bad"))), false)
+	assert.That(t, "timeout must be transient", ports.IsTransient(context.DeadlineExceeded), true)
+	assert.That(t, "other errors must be transient", ports.IsTransient(errors.New("connection refused")), true)
+}
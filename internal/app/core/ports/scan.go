@@ -0,0 +1,45 @@
+package ports
+
+import "strings"
+
+// Range selects the keys returned by a scan.
+// Zero values do not restrict the result.
+type Range[K ~string] struct {
+	After  K   `json:"after"`  // Continuation cursor, only keys greater than it are returned.
+	End    K   `json:"end"`    // Exclusive upper bound of the keys.
+	Limit  int `json:"limit"`  // Maximum number of keys in a page.
+	Prefix K   `json:"prefix"` // Prefix which all keys must have.
+	Start  K   `json:"start"`  // Inclusive lower bound of the keys.
+}
+
+// Page is a part of the keys selected by a range in ascending order.
+type Page[K ~string] struct {
+	Keys []K `json:"keys"`
+	Next K   `json:"next,omitempty"` // Cursor of the next page, empty if there are no more keys.
+}
+
+// Contains reports whether the key is selected by the range, regardless of the limit.
+func (r Range[K]) Contains(key K) bool {
+	return strings.HasPrefix(string(key), string(r.Prefix)) &&
+		key >= r.Start &&
+		(r.After == "" || key > r.After) &&
+		(r.End == "" || key < r.End)
+}
+
+// Paginate returns the page of keys selected by the range.
+// The keys must be sorted in ascending order.
+func (r Range[K]) Paginate(keys []K) (page Page[K]) {
+	page.Keys = []K{}
+	for _, key := range keys {
+		if !r.Contains(key) {
+			continue
+		}
+		// Only set the cursor if there is at least one more key.
+		if r.Limit > 0 && len(page.Keys) == r.Limit {
+			page.Next = page.Keys[len(page.Keys)-1]
+			break
+		}
+		page.Keys = append(page.Keys, key)
+	}
+	return page
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// teamSubjectPrefix is the prefix of the subjects of an ACL rule, which refer to a team instead of a login.
+const teamSubjectPrefix = "team:"
+
+// ACL maps the GitHub logins of the users of the UI to the grants on the keys of the store.
+// The teams are a local stand-in for the organizations and teams of GitHub,
+// which map the name of a team, e.g. "acme/ops", to the logins of its members.
+// A login which is not covered by any rule is not allowed to access the store.
+type ACL struct {
+	Rules []ACLRule           `json:"rules"`
+	Teams map[string][]string `json:"teams"`
+}
+
+// ACLRule gives the grants to the subjects, which are either GitHub logins or teams prefixed by "team:".
+type ACLRule struct {
+	Grants   []Grant  `json:"grants"`
+	Subjects []string `json:"subjects"`
+}
+
+// ParseACL parses the JSON encoded ACL and checks its grants.
+func ParseACL(data []byte) (acl ACL, err error) {
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return acl, err
+	}
+	for _, rule := range acl.Rules {
+		for _, grant := range rule.Grants {
+			if err := validateGrant(grant); err != nil {
+				return ACL{}, err
+			}
+		}
+	}
+	return acl, nil
+}
+
+// Token returns a token with the grants of all rules which apply to the login
+// or ErrorUnauthorized if there are none. The token is not stored, thus it has no secret.
+func (a ACL) Token(login string) (token Token, err error) {
+	if login == "" {
+		return token, ErrorUnauthorized
+	}
+	token = Token{ID: "session:" + login, Name: login}
+	for _, rule := range a.Rules {
+		for _, subject := range rule.Subjects {
+			if a.matches(subject, login) {
+				token.Grants = append(token.Grants, rule.Grants...)
+				break
+			}
+		}
+	}
+	if len(token.Grants) == 0 {
+		return Token{}, ErrorUnauthorized
+	}
+	return token, nil
+}
+
+// matches reports whether the subject of a rule is the login or a team with the login as member.
+// GitHub logins are case-insensitive.
+func (a ACL) matches(subject, login string) bool {
+	team, found := strings.CutPrefix(subject, teamSubjectPrefix)
+	if !found {
+		return strings.EqualFold(subject, login)
+	}
+	for _, member := range a.Teams[team] {
+		if strings.EqualFold(member, login) {
+			return true
+		}
+	}
+	return false
+}
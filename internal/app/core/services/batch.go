@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/andygeiss/cloud-native-utils/consistency"
+)
+
+var (
+	// ErrorBatchNotSupported is returned when the port is not able to apply a batch atomically.
+	ErrorBatchNotSupported = errors.New("batch not supported by port")
+	// ErrorInvalidBatch is returned when a batch has an unknown operation or changes a key twice.
+	ErrorInvalidBatch = errors.New("invalid batch")
+)
+
+// OperationType is the kind of change of an operation.
+type OperationType string
+
+const (
+	// OperationDelete removes the object of the key.
+	OperationDelete OperationType = "delete"
+	// OperationPut adds or updates the object of the key.
+	OperationPut OperationType = "put"
+)
+
+// Operation is a single change of a batch, which is only applied if the object matches the condition.
+// The value and the ttl are only used by put operations.
+type Operation struct {
+	Condition Condition
+	Key       string
+	TTL       time.Duration
+	Type      OperationType
+	Value     string
+}
+
+// batchLogger is implemented by transactional loggers which are able to write several
+// events as a single record, so that they are either all replayed or none of them.
+type batchLogger interface {
+	ReadBatches() (<-chan []consistency.Event[string, string], <-chan error)
+	WriteBatch(events []consistency.Event[string, string])
+}
+
+// validate checks that the operations are known and that every key is changed only once.
+func validate(ops []Operation) error {
+	keys := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if op.Type != OperationDelete && op.Type != OperationPut {
+			return ErrorInvalidBatch
+		}
+		if keys[op.Key] {
+			return ErrorInvalidBatch
+		}
+		keys[op.Key] = true
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andygeiss/cloud-native-utils/service"
+)
+
+var (
+	// ErrorBreakerOpen is returned when a call of the port is rejected by its open circuit breaker.
+	ErrorBreakerOpen = errors.New("circuit breaker is open")
+)
+
+// breakerBackoff is the time for which the breaker rejects the calls after it has been opened.
+// It is doubled by every failed trial, like the backoff of stability.Breaker.
+const breakerBackoff = 2 * time.Second
+
+// BreakerState is the state of the circuit breaker of a call of the port.
+type BreakerState string
+
+const (
+	// BreakerClosed lets all calls through, which is also the state of a disabled breaker.
+	BreakerClosed BreakerState = "closed"
+	// BreakerHalfOpen lets the next call through as a trial, which either closes or opens the breaker again.
+	BreakerHalfOpen BreakerState = "half_open"
+	// BreakerOpen rejects all calls with ErrorBreakerOpen until its backoff has passed.
+	BreakerOpen BreakerState = "open"
+)
+
+// breaker is the circuit breaker of a call of the port. It opens when the consecutive failures reach
+// the threshold and rejects the calls until its backoff has passed. Then it is half-open and lets
+// a single trial through, which closes it on success or opens it again with a doubled backoff.
+// Unlike stability.Breaker, it exposes its state and the number of times it has opened to the metrics.
+type breaker struct {
+	failures  int // Consecutive failures, which only grow by the failed trials while the breaker is open.
+	mutex     sync.Mutex
+	openUntil time.Time
+	threshold int
+	trial     bool // Whether the trial of the half-open breaker is running.
+	trips     uint64
+}
+
+// newBreaker creates a closed breaker, which opens after the given number of consecutive failures.
+func newBreaker(threshold int) *breaker {
+	return &breaker{threshold: threshold}
+}
+
+// protect returns the function, whose calls are rejected while the breaker is open.
+func protect[IN, OUT any](b *breaker, fn service.Function[IN, OUT]) service.Function[IN, OUT] {
+	return func(ctx context.Context, in IN) (out OUT, err error) {
+		trial, err := b.enter(time.Now())
+		if err != nil {
+			return out, err
+		}
+		out, err = fn(ctx, in)
+		b.leave(trial, err, time.Now())
+		return out, err
+	}
+}
+
+// enter reports whether the call is the trial of the half-open breaker.
+// It returns ErrorBreakerOpen, if the call is rejected.
+func (b *breaker) enter(now time.Time) (trial bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state(now) {
+	case BreakerOpen:
+		return false, ErrorBreakerOpen
+	case BreakerHalfOpen:
+		b.trial = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// leave records the result of a call, which has been let through.
+// A failure of a call, which has been let through before the breaker opened, does not open it again.
+func (b *breaker) leave(trial bool, err error, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if trial {
+		b.trial = false
+	}
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if b.failures >= b.threshold && !trial {
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(breakerBackoff << (b.failures - b.threshold))
+		b.trips++
+	}
+}
+
+// snapshot returns the state of the breaker and the number of times it has been opened.
+func (b *breaker) snapshot(now time.Time) (BreakerState, uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state(now), b.trips
+}
+
+// state returns the state of the breaker at the given time. It must be called with the lock held.
+func (b *breaker) state(now time.Time) BreakerState {
+	switch {
+	case b.failures < b.threshold:
+		return BreakerClosed
+	case b.trial || now.Before(b.openUntil):
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+func TestBreaker_Opens_And_Closes(t *testing.T) {
+	b := newBreaker(2)
+	now := time.Now()
+	failure := errors.New("failure")
+
+	// The breaker opens after the threshold of consecutive failures.
+	for range 2 {
+		trial, err := b.enter(now)
+		assert.That(t, "err must be nil", err, nil)
+		b.leave(trial, failure, now)
+	}
+	state, trips := b.snapshot(now)
+	assert.That(t, "breaker must be open", state, BreakerOpen)
+	assert.That(t, "breaker must be tripped once", trips, uint64(1))
+	_, err := b.enter(now)
+	assert.That(t, "call must be rejected", err, ErrorBreakerOpen)
+
+	// After the backoff, a single trial is let through, which opens the breaker again for twice as long.
+	now = now.Add(breakerBackoff)
+	state, _ = b.snapshot(now)
+	assert.That(t, "breaker must be half-open", state, BreakerHalfOpen)
+	trial, err := b.enter(now)
+	assert.That(t, "trial must be let through", trial && err == nil, true)
+	_, err = b.enter(now)
+	assert.That(t, "call during the trial must be rejected", err, ErrorBreakerOpen)
+	b.leave(trial, failure, now)
+	state, trips = b.snapshot(now.Add(breakerBackoff))
+	assert.That(t, "breaker must be open again", state, BreakerOpen)
+	assert.That(t, "failed trial must be a trip", trips, uint64(2))
+
+	// A successful trial closes the breaker.
+	now = now.Add(2 * breakerBackoff)
+	trial, _ = b.enter(now)
+	b.leave(trial, nil, now)
+	state, trips = b.snapshot(now)
+	assert.That(t, "breaker must be closed", state, BreakerClosed)
+	assert.That(t, "trips must be kept", trips, uint64(2))
+}
+
+func TestBreaker_Concurrent_Failures_Are_One_Trip(t *testing.T) {
+	b := newBreaker(1)
+	now := time.Now()
+	failure := errors.New("failure")
+
+	// Calls, which were let through before the breaker opened, neither open it again nor extend it.
+	first, _ := b.enter(now)
+	second, _ := b.enter(now)
+	b.leave(first, failure, now)
+	b.leave(second, failure, now.Add(time.Second))
+
+	state, trips := b.snapshot(now.Add(breakerBackoff))
+	assert.That(t, "breaker must be half-open", state, BreakerHalfOpen)
+	assert.That(t, "breaker must be tripped once", trips, uint64(1))
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// formatLegacy is the format of values which were encrypted without associated data.
+	formatLegacy = 0
+	// formatBound is the format of values which are bound to the key and version of their
+	// object by the associated data, so that a ciphertext cannot be moved to another object.
+	formatBound = 1
+	// formatEnvelope is the format of values which are bound like formatBound, but encrypted
+	// with their own data key, which is wrapped by the master key of the key provider.
+	formatEnvelope = 2
+	// formatBoundExpiry is the format of values which are bound like formatBound and also to
+	// the expiry of their object, so that the expiry cannot be added, extended or removed.
+	formatBoundExpiry = 3
+	// formatEnvelopeExpiry is the format of values which are bound like formatBoundExpiry,
+	// but encrypted with their own data key like formatEnvelope.
+	formatEnvelopeExpiry = 4
+)
+
+// namespaceKeyInfo is the context of the keys which are derived for namespaces.
+const namespaceKeyInfo = "cloud-native-store namespace "
+
+var (
+	// errCiphertextTooShort is returned when a ciphertext is shorter than its nonce.
+	errCiphertextTooShort = errors.New("ciphertext too short")
+	// errInvalidDataKey is returned when an unwrapped data key has not the size of an AES-256 key.
+	errInvalidDataKey = errors.New("invalid data key")
+)
+
+// associatedData returns the data which binds a ciphertext in formatBound or formatEnvelope to the key
+// and version of its object. The version has a fixed size, thus the key does not need a delimiter.
+func associatedData(key string, version uint64) []byte {
+	data := binary.BigEndian.AppendUint64(nil, version)
+	return append(data, key...)
+}
+
+// expiryAssociatedData returns the data which binds a ciphertext in formatBoundExpiry or formatEnvelopeExpiry
+// to the key, version and expiry of its object. The expiry is always included, also if it is zero,
+// so that an object without an expiry cannot be given one without the key.
+func expiryAssociatedData(key string, version uint64, expiresAt int64) []byte {
+	data := binary.BigEndian.AppendUint64(nil, version)
+	data = binary.BigEndian.AppendUint64(data, uint64(expiresAt))
+	return append(data, key...)
+}
+
+// decrypt authenticates and decrypts the ciphertext together with the associated data using AES-GCM.
+func decrypt(ciphertext []byte, key [32]byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errCiphertextTooShort
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// newDataKey returns a fresh random key, which encrypts a single value.
+func newDataKey() ([32]byte, error) {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	return key, err
+}
+
+// deriveKey derives the key of the namespace from the given key with HKDF-SHA256 (RFC 5869),
+// so that the values of a namespace cannot be decrypted with the key of another one.
+// The default namespace uses the given key, which keeps the values written before namespaces readable.
+func deriveKey(key [32]byte, namespace string) [32]byte {
+	if namespace == DefaultNamespace {
+		return key
+	}
+
+	// Extract a pseudorandom key without a salt, which is the same as a salt of zeros.
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(key[:])
+	prk := extract.Sum(nil)
+
+	// Expand it to a single block, which has the size of the derived key.
+	expand := hmac.New(sha256.New, prk)
+	expand.Write([]byte(namespaceKeyInfo + namespace))
+	expand.Write([]byte{1})
+	return [32]byte(expand.Sum(nil))
+}
+
+// encrypt encrypts and authenticates the plaintext together with the associated data using AES-GCM.
+// The random nonce is prepended to the ciphertext.
+func encrypt(plaintext []byte, key [32]byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// newGCM returns an AES-256 cipher in Galois/Counter Mode.
+func newGCM(key [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+// IntegrityFailure describes an object whose value cannot be decoded or fails to authenticate.
+type IntegrityFailure struct {
+	Err       error
+	Key       string
+	Namespace string
+}
+
+// Verify decrypts the value of every object in all namespaces and returns the objects which are corrupt or
+// fail to authenticate, e.g. because they were encrypted with a key which is not configured.
+// Other errors, like a failing port, stop the scan.
+func (a *ObjectService) Verify(ctx context.Context) (failures []IntegrityFailure, err error) {
+	r := ports.Range[string]{Limit: scanPageSize}
+	for {
+		page, err := a.scan(ctx, r)
+		if err != nil {
+			return failures, err
+		}
+		for _, key := range page.Keys {
+			raw, err := a.load(ctx, key)
+			if err != nil {
+				return failures, err
+			}
+			// The object has been deleted in the meantime.
+			if raw == "" {
+				continue
+			}
+			_, err = a.open(ctx, key, decodeEnvelope(raw))
+			if errors.Is(err, ErrCorruptValue) || errors.Is(err, ErrDecryptionFailed) {
+				namespace, name := splitKey(key)
+				failures = append(failures, IntegrityFailure{Err: err, Key: name, Namespace: namespace})
+			}
+		}
+		if page.Next == "" {
+			return failures, nil
+		}
+		r.After = page.Next
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/security"
+)
+
+var (
+	// ErrorUnknownKey is returned when a value was encrypted with a key which is not configured.
+	// It is a kind of ErrDecryptionFailed.
+	ErrorUnknownKey = fmt.Errorf("%w: unknown encryption key", ErrDecryptionFailed)
+)
+
+// scanPageSize is the number of keys which are read at once by the re-encryption and the integrity scan.
+const scanPageSize = 100
+
+// Reencrypt migrates all values of all namespaces which were encrypted with a retired key or
+// in an older format to the active key and the current format. If a key provider is set, the values are
+// migrated to envelope encryption with the active master key.
+// It runs online: a value which is changed concurrently is skipped, because the new value
+// has already been encrypted with the active key. The version and the expiry of each
+// object are kept, because its content does not change. It returns the number of migrated values.
+func (a *ObjectService) Reencrypt(ctx context.Context) (count int, err error) {
+	r := ports.Range[string]{Limit: scanPageSize}
+	for {
+		page, err := a.scan(ctx, r)
+		if err != nil {
+			return count, err
+		}
+		for _, key := range page.Keys {
+			migrated, err := a.reencrypt(ctx, key)
+			// Values which cannot be read are reported by the integrity scan instead.
+			if errors.Is(err, ErrCorruptValue) || errors.Is(err, ErrDecryptionFailed) {
+				log.Printf("skipping re-encryption of %q: %v", key, err)
+				continue
+			}
+			if err != nil {
+				return count, fmt.Errorf("re-encryption of %q failed: %w", key, err)
+			}
+			if migrated {
+				count++
+			}
+		}
+		if page.Next == "" {
+			return count, nil
+		}
+		r.After = page.Next
+	}
+}
+
+// dataKey returns the key which decrypts the value of the envelope.
+// In formatEnvelope and formatEnvelopeExpiry, the data key is unwrapped by the key provider,
+// otherwise it is the configured encryption key with the ID of the envelope.
+func (a *ObjectService) dataKey(ctx context.Context, env envelope) ([32]byte, error) {
+	if env.Format != formatEnvelope && env.Format != formatEnvelopeExpiry {
+		return a.key(env.KeyID)
+	}
+	if a.keys == nil {
+		return [32]byte{}, ErrorUnknownKey
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(env.DataKey)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("%w: %v", ErrCorruptValue, err)
+	}
+
+	// Errors of the provider itself, like an unavailable KMS, are passed on as they are.
+	dataKey, err := a.keys.Unwrap(ctx, env.KeyID, wrapped)
+	if errors.Is(err, ports.ErrorUnknownMasterKey) || errors.Is(err, ports.ErrorUnwrapFailed) {
+		return [32]byte{}, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	if err != nil {
+		return [32]byte{}, err
+	}
+	if len(dataKey) != len([32]byte{}) {
+		return [32]byte{}, fmt.Errorf("%w: %v", ErrDecryptionFailed, errInvalidDataKey)
+	}
+	return [32]byte(dataKey), nil
+}
+
+// key returns the encryption key with the given ID, which is either the active or a retired key.
+func (a *ObjectService) key(id string) ([32]byte, error) {
+	if id == a.cfg.Service.KeyID {
+		return a.cfg.Service.Key, nil
+	}
+	if key, ok := a.cfg.Service.RetiredKeys[id]; ok {
+		return key, nil
+	}
+	return [32]byte{}, ErrorUnknownKey
+}
+
+// migrated reports whether the value of the envelope is encrypted in the current format,
+// which is formatEnvelopeExpiry with the active master key if a key provider is set and
+// formatBoundExpiry with the active encryption key otherwise.
+func (a *ObjectService) migrated(env envelope) bool {
+	if a.keys != nil {
+		return env.Format == formatEnvelopeExpiry && env.KeyID == a.keys.KeyID()
+	}
+	return env.Format == formatBoundExpiry && env.KeyID == a.cfg.Service.KeyID
+}
+
+// open decrypts the value of the envelope with the key it was encrypted with, which is derived
+// for the namespace of the object. Values in the current formats are authenticated together
+// with the key, version and expiry of their object.
+// Values in the older formats, which are not bound to their object or its expiry, are only accepted,
+// unless they are rejected by the configuration.
+func (a *ObjectService) open(ctx context.Context, key string, env envelope) (string, error) {
+	encryptionKey, err := a.dataKey(ctx, env)
+	if err != nil {
+		return "", err
+	}
+	namespace, _ := splitKey(key)
+	encryptionKey = deriveKey(encryptionKey, namespace)
+
+	// Decode the ciphertext from base64.
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCorruptValue, err)
+	}
+
+	// Decrypt the value using the encryption key, which also authenticates it.
+	var plaintext []byte
+	switch {
+	case env.Format == formatBoundExpiry || env.Format == formatEnvelopeExpiry:
+		plaintext, err = decrypt(ciphertext, encryptionKey, expiryAssociatedData(key, env.Version, env.ExpiresAt))
+	case (env.Format == formatBound || env.Format == formatEnvelope) && !a.cfg.Service.RejectLegacy:
+		plaintext, err = decrypt(ciphertext, encryptionKey, associatedData(key, env.Version))
+	case env.Format == formatLegacy && !a.cfg.Service.RejectLegacy:
+		plaintext, err = security.Decrypt(ciphertext, encryptionKey)
+	default:
+		err = fmt.Errorf("format %d is not accepted", env.Format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// reencrypt replaces the value of the key by a value encrypted with the active key in the
+// current format, if it was encrypted with a retired key or in an older format.
+// It reports whether the value was migrated.
+func (a *ObjectService) reencrypt(ctx context.Context, key string) (migrated bool, err error) {
+	old, err := a.load(ctx, key)
+	if err != nil || old == "" {
+		return false, err
+	}
+	current := decodeEnvelope(old)
+	if a.migrated(current) {
+		return false, nil
+	}
+
+	plaintext, err := a.open(ctx, key, current)
+	if err != nil {
+		return false, err
+	}
+	env, err := a.seal(ctx, key, current.Version, current.ExpiresAt, plaintext)
+	if err != nil {
+		return false, err
+	}
+	raw := env.encode()
+
+	if migrated, err = a.calls.swap(ctx, swapRequest{Key: key, Old: old, Value: raw}); err != nil || !migrated {
+		return false, err
+	}
+
+	// The swap removed the expiry from the port, thus it has to be set again.
+	if err := a.expire(ctx, key, raw, env); err != nil {
+		return false, err
+	}
+
+	// If a transactional logger is configured, write the put operation to the log.
+	if a.tx != nil {
+		a.tx.WritePut(key, raw)
+	}
+
+	return true, nil
+}
+
+// seal encrypts the value and binds it to the key, version and expiry of its object.
+// The expiry is the Unix time in nanoseconds or zero if the object does not expire.
+// If a key provider is set, the value is encrypted with a fresh data key, which is wrapped
+// by the active master key. Otherwise the active encryption key is used.
+// Either key is derived for the namespace of the object before it encrypts the value.
+func (a *ObjectService) seal(ctx context.Context, key string, version uint64, expiresAt int64, value string) (envelope, error) {
+	namespace, _ := splitKey(key)
+	if a.keys == nil {
+		ciphertext, err := encrypt([]byte(value), deriveKey(a.cfg.Service.Key, namespace), expiryAssociatedData(key, version, expiresAt))
+		if err != nil {
+			return envelope{}, err
+		}
+		return envelope{
+			Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+			ExpiresAt:  expiresAt,
+			Format:     formatBoundExpiry,
+			KeyID:      a.cfg.Service.KeyID,
+			Version:    version,
+		}, nil
+	}
+
+	dataKey, err := newDataKey()
+	if err != nil {
+		return envelope{}, err
+	}
+	ciphertext, err := encrypt([]byte(value), deriveKey(dataKey, namespace), expiryAssociatedData(key, version, expiresAt))
+	if err != nil {
+		return envelope{}, err
+	}
+	wrapped, err := a.keys.Wrap(ctx, dataKey[:])
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		DataKey:    base64.StdEncoding.EncodeToString(wrapped),
+		ExpiresAt:  expiresAt,
+		Format:     formatEnvelopeExpiry,
+		KeyID:      a.keys.KeyID(),
+		Version:    version,
+	}, nil
+}
+
+// startReencryption migrates the values encrypted with retired keys or in an older format
+// in the background, if any retired keys are configured or the re-encryption is enabled.
+func (a *ObjectService) startReencryption(ctx context.Context) {
+	if len(a.cfg.Service.RetiredKeys) == 0 && !a.cfg.Service.Reencrypt {
+		return
+	}
+
+	a.tasks.Add(1)
+	go func() {
+		defer a.tasks.Done()
+		count, err := a.Reencrypt(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error during re-encryption: %v", err)
+			return
+		}
+		log.Printf("re-encrypted %d values with the active key", count)
+	}()
+}
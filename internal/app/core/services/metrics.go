@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of the latency histograms.
+var LatencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Metrics records the operations of the ObjectService and the calls of its port.
+// It is safe for concurrent use.
+type Metrics struct {
+	calls      map[string]*callMetrics
+	mutex      sync.Mutex
+	operations map[string]*operationMetrics
+}
+
+// callMetrics are the metrics of the calls of the port, which are protected by the stability patterns.
+type callMetrics struct {
+	breaker *breaker // Breaker of the call, which is nil if it is disabled.
+	retries uint64
+}
+
+// operationMetrics are the metrics of an operation of the service.
+type operationMetrics struct {
+	buckets []uint64 // Number of operations per bucket of LatencyBuckets, which are not cumulative.
+	count   uint64
+	errors  map[string]uint64
+	sum     time.Duration
+}
+
+// CallStats are the metrics of the calls of the port, e.g. "get" or "swap".
+type CallStats struct {
+	BreakerState BreakerState // State of the breaker, which is closed if the breaker is disabled.
+	BreakerTrips uint64       // Number of times the breaker has been opened, including the failed trials.
+	Call         string
+	Retries      uint64
+}
+
+// OperationStats are the metrics of an operation of the service, e.g. "get" or "put".
+type OperationStats struct {
+	Buckets   []uint64          // Cumulative number of operations per bucket of LatencyBuckets.
+	Count     uint64            // Number of operations including the failed ones.
+	Errors    map[string]uint64 // Number of failed operations by the type of the error.
+	Operation string
+	Sum       time.Duration // Total latency of the operations.
+}
+
+// Stats is a snapshot of the metrics of the ObjectService and its dependencies.
+type Stats struct {
+	Calls               []CallStats
+	Operations          []OperationStats
+	ShardKeys           []int // Number of keys per shard, if the port is sharded.
+	TransactionLogBytes int64 // Size of the transaction log or -1 if it is unknown.
+}
+
+// attemptsContextKey is the key of the counter of the attempts of a call in its context.
+type attemptsContextKey struct{}
+
+// sharded is implemented by ports which count the keys of each shard.
+type sharded interface {
+	ShardSizes() []int
+}
+
+// sized is implemented by transactional loggers which know the size of the log.
+type sized interface {
+	Size() (int64, error)
+}
+
+// newMetrics creates a new instance of Metrics without any recorded operations.
+func newMetrics() *Metrics {
+	return &Metrics{calls: make(map[string]*callMetrics), operations: make(map[string]*operationMetrics)}
+}
+
+// Stats returns a snapshot of the metrics of the service, its port and its transactional logger.
+// The operations and calls are sorted by their names.
+func (a *ObjectService) Stats() Stats {
+	stats := a.metrics.stats()
+	stats.TransactionLogBytes = -1
+	if port, ok := a.port.(sharded); ok {
+		stats.ShardKeys = port.ShardSizes()
+	}
+	if logger, ok := a.tx.(sized); ok {
+		if size, err := logger.Size(); err == nil {
+			stats.TransactionLogBytes = size
+		}
+	}
+	return stats
+}
+
+// call records a call of the port, which has been attempted the given number of times.
+// A call without any attempt has been rejected by its open breaker, whose state is read by the stats.
+func (a *Metrics) call(name string, b *breaker, attempts int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	m, ok := a.calls[name]
+	if !ok {
+		m = &callMetrics{breaker: b}
+		a.calls[name] = m
+	}
+	if attempts > 0 {
+		m.retries += uint64(attempts - 1)
+	}
+}
+
+// observe records the latency and the error of an operation, which started at the given time.
+// It is deferred with a pointer to the error, which is returned by the operation.
+func (a *Metrics) observe(operation string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	m, ok := a.operations[operation]
+	if !ok {
+		m = &operationMetrics{buckets: make([]uint64, len(LatencyBuckets)), errors: make(map[string]uint64)}
+		a.operations[operation] = m
+	}
+	m.count++
+	m.sum += elapsed
+	for i, bound := range LatencyBuckets {
+		if elapsed.Seconds() <= bound {
+			m.buckets[i]++
+			break
+		}
+	}
+	if *err != nil {
+		m.errors[errorType(*err)]++
+	}
+}
+
+// stats returns a snapshot of the recorded operations and calls.
+func (a *Metrics) stats() (stats Stats) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for _, name := range slices.Sorted(maps.Keys(a.calls)) {
+		m := a.calls[name]
+		call := CallStats{BreakerState: BreakerClosed, Call: name, Retries: m.retries}
+		if m.breaker != nil {
+			call.BreakerState, call.BreakerTrips = m.breaker.snapshot(now)
+		}
+		stats.Calls = append(stats.Calls, call)
+	}
+	for _, name := range slices.Sorted(maps.Keys(a.operations)) {
+		m := a.operations[name]
+		buckets := make([]uint64, len(m.buckets))
+		var total uint64
+		for i, count := range m.buckets {
+			total += count
+			buckets[i] = total
+		}
+		stats.Operations = append(stats.Operations, OperationStats{
+			Buckets:   buckets,
+			Count:     m.count,
+			Errors:    maps.Clone(m.errors),
+			Operation: name,
+			Sum:       m.sum,
+		})
+	}
+	return stats
+}
+
+// countAttempt increments the counter of the attempts in the context, if there is one.
+func countAttempt(ctx context.Context) {
+	if attempts, ok := ctx.Value(attemptsContextKey{}).(*atomic.Int64); ok {
+		attempts.Add(1)
+	}
+}
+
+// errorType returns the type of the error, which is used as the label of the error metrics.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return "not_found"
+	case errors.Is(err, ErrorVersionMismatch):
+		return "version_mismatch"
+	case errors.Is(err, ErrorInvalidBatch), errors.Is(err, ErrorInvalidKey), errors.Is(err, ErrorInvalidNamespace):
+		return "invalid"
+	case errors.Is(err, ErrorBatchNotSupported):
+		return "not_supported"
+	case errors.Is(err, ErrorBreakerOpen):
+		return "breaker_open"
+	case errors.Is(err, ErrCorruptValue):
+		return "corrupt_value"
+	case errors.Is(err, ErrDecryptionFailed):
+		return "decryption_failed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "port"
+	}
+}
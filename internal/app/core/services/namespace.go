@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+// DefaultNamespace is the namespace of the keys which are not scoped to a tenant.
+// Its keys are stored as they are, which keeps the objects written before namespaces readable.
+const DefaultNamespace = ""
+
+// namespaceSeparator separates the namespace from the key inside the port.
+// It is not allowed in keys, thus the keys of the default namespace never collide with other namespaces.
+const namespaceSeparator = "\x00"
+
+var (
+	// ErrorInvalidKey is returned when a key contains a character which is reserved for namespaces.
+	ErrorInvalidKey = errors.New("invalid key")
+	// ErrorInvalidNamespace is returned when the name of a namespace is not allowed.
+	ErrorInvalidNamespace = errors.New("invalid namespace")
+)
+
+// namespacePattern is the pattern of the names of namespaces.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Namespace is an isolated key space of the ObjectService, e.g. of a tenant.
+// The values of each namespace are encrypted with its own key, which is derived from the
+// encryption key, and are bound to the namespace, so that they cannot be decrypted in another one.
+type Namespace struct {
+	name string
+	svc  *ObjectService
+}
+
+// Namespace returns the namespace with the given name, which consists of up to 63 lower case
+// letters, digits, underscores and dashes. An empty name refers to the default namespace.
+func (a *ObjectService) Namespace(name string) (*Namespace, error) {
+	if name == DefaultNamespace {
+		return a.root, nil
+	}
+	if !namespacePattern.MatchString(name) {
+		return nil, ErrorInvalidNamespace
+	}
+	return &Namespace{name: name, svc: a}, nil
+}
+
+// Apply applies either all operations or none of them and logs them as a single batch.
+// It returns the new version of each object, which is zero for deleted objects,
+// or ErrorVersionMismatch if any object does not match the condition of its operation.
+func (a *Namespace) Apply(ctx context.Context, ops []Operation) (versions []uint64, err error) {
+	if err := validate(ops); err != nil {
+		return nil, err
+	}
+	scoped := make([]Operation, len(ops))
+	for i, op := range ops {
+		if op.Key, err = a.key(op.Key); err != nil {
+			return nil, err
+		}
+		scoped[i] = op
+	}
+	return a.svc.apply(ctx, scoped)
+}
+
+// Delete removes an object identified by the key and logs the operation.
+func (a *Namespace) Delete(ctx context.Context, key string) (err error) {
+	return a.DeleteIf(ctx, key, Condition{})
+}
+
+// DeleteIf removes an object identified by the key, if it matches the condition,
+// and logs the operation. It returns ErrorVersionMismatch if the condition is not fulfilled.
+func (a *Namespace) DeleteIf(ctx context.Context, key string, cond Condition) (err error) {
+	if key, err = a.key(key); err != nil {
+		return err
+	}
+	return a.svc.deleteIf(ctx, key, cond)
+}
+
+// Get retrieves an object identified by the key.
+func (a *Namespace) Get(ctx context.Context, key string) (value string, err error) {
+	obj, err := a.GetObject(ctx, key)
+	return obj.Value, err
+}
+
+// GetObject retrieves an object identified by the key together with its version.
+func (a *Namespace) GetObject(ctx context.Context, key string) (obj Object, err error) {
+	if key, err = a.key(key); err != nil {
+		return obj, err
+	}
+	return a.svc.getObject(ctx, key)
+}
+
+// Name returns the name of the namespace, which is empty for the default namespace.
+func (a *Namespace) Name() string {
+	return a.name
+}
+
+// Put adds or updates an object identified by the key and logs the operation.
+func (a *Namespace) Put(ctx context.Context, key, value string) (err error) {
+	_, err = a.PutIf(ctx, key, value, Condition{}, 0)
+	return err
+}
+
+// PutWithTTL adds or updates an object identified by the key, which expires after the ttl,
+// and logs the operation. A ttl of zero means that the object does not expire.
+func (a *Namespace) PutWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	_, err = a.PutIf(ctx, key, value, Condition{}, ttl)
+	return err
+}
+
+// PutIf adds or updates an object identified by the key, if it matches the condition,
+// and logs the operation. The object expires after the ttl, unless the ttl is zero.
+// It returns the new version of the object or ErrorVersionMismatch if the condition is not fulfilled.
+func (a *Namespace) PutIf(ctx context.Context, key, value string, cond Condition, ttl time.Duration) (version uint64, err error) {
+	if key, err = a.key(key); err != nil {
+		return 0, err
+	}
+	return a.svc.putIf(ctx, key, value, cond, ttl)
+}
+
+// Scan returns a page of the keys selected by the range in ascending order.
+// The keys of other namespaces are never returned.
+func (a *Namespace) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	prefix := a.prefix()
+	scoped := ports.Range[string]{Limit: r.Limit, Prefix: prefix + r.Prefix}
+	if r.After != "" {
+		scoped.After = prefix + r.After
+	}
+	if r.End != "" {
+		scoped.End = prefix + r.End
+	}
+	if r.Start != "" {
+		scoped.Start = prefix + r.Start
+	}
+
+	// The default namespace has no prefix, thus the port returns the keys of all other namespaces, too.
+	// They are skipped, which requires to read further pages until the page is complete.
+	page.Keys = []string{}
+	for {
+		raw, err := a.svc.scan(ctx, scoped)
+		if err != nil {
+			return page, err
+		}
+		for _, key := range raw.Keys {
+			if a.name == DefaultNamespace && strings.Contains(key, namespaceSeparator) {
+				continue
+			}
+			if r.Limit > 0 && len(page.Keys) == r.Limit {
+				page.Next = page.Keys[len(page.Keys)-1]
+				return page, nil
+			}
+			page.Keys = append(page.Keys, strings.TrimPrefix(key, prefix))
+		}
+		if raw.Next == "" {
+			return page, nil
+		}
+		scoped.After = raw.Next
+	}
+}
+
+// key returns the key of the object inside the port.
+func (a *Namespace) key(key string) (string, error) {
+	if strings.Contains(key, namespaceSeparator) {
+		return "", ErrorInvalidKey
+	}
+	return a.prefix() + key, nil
+}
+
+// prefix returns the prefix of the keys of the namespace inside the port.
+func (a *Namespace) prefix() string {
+	if a.name == DefaultNamespace {
+		return ""
+	}
+	return a.name + namespaceSeparator
+}
+
+// splitKey returns the namespace and the key of a key inside the port.
+func splitKey(key string) (namespace, name string) {
+	if namespace, name, found := strings.Cut(key, namespaceSeparator); found {
+		return namespace, name
+	}
+	return DefaultNamespace, key
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+var (
+	// ErrCorruptValue is returned when a stored value cannot be decoded.
+	ErrCorruptValue = errors.New("corrupt value")
+	// ErrDecryptionFailed is returned when a stored value cannot be decrypted or fails to authenticate.
+	ErrDecryptionFailed = errors.New("decryption failed")
+	// ErrKeyNotFound is returned when there is no object for the key.
+	// It is the same error as ports.ErrorKeyDoesNotExist.
+	ErrKeyNotFound = ports.ErrorKeyDoesNotExist
+	// ErrorVersionMismatch is returned when an object does not match the condition of a write.
+	ErrorVersionMismatch = errors.New("version mismatch")
+)
+
+// Object is a decrypted value together with its version.
+// ExpiresAt is the zero time if the object does not expire.
+type Object struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Value     string    `json:"value"`
+	Version   uint64    `json:"version"`
+}
+
+// Condition restricts a write to an expected state of the object.
+// The zero value does not restrict the write.
+type Condition struct {
+	Absent  bool   // The object must not exist, like "If-None-Match: *".
+	Present bool   // The object must exist, like "If-Match: *".
+	Version uint64 // The object must have this version, like "If-Match", if not zero.
+}
+
+// matches reports whether an object in the given state fulfills the condition.
+func (c Condition) matches(exists bool, version uint64) bool {
+	switch {
+	case c.Absent && exists:
+		return false
+	case c.Present && !exists:
+		return false
+	case c.Version != 0 && (!exists || c.Version != version):
+		return false
+	}
+	return true
+}
+
+// envelope is the representation of an object inside the port.
+// Values written before versioning was introduced only consist of the
+// base64 encoded ciphertext and are treated as version zero.
+type envelope struct {
+	Ciphertext string `json:"ciphertext"`
+	DataKey    string `json:"data_key,omitempty"`   // Base64 encoded data key wrapped by the key provider in the envelope formats.
+	ExpiresAt  int64  `json:"expires_at,omitempty"` // Unix time in nanoseconds or zero if the object does not expire.
+	Format     int    `json:"format,omitempty"`     // Format of the ciphertext, which is formatLegacy for values without associated data.
+	KeyID      string `json:"key_id,omitempty"`     // ID of the encryption or master key or empty for values written before key IDs.
+	Version    uint64 `json:"version"`
+}
+
+// decodeEnvelope returns the envelope of the raw value from the port.
+func decodeEnvelope(raw string) envelope {
+	var env envelope
+	if strings.HasPrefix(raw, "{") && json.Unmarshal([]byte(raw), &env) == nil {
+		return env
+	}
+	return envelope{Ciphertext: raw}
+}
+
+// expiresAt returns the time at which the object expires or the zero time if it does not expire.
+func (e envelope) expiresAt() time.Time {
+	if e.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, e.ExpiresAt)
+}
+
+// expired reports whether the object has expired at the given time.
+func (e envelope) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now.UnixNano()
+}
+
+// encode returns the raw value which is stored in the port.
+func (e envelope) encode() string {
+	data, _ := json.Marshal(e)
+	return string(data)
+}
+
+// nextVersion returns the version of the next write after the current version.
+// Versions are based on the clock, so that they also increase after an object was
+// deleted and created again. This prevents an outdated version from matching a new object.
+func nextVersion(current uint64) uint64 {
+	return max(current+1, uint64(time.Now().UnixNano()))
+}
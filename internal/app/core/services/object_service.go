@@ -2,155 +2,419 @@ package services
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/andygeiss/cloud-native-store/internal/app/config"
 	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
 	"github.com/andygeiss/cloud-native-utils/consistency"
-	"github.com/andygeiss/cloud-native-utils/security"
-	"github.com/andygeiss/cloud-native-utils/service"
-	"github.com/andygeiss/cloud-native-utils/stability"
 )
 
 type ObjectService struct {
-	cfg  *config.Config
-	tx   consistency.Logger[string, string] // Transactional logger for recording operations.
-	port ports.ObjectPort[string, string]   // Port interface for object interactions (e.g., CRUD operations).
+	calls   portCalls // Calls of the port, which are protected by the stability patterns.
+	cfg     *config.Config
+	cancel  context.CancelFunc                 // Stops the background tasks like compaction, expiry and re-encryption.
+	keys    ports.KeyProvider                  // Wraps the data keys of the values, if envelope encryption is used.
+	metrics *Metrics                           // Records the operations and the calls of the port.
+	tasks   sync.WaitGroup                     // Waits for the background tasks to stop.
+	tx      consistency.Logger[string, string] // Transactional logger for recording operations.
+	port    ports.ObjectPort[string, string]   // Port interface for object interactions (e.g., CRUD operations).
+	root    *Namespace                         // Default namespace, which is used by the methods of the service.
+}
+
+// compacter is implemented by transactional loggers which are able to replace
+// their history by a snapshot of the current state.
+type compacter interface {
+	Compact() error
 }
 
 // NewObjectService creates a new instance of ObjectService without any dependencies.
 func NewObjectService(cfg *config.Config) *ObjectService {
-	return &ObjectService{
-		cfg: cfg,
+	a := &ObjectService{
+		cfg:     cfg,
+		metrics: newMetrics(),
 	}
+	a.calls = newPortCalls(a, cfg.Stability)
+	a.root = &Namespace{name: DefaultNamespace, svc: a}
+	return a
 }
 
-// Delete removes an object identified by the key from the port and logs the operation.
+// Apply applies either all operations or none of them in the default namespace and logs them as a single batch.
+// It returns the new version of each object, which is zero for deleted objects,
+// or ErrorVersionMismatch if any object does not match the condition of its operation.
+func (a *ObjectService) Apply(ctx context.Context, ops []Operation) (versions []uint64, err error) {
+	return a.root.Apply(ctx, ops)
+}
+
+// Delete removes an object identified by the key from the default namespace and logs the operation.
 func (a *ObjectService) Delete(ctx context.Context, key string) (err error) {
+	return a.root.Delete(ctx, key)
+}
 
-	// Define the function to be executed with the stability patterns applied.
-	fn := func() service.Function[string, string] {
-		return func(context.Context, string) (value string, err error) {
-			err = a.port.Delete(ctx, key)
-			return value, err
-		}
-	}()
+// DeleteIf removes an object identified by the key from the default namespace, if it matches the condition,
+// and logs the operation. It returns ErrorVersionMismatch if the condition is not fulfilled.
+func (a *ObjectService) DeleteIf(ctx context.Context, key string, cond Condition) (err error) {
+	return a.root.DeleteIf(ctx, key, cond)
+}
 
-	// Apply the stability patterns to the function.
-	fn = stability.Timeout(fn, security.ParseDuration("STORE_TIMEOUT", 5*time.Second))
-	fn = stability.Debounce(fn, time.Second/time.Duration(security.ParseInt("STORE_DEBOUNCE_PER_SEC", 50)))
-	fn = stability.Retry(fn, security.ParseInt("STORE_RETRY_MAX", 3), security.ParseDuration("STORE_RETRY_DELAY", 5*time.Second))
-	fn = stability.Breaker(fn, security.ParseInt("STORE_BREAKER_THRESHOLD", 3))
+// Get retrieves an object identified by the key from the default namespace.
+func (a *ObjectService) Get(ctx context.Context, key string) (value string, err error) {
+	return a.root.Get(ctx, key)
+}
 
-	// Execute the function with the stability patterns applied.
-	_, err = fn(ctx, key)
-	if err != nil {
-		return
-	}
+// GetObject retrieves an object identified by the key from the default namespace together with its version.
+func (a *ObjectService) GetObject(ctx context.Context, key string) (obj Object, err error) {
+	return a.root.GetObject(ctx, key)
+}
 
-	// If a transactional logger is configured, write the delete operation to the log.
-	if a.tx != nil {
-		a.tx.WriteDelete(key)
+// Put adds or updates an object identified by the key in the default namespace and logs the operation.
+func (a *ObjectService) Put(ctx context.Context, key, value string) (err error) {
+	return a.root.Put(ctx, key, value)
+}
+
+// PutWithTTL adds or updates an object identified by the key in the default namespace, which expires
+// after the ttl, and logs the operation. A ttl of zero means that the object does not expire.
+func (a *ObjectService) PutWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	return a.root.PutWithTTL(ctx, key, value, ttl)
+}
+
+// PutIf adds or updates an object identified by the key in the default namespace, if it matches the condition,
+// and logs the operation. The object expires after the ttl, unless the ttl is zero.
+// It returns the new version of the object or ErrorVersionMismatch if the condition is not fulfilled.
+func (a *ObjectService) PutIf(ctx context.Context, key, value string, cond Condition, ttl time.Duration) (version uint64, err error) {
+	return a.root.PutIf(ctx, key, value, cond, ttl)
+}
+
+// Scan returns a page of the keys of the default namespace selected by the range in ascending order.
+func (a *ObjectService) Scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	return a.root.Scan(ctx, r)
+}
+
+// Setup initializes the ObjectService by processing pending events
+// from the transactional logger and applying them to the data store.
+// Loggers which support snapshots deliver the latest snapshot first, followed by
+// the events written after it. Afterwards the background tasks like the periodic
+// compaction, the removal of expired objects and the re-encryption are started.
+func (a *ObjectService) Setup() (err error) {
+	if err := a.Replay(); err != nil {
+		return err
 	}
 
+	// Start the background tasks after the events have been applied successfully.
+	a.start()
 	return nil
 }
 
-// Get retrieves an object identified by the key from the port.
-func (a *ObjectService) Get(ctx context.Context, key string) (value string, err error) {
+// Replay applies the pending events of the transactional logger to the data store
+// without starting any background tasks. It is used by tools which inspect the store.
+func (a *ObjectService) Replay() (err error) {
 
-	// Define the function to be executed with the stability patterns applied.
-	fn := func() service.Function[string, string] {
-		return func(context.Context, string) (string, error) {
-			return a.port.Get(ctx, key)
-		}
-	}()
+	// Do not read events if there is no logger configured.
+	if a.tx == nil {
+		return
+	}
 
-	// Apply the stability patterns to the function.
-	fn = stability.Timeout(fn, security.ParseDuration("STORE_TIMEOUT", 5*time.Second))
-	fn = stability.Debounce(fn, time.Second/time.Duration(security.ParseInt("STORE_DEBOUNCE_PER_SEC", 50)))
-	fn = stability.Retry(fn, security.ParseInt("STORE_RETRY_MAX", 3), security.ParseDuration("STORE_RETRY_DELAY", 5*time.Second))
-	fn = stability.Breaker(fn, security.ParseInt("STORE_BREAKER_THRESHOLD", 3))
+	// Start reading batches of events and errors from the transactional logger.
+	batchCh, errCh := a.readBatches()
 
-	// Execute the function with the stability patterns applied.
-	value, err = fn(ctx, key)
-	if err != nil {
+	// Create a context with cancellation to manage the lifecycle of operations.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Ensure the context is cancelled when the function exits.
+
+	for {
+		select {
+		case events, ok := <-batchCh:
+			if !ok {
+				// The batch channel has been closed, signaling no more events.
+				// An error may still be pending, because it could have been reported right before.
+				select {
+				case err, ok := <-errCh:
+					if ok && err != nil {
+						return err
+					}
+				default:
+				}
+				return nil
+			}
+			if err := a.replay(ctx, events); err != nil {
+				return err // Return the error if the events could not be applied.
+			}
+		case err, ok := <-errCh:
+			// Handle errors reported by the error channel.
+			if ok && err != nil {
+				return err // Return the error if any occurred during processing.
+			}
+		}
+	}
+}
+
+// Teardown cleans up any resources used by the ObjectService.
+func (a *ObjectService) Teardown() {
+	// Wait for the background tasks before the logger is closed.
+	if a.cancel != nil {
+		a.cancel()
+		a.tasks.Wait()
+	}
+	// Skip if there is no transactional logger configured.
+	if a.tx == nil {
 		return
 	}
+	if err := a.tx.Close(); err != nil {
+		log.Fatalf("error during close: %v", err)
+	}
+}
 
-	// Decode the ciphertext from base64.
-	ciphertext, _ := base64.StdEncoding.DecodeString(value)
+// apply applies either all operations on the keys of the port or none of them and logs them as a single batch.
+func (a *ObjectService) apply(ctx context.Context, ops []Operation) (versions []uint64, err error) {
+	defer a.metrics.observe("apply", time.Now(), &err)
+	if _, ok := a.port.(ports.BatchPort[string, string]); !ok {
+		return nil, ErrorBatchNotSupported
+	}
+
+	var envs []envelope
+	var writes []ports.Write[string, string]
+	for applied := false; !applied; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	// Decrypt the value using the encryption key from the configuration.
-	plaintext, _ := security.Decrypt(ciphertext, a.cfg.Service.Key)
+		// Build the batch from the current objects, which must match the conditions.
+		envs = make([]envelope, len(ops))
+		writes = make([]ports.Write[string, string], len(ops))
+		for i, op := range ops {
+			old, err := a.load(ctx, op.Key)
+			if err != nil {
+				return nil, err
+			}
+			current := decodeEnvelope(old)
+			if !op.Condition.matches(old != "" && !current.expired(time.Now()), current.Version) {
+				return nil, ErrorVersionMismatch
+			}
+			writes[i] = ports.Write[string, string]{Key: op.Key, Old: old}
+			if op.Type == OperationPut {
+				// The value is bound to its version and expiry, thus it is encrypted for every attempt.
+				var expiresAt int64
+				if op.TTL > 0 {
+					expiresAt = time.Now().Add(op.TTL).UnixNano()
+				}
+				if envs[i], err = a.seal(ctx, op.Key, nextVersion(current.Version), expiresAt, op.Value); err != nil {
+					return nil, err
+				}
+				writes[i].Value = envs[i].encode()
+			}
+		}
 
-	value = string(plaintext)
+		if applied, err = a.calls.apply(ctx, writes); err != nil {
+			return nil, err
+		}
+	}
 
-	return value, nil
-}
+	// Let the port remove the objects when they expire.
+	versions = make([]uint64, len(ops))
+	events := make([]consistency.Event[string, string], len(ops))
+	for i, op := range ops {
+		events[i] = consistency.Event[string, string]{EventType: consistency.EventTypeDelete, Key: op.Key}
+		if op.Type == OperationPut {
+			if err := a.expire(ctx, op.Key, writes[i].Value, envs[i]); err != nil {
+				return nil, err
+			}
+			events[i] = consistency.Event[string, string]{EventType: consistency.EventTypePut, Key: op.Key, Value: writes[i].Value}
+			versions[i] = envs[i].Version
+		}
+	}
 
-// Put adds or updates an object identified by the key and logs the operation.
-func (a *ObjectService) Put(ctx context.Context, key, value string) (err error) {
+	// If a transactional logger is configured, write the batch to the log.
+	// Loggers without support for batches get the operations one by one.
+	if logger, ok := a.tx.(batchLogger); ok {
+		logger.WriteBatch(events)
+	} else if a.tx != nil {
+		for _, event := range events {
+			if event.EventType == consistency.EventTypePut {
+				a.tx.WritePut(event.Key, event.Value)
+			} else {
+				a.tx.WriteDelete(event.Key)
+			}
+		}
+	}
 
-	// Encrypt the value using the encryption key from the configuration.
-	value = string(security.Encrypt([]byte(value), a.cfg.Service.Key))
+	return versions, nil
+}
 
-	// Encode the value to base64.
-	value = base64.StdEncoding.EncodeToString([]byte(value))
+// compareAndSwap replaces the raw value of the key by the result of next, if the current
+// object matches the condition. An empty result deletes the key. If the value was changed
+// concurrently, the object is read again and the condition is checked once more.
+func (a *ObjectService) compareAndSwap(ctx context.Context, key string, cond Condition, next func(current envelope) (string, error)) (err error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	// Define the function to be executed with the stability patterns applied.
-	fn := func() service.Function[string, string] {
-		return func(context.Context, string) (string, error) {
-			err = a.port.Put(ctx, key, value)
-			return value, err
+		old, err := a.load(ctx, key)
+		if err != nil {
+			return err
 		}
-	}()
 
-	// Apply the stability patterns to the function.
-	fn = stability.Timeout(fn, security.ParseDuration("STORE_TIMEOUT", 5*time.Second))
-	fn = stability.Debounce(fn, time.Second/time.Duration(security.ParseInt("STORE_DEBOUNCE_PER_SEC", 50)))
-	fn = stability.Retry(fn, security.ParseInt("STORE_RETRY_MAX", 3), security.ParseDuration("STORE_RETRY_DELAY", 5*time.Second))
-	fn = stability.Breaker(fn, security.ParseInt("STORE_BREAKER_THRESHOLD", 3))
+		// An expired object which has not been removed yet does not exist anymore.
+		current := decodeEnvelope(old)
+		if !cond.matches(old != "" && !current.expired(time.Now()), current.Version) {
+			return ErrorVersionMismatch
+		}
+		value, err := next(current)
+		if err != nil {
+			return err
+		}
 
-	// Execute the function with the stability patterns applied.
-	value, err = fn(ctx, key)
+		swapped, err := a.calls.swap(ctx, swapRequest{Key: key, Old: old, Value: value})
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// deleteIf removes the key from the port, if its object matches the condition, and logs the operation.
+func (a *ObjectService) deleteIf(ctx context.Context, key string, cond Condition) (err error) {
+	defer a.metrics.observe("delete", time.Now(), &err)
+
+	// Delete the object without reading it, if there is no condition.
+	if cond == (Condition{}) {
+		_, err = a.calls.delete(ctx, key)
+	} else {
+		err = a.compareAndSwap(ctx, key, cond, func(envelope) (string, error) {
+			return "", nil
+		})
+	}
 	if err != nil {
 		return
 	}
 
-	// If a transactional logger is configured, write the put operation to the log.
+	// If a transactional logger is configured, write the delete operation to the log.
 	if a.tx != nil {
-		a.tx.WritePut(key, value)
+		a.tx.WriteDelete(key)
 	}
 
 	return nil
 }
 
-// Setup initializes the ObjectService by processing pending events
-// from the transactional logger and applying them to the data store.
-func (a *ObjectService) Setup() (err error) {
+// expire lets the port remove the object when it expires, if the port supports it.
+// A concurrent write of the key is not an error, because it replaced the expiring object.
+func (a *ObjectService) expire(ctx context.Context, key, raw string, env envelope) error {
+	port, ok := a.port.(ports.ExpiringPort[string, string])
+	if !ok || env.ExpiresAt == 0 {
+		return nil
+	}
+	if err := port.Expire(ctx, key, raw, env.expiresAt()); err != nil && !errors.Is(err, ports.ErrorValueChanged) {
+		return err
+	}
+	return nil
+}
 
-	// Do not read events if there is no logger configured.
-	if a.tx == nil {
+// getObject retrieves the object of the key from the port and decrypts its value.
+func (a *ObjectService) getObject(ctx context.Context, key string) (obj Object, err error) {
+	defer a.metrics.observe("get", time.Now(), &err)
+
+	// Execute the call of the port with the stability patterns applied.
+	raw, err := a.calls.get(ctx, key)
+	if err != nil {
 		return
 	}
+	env := decodeEnvelope(raw)
 
-	// Start reading events and errors from the transactional logger.
-	eventCh, errCh := a.tx.ReadEvents()
+	// Expired objects are invisible, even if they have not been removed yet.
+	if env.expired(time.Now()) {
+		return obj, ports.ErrorKeyDoesNotExist
+	}
 
-	// Create a context with cancellation to manage the lifecycle of operations.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel() // Ensure the context is cancelled when the function exits.
+	// Decrypt the value using the key it was encrypted with.
+	plaintext, err := a.open(ctx, key, env)
+	if err != nil {
+		return
+	}
 
-	for {
-		select {
-		case event, ok := <-eventCh:
-			if !ok {
-				// The event channel has been closed, signaling no more events.
-				return nil
+	return Object{ExpiresAt: env.expiresAt(), Value: plaintext, Version: env.Version}, nil
+}
+
+// load returns the current raw value of the key, which is empty if the key does not exist.
+func (a *ObjectService) load(ctx context.Context, key string) (string, error) {
+	return a.calls.load(ctx, key)
+}
+
+// putIf writes the next version of the object of the key to the port, if it matches the condition,
+// and logs the operation.
+func (a *ObjectService) putIf(ctx context.Context, key, value string, cond Condition, ttl time.Duration) (version uint64, err error) {
+	defer a.metrics.observe("put", time.Now(), &err)
+
+	// Replace the current object by the next version, which is encrypted using the active
+	// encryption key. The value is bound to its version and expiry, thus it is encrypted for every attempt.
+	var env envelope
+	var raw string
+	err = a.compareAndSwap(ctx, key, cond, func(current envelope) (string, error) {
+		var expiresAt int64
+		if ttl > 0 {
+			expiresAt = time.Now().Add(ttl).UnixNano()
+		}
+		var err error
+		if env, err = a.seal(ctx, key, nextVersion(current.Version), expiresAt, value); err != nil {
+			return "", err
+		}
+		raw = env.encode()
+		return raw, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Let the port remove the object when it expires.
+	if err := a.expire(ctx, key, raw, env); err != nil {
+		return 0, err
+	}
+
+	// If a transactional logger is configured, write the put operation to the log.
+	if a.tx != nil {
+		a.tx.WritePut(key, raw)
+	}
+
+	return env.Version, nil
+}
+
+// readBatches reads the events of the transactional logger in batches, which have to be applied atomically.
+// Every event of a logger without support for batches is a batch on its own.
+func (a *ObjectService) readBatches() (<-chan []consistency.Event[string, string], <-chan error) {
+	if logger, ok := a.tx.(batchLogger); ok {
+		return logger.ReadBatches()
+	}
+	eventCh, errCh := a.tx.ReadEvents()
+	batchCh := make(chan []consistency.Event[string, string])
+	go func() {
+		defer close(batchCh)
+		for event := range eventCh {
+			batchCh <- []consistency.Event[string, string]{event}
+		}
+	}()
+	return batchCh, errCh
+}
+
+// replay applies a batch of events from the transactional logger to the port.
+// A batch of several events is applied atomically, if the port supports it.
+func (a *ObjectService) replay(ctx context.Context, events []consistency.Event[string, string]) error {
+	if port, ok := a.port.(ports.BatchPort[string, string]); ok && len(events) > 1 {
+		writes := make([]ports.Write[string, string], len(events))
+		for i, event := range events {
+			old, err := a.port.Get(ctx, event.Key)
+			if err != nil && !errors.Is(err, ports.ErrorKeyDoesNotExist) {
+				return err
+			}
+			writes[i] = ports.Write[string, string]{Key: event.Key, Old: old}
+			if event.EventType == consistency.EventTypePut {
+				writes[i].Value = event.Value
 			}
+		}
+		if err := port.Apply(ctx, writes); err != nil {
+			return err
+		}
+	} else {
+		for _, event := range events {
 			// Handle the specific type of event received.
 			switch event.EventType {
 			case consistency.EventTypeDelete:
@@ -164,24 +428,84 @@ func (a *ObjectService) Setup() (err error) {
 					return err // Return the error if the put operation fails.
 				}
 			}
-		case err, ok := <-errCh:
-			// Handle errors reported by the error channel.
-			if ok && err != nil {
-				return err // Return the error if any occurred during processing.
-			}
 		}
 	}
+
+	// Restore the expiry of the objects, which is part of their values.
+	for _, event := range events {
+		if event.EventType != consistency.EventTypePut {
+			continue
+		}
+		if err := a.expire(ctx, event.Key, event.Value, decodeEnvelope(event.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Teardown cleans up any resources used by the ObjectService.
-func (a *ObjectService) Teardown() {
-	// Skip if there is no transactional logger configured.
-	if a.tx == nil {
+// scan returns a page of the keys of the port selected by the range in ascending order.
+func (a *ObjectService) scan(ctx context.Context, r ports.Range[string]) (page ports.Page[string], err error) {
+	defer a.metrics.observe("scan", time.Now(), &err)
+
+	// Execute the call of the port with the stability patterns applied.
+	return a.calls.scan(ctx, r)
+}
+
+// start runs the background tasks until Teardown is called.
+func (a *ObjectService) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.startCompaction(ctx)
+	a.startReencryption(ctx)
+	a.startSweeper(ctx)
+}
+
+// startCompaction periodically replaces the history of the transactional logger by a snapshot,
+// if the logger supports it and a snapshot interval is configured.
+func (a *ObjectService) startCompaction(ctx context.Context) {
+	logger, ok := a.tx.(compacter)
+	interval := a.cfg.TransactionLog.SnapshotInterval
+	if !ok || interval <= 0 {
 		return
 	}
-	if err := a.tx.Close(); err != nil {
-		log.Fatalf("error during close: %v", err)
+
+	a.tasks.Add(1)
+	go func() {
+		defer a.tasks.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := logger.Compact(); err != nil {
+					log.Printf("error during compaction: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// startSweeper periodically removes the expired objects from the port and logs their deletion,
+// if the port supports expiry and a sweep interval is configured. The port calls back before
+// the key can be written again, thus the deletion is never logged after a newer write.
+func (a *ObjectService) startSweeper(ctx context.Context) {
+	port, ok := a.port.(ports.ExpiringPort[string, string])
+	interval := a.cfg.Service.SweepInterval
+	if !ok || interval <= 0 {
+		return
 	}
+
+	a.tasks.Add(1)
+	go func() {
+		defer a.tasks.Done()
+		port.Sweep(ctx, interval, func(key string) {
+			if a.tx != nil {
+				a.tx.WriteDelete(key)
+			}
+		})
+	}()
 }
 
 // WithTransactionalLogger sets the transactional logger for the service and returns the updated service.
@@ -190,6 +514,14 @@ func (a *ObjectService) WithTransactionalLogger(logger consistency.Logger[string
 	return a
 }
 
+// WithKeyProvider enables envelope encryption: each value is encrypted with a fresh data key,
+// which is wrapped by the master key of the provider. The configured encryption keys are
+// only used to decrypt values which were written before.
+func (a *ObjectService) WithKeyProvider(provider ports.KeyProvider) *ObjectService {
+	a.keys = provider
+	return a
+}
+
 // WithPort sets the ObjectPort for the service and returns the updated service.
 func (a *ObjectService) WithPort(port ports.ObjectPort[string, string]) *ObjectService {
 	a.port = port
@@ -2,14 +2,23 @@ package services_test
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/txlog"
 	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
 	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
 	"github.com/andygeiss/cloud-native-utils/assert"
 	"github.com/andygeiss/cloud-native-utils/consistency"
+	"github.com/andygeiss/cloud-native-utils/security"
 )
 
 // ----------------------------------------------------------------------------
@@ -201,8 +210,16 @@ func TestObjectService_Logger_WritePut_And_Delete(t *testing.T) {
 // 5) Test failures from the port (to see retries, circuit breaker, etc.)
 // ----------------------------------------------------------------------------
 
+// retryingConfig returns a configuration, which retries the failed calls of the port three times.
+func retryingConfig() *config.Config {
+	policy := config.StabilityPolicy{BreakerThreshold: 3, RetryDelay: time.Millisecond, RetryMax: 3, Timeout: 5 * time.Second}
+	return &config.Config{Stability: config.Stability{Read: policy, Write: policy}}
+}
+
 type failingPort struct {
+	calls          int
 	failsRemaining int
+	failure        error // Error of the failed calls, which is a transient failure if nil.
 	store          map[string]string
 }
 
@@ -214,8 +231,12 @@ func newFailingPort(fails int) *failingPort {
 }
 
 func (f *failingPort) failIfNeeded() error {
+	f.calls++
 	if f.failsRemaining > 0 {
 		f.failsRemaining--
+		if f.failure != nil {
+			return f.failure
+		}
 		return errors.New("simulated port failure")
 	}
 	return nil
@@ -227,7 +248,7 @@ func (f *failingPort) Get(ctx context.Context, key string) (string, error) {
 	}
 	val, ok := f.store[key]
 	if !ok {
-		return "", errors.New("key does not exist")
+		return "", ports.ErrorKeyDoesNotExist
 	}
 	return val, nil
 }
@@ -248,11 +269,45 @@ func (f *failingPort) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+func (f *failingPort) CompareAndDelete(ctx context.Context, key, old string) error {
+	if err := f.failIfNeeded(); err != nil {
+		return err
+	}
+	if current, ok := f.store[key]; !ok || current != old {
+		return ports.ErrorValueChanged
+	}
+	delete(f.store, key)
+	return nil
+}
+
+func (f *failingPort) CompareAndSwap(ctx context.Context, key, old, value string) error {
+	if err := f.failIfNeeded(); err != nil {
+		return err
+	}
+	if current, ok := f.store[key]; ok != (old != "") || current != old {
+		return ports.ErrorValueChanged
+	}
+	f.store[key] = value
+	return nil
+}
+
+func (f *failingPort) Scan(ctx context.Context, r ports.Range[string]) (ports.Page[string], error) {
+	if err := f.failIfNeeded(); err != nil {
+		return ports.Page[string]{}, err
+	}
+	keys := make([]string, 0, len(f.store))
+	for key := range f.store {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return r.Paginate(keys), nil
+}
+
 // Test that retries eventually succeed if failures < retry limit.
 func TestObjectService_Put_WithFailingPort(t *testing.T) {
 	// This port fails the first 2 calls, then succeeds on the 3rd.
 	p := newFailingPort(2)
-	cfg := &config.Config{}
+	cfg := retryingConfig()
 	ctx := context.Background()
 
 	svc := services.NewObjectService(cfg).WithPort(p)
@@ -271,7 +326,7 @@ func TestObjectService_Put_WithFailingPort(t *testing.T) {
 func TestObjectService_Delete_WithFailingPort(t *testing.T) {
 	// This port fails the first time, then succeeds on the second attempt
 	p := newFailingPort(1)
-	cfg := &config.Config{}
+	cfg := retryingConfig()
 	ctx := context.Background()
 	// Put an item in the store directly
 	p.store["foo"] = "bar"
@@ -290,7 +345,7 @@ func TestObjectService_Delete_WithFailingPort(t *testing.T) {
 func TestObjectService_Get_WithFailingPort(t *testing.T) {
 	// This port fails all 3 attempts.
 	p := newFailingPort(4)
-	cfg := &config.Config{}
+	cfg := retryingConfig()
 	ctx := context.Background()
 	p.store["foo"] = "bar"
 
@@ -305,3 +360,838 @@ func TestObjectService_Get_WithFailingPort(t *testing.T) {
 	// Max retries reached, so we expect the error message from the port.
 	assert.That(t, "error must be correct", err.Error(), "simulated port failure")
 }
+
+// Test that the breaker keeps its state across the operations.
+func TestObjectService_Breaker_Is_Shared(t *testing.T) {
+	// This port fails the first 2 calls, which open the breaker.
+	p := newFailingPort(2)
+	p.store["foo"] = "bar"
+	policy := config.StabilityPolicy{BreakerThreshold: 2}
+	svc := services.NewObjectService(&config.Config{Stability: config.Stability{Read: policy}}).WithPort(p)
+	ctx := context.Background()
+
+	_, err := svc.Get(ctx, "foo")
+	assert.That(t, "first err must be the port failure", err.Error(), "simulated port failure")
+	_, err = svc.Get(ctx, "foo")
+	assert.That(t, "second err must be the port failure", err.Error(), "simulated port failure")
+
+	// The port would succeed now, but the open breaker rejects the call.
+	_, err = svc.Get(ctx, "foo")
+	assert.That(t, "err must not be nil", err == nil, false)
+	assert.That(t, "err must not be the port failure", err.Error() != "simulated port failure", true)
+
+	// The writes have their own breaker, which is still closed.
+	err = svc.Put(ctx, "bar", "baz")
+	assert.That(t, "err must be nil", err, nil)
+}
+
+// Test that a missing key is neither retried nor opens the breaker.
+func TestObjectService_Get_Missing_Key_Is_Not_Retried(t *testing.T) {
+	p := newFailingPort(0)
+	policy := config.StabilityPolicy{BreakerThreshold: 1, RetryDelay: 5 * time.Second, RetryMax: 3}
+	svc := services.NewObjectService(&config.Config{Stability: config.Stability{Read: policy}}).WithPort(p)
+	ctx := context.Background()
+	assert.That(t, "err must be nil", svc.Put(ctx, "foo", "bar"), nil)
+	p.calls = 0
+
+	start := time.Now()
+	_, err := svc.Get(ctx, "missing")
+	assert.That(t, "err must be ErrKeyNotFound", err, services.ErrKeyNotFound)
+	_, err = svc.Get(ctx, "missing")
+	assert.That(t, "err must still be ErrKeyNotFound", err, services.ErrKeyNotFound)
+	assert.That(t, "missing key must be returned immediately", time.Since(start) < time.Second, true)
+	assert.That(t, "port must be called once per get", p.calls, 2)
+
+	// The breaker is still closed.
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+	for _, call := range svc.Stats().Calls {
+		assert.That(t, call.Call+" must not be retried", call.Retries, uint64(0))
+	}
+}
+
+// Test that an error marked as permanent by the port is neither retried nor opens the breaker.
+func TestObjectService_Permanent_Error_Is_Not_Retried(t *testing.T) {
+	failure := errors.New("malformed value")
+	p := newFailingPort(0)
+	svc := services.NewObjectService(retryingConfig()).WithPort(p)
+	ctx := context.Background()
+	assert.That(t, "err must be nil", svc.Put(ctx, "foo", "bar"), nil)
+	p.calls, p.failsRemaining, p.failure = 0, 2, ports.Permanent(failure)
+
+	_, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be the failure", errors.Is(err, failure), true)
+	_, err = svc.Get(ctx, "foo")
+	assert.That(t, "err must still be the failure", errors.Is(err, failure), true)
+	assert.That(t, "port must be called once per get", p.calls, 2)
+
+	// The breaker is still closed.
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+	for _, call := range svc.Stats().Calls {
+		assert.That(t, call.Call+" breaker must not be tripped", call.BreakerTrips, uint64(0))
+	}
+}
+
+// ----------------------------------------------------------------------------
+// 6) Test that a restarted service recovers its data from the transaction log
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Restart_With_FileLogger(t *testing.T) {
+	cfg := &config.Config{}
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transactions.log")
+
+	// Start the first service, write some data and shut it down.
+	logger, err := txlog.NewFileLogger(path)
+	assert.That(t, "err must be nil", err, nil)
+	svc := services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	_ = svc.Put(ctx, "foo", "bar")
+	_ = svc.Put(ctx, "baz", "qux")
+	_ = svc.Delete(ctx, "baz")
+	svc.Teardown()
+
+	// Start a second service with an empty port on the same transaction log.
+	logger, err = txlog.NewFileLogger(path)
+	assert.That(t, "err must be nil", err, nil)
+	svc = services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	defer svc.Teardown()
+
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	_, err = svc.Get(ctx, "baz")
+	assert.That(t, "deleted key must not exist", err == nil, false)
+}
+
+func TestObjectService_Restart_With_Compaction(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.TransactionLog.SnapshotInterval = time.Millisecond
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transactions.log")
+
+	logger, _ := txlog.NewFileLogger(path)
+	svc := services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	_ = svc.Put(ctx, "foo", "bar")
+
+	// Wait for the background compaction to write the snapshot.
+	time.Sleep(50 * time.Millisecond)
+	svc.Teardown()
+
+	_, err := os.Stat(path + ".snapshot")
+	assert.That(t, "snapshot must exist", err, nil)
+
+	logger, _ = txlog.NewFileLogger(path)
+	svc = services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	defer svc.Teardown()
+
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+}
+
+// ----------------------------------------------------------------------------
+// 7) Test listing the keys of the port
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Scan(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(4))
+	for _, key := range []string{"b/2", "a/1", "b/1"} {
+		_ = svc.Put(ctx, key, "value")
+	}
+
+	page, err := svc.Scan(ctx, ports.Range[string]{Prefix: "b/", Limit: 1})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "keys must be the first page", page.Keys, []string{"b/1"})
+	assert.That(t, "cursor must be set", page.Next, "b/1")
+}
+
+// ----------------------------------------------------------------------------
+// 8) Test optimistic concurrency with versions
+// ----------------------------------------------------------------------------
+
+func TestObjectService_PutIf_Versions(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+
+	v1, err := svc.PutIf(ctx, "foo", "bar", services.Condition{Absent: true}, 0)
+	assert.That(t, "create must succeed", err, nil)
+
+	_, err = svc.PutIf(ctx, "foo", "baz", services.Condition{Absent: true}, 0)
+	assert.That(t, "second create must fail", err, services.ErrorVersionMismatch)
+
+	v2, err := svc.PutIf(ctx, "foo", "baz", services.Condition{Version: v1}, 0)
+	assert.That(t, "update must succeed", err, nil)
+	assert.That(t, "version must increase", v2 > v1, true)
+
+	_, err = svc.PutIf(ctx, "foo", "qux", services.Condition{Version: v1}, 0)
+	assert.That(t, "update of outdated version must fail", err, services.ErrorVersionMismatch)
+
+	obj, err := svc.GetObject(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "object must be the second write", obj, services.Object{Value: "baz", Version: v2})
+}
+
+func TestObjectService_DeleteIf_Versions(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	version, _ := svc.PutIf(ctx, "foo", "bar", services.Condition{}, 0)
+
+	err := svc.DeleteIf(ctx, "foo", services.Condition{Version: version + 1})
+	assert.That(t, "delete of other version must fail", err, services.ErrorVersionMismatch)
+
+	err = svc.DeleteIf(ctx, "foo", services.Condition{Version: version})
+	assert.That(t, "delete must succeed", err, nil)
+
+	err = svc.DeleteIf(ctx, "foo", services.Condition{Present: true})
+	assert.That(t, "delete of missing object must fail", err, services.ErrorVersionMismatch)
+}
+
+// legacyValue returns a value in the format which was written before versioning,
+// which only consists of the base64 encoded ciphertext without associated data.
+func legacyValue(value string, key [32]byte) string {
+	return base64.StdEncoding.EncodeToString(security.Encrypt([]byte(value), key))
+}
+
+func TestObjectService_GetObject_Legacy_Value(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(1)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = port.Put(ctx, "foo", legacyValue("bar", [32]byte{}))
+
+	obj, err := svc.GetObject(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "legacy object must have version zero", obj, services.Object{Value: "bar"})
+}
+
+// ----------------------------------------------------------------------------
+// 9) Test the expiry of objects
+// ----------------------------------------------------------------------------
+
+func TestObjectService_PutWithTTL_Expires(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+
+	err := svc.PutWithTTL(ctx, "foo", "bar", 20*time.Millisecond)
+	assert.That(t, "err must be nil", err, nil)
+
+	obj, err := svc.GetObject(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "object must expire", obj.ExpiresAt.IsZero(), false)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = svc.Get(ctx, "foo")
+	assert.That(t, "expired object must not exist", err, ports.ErrorKeyDoesNotExist)
+
+	_, err = svc.PutIf(ctx, "foo", "baz", services.Condition{Absent: true}, 0)
+	assert.That(t, "expired object must be absent", err, nil)
+}
+
+func TestObjectService_Sweeper_Logs_Deletes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Service.SweepInterval = time.Millisecond
+	ctx := context.Background()
+	logger := NewFakeLogger(nil, nil)
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(cfg).
+		WithPort(port).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+
+	_ = svc.PutWithTTL(ctx, "foo", "bar", time.Millisecond)
+	_ = svc.Put(ctx, "baz", "qux")
+
+	// Wait for the sweeper to remove the expired object.
+	time.Sleep(50 * time.Millisecond)
+	svc.Teardown()
+
+	_, err := port.Get(ctx, "foo")
+	assert.That(t, "expired key must be removed", err, ports.ErrorKeyDoesNotExist)
+	_, err = port.Get(ctx, "baz")
+	assert.That(t, "other key must remain", err, nil)
+	assert.That(t, "delete must be logged", logger.wroteDelete, []string{"foo"})
+}
+
+func TestObjectService_Setup_Restores_Expiry(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Service.SweepInterval = time.Millisecond
+	ctx := context.Background()
+
+	// Write an expiring object to a logger, which is replayed into a new port.
+	first := NewFakeLogger(nil, nil)
+	svc := services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(first)
+	_ = svc.PutWithTTL(ctx, "foo", "bar", time.Millisecond)
+
+	port := inmemory.NewObjectStore(2)
+	logger := NewFakeLogger([]consistency.Event[string, string]{
+		{EventType: consistency.EventTypePut, Key: "foo", Value: first.wrotePut["foo"]},
+	}, nil)
+	svc = services.NewObjectService(cfg).
+		WithPort(port).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+
+	time.Sleep(50 * time.Millisecond)
+	svc.Teardown()
+
+	_, err := port.Get(ctx, "foo")
+	assert.That(t, "replayed expired key must be removed", err, ports.ErrorKeyDoesNotExist)
+}
+
+// ----------------------------------------------------------------------------
+// 10) Test atomic batches
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Apply(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	version, _ := svc.PutIf(ctx, "a", "1", services.Condition{}, 0)
+
+	versions, err := svc.Apply(ctx, []services.Operation{
+		{Type: services.OperationDelete, Key: "a", Condition: services.Condition{Version: version}},
+		{Type: services.OperationPut, Key: "b", Value: "2", Condition: services.Condition{Absent: true}},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "deleted object must have no version", versions[0], uint64(0))
+
+	obj, _ := svc.GetObject(ctx, "b")
+	assert.That(t, "object must be written", obj, services.Object{Value: "2", Version: versions[1]})
+	_, err = svc.Get(ctx, "a")
+	assert.That(t, "object must be deleted", err, ports.ErrorKeyDoesNotExist)
+}
+
+func TestObjectService_Apply_Mismatch_Changes_Nothing(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	_ = svc.Put(ctx, "b", "1")
+
+	_, err := svc.Apply(ctx, []services.Operation{
+		{Type: services.OperationPut, Key: "a", Value: "1"},
+		{Type: services.OperationPut, Key: "b", Value: "2", Condition: services.Condition{Absent: true}},
+	})
+	assert.That(t, "err must be ErrorVersionMismatch", err, services.ErrorVersionMismatch)
+	_, err = svc.Get(ctx, "a")
+	assert.That(t, "first operation must not be applied", err, ports.ErrorKeyDoesNotExist)
+
+	_, err = svc.Apply(ctx, []services.Operation{
+		{Type: services.OperationPut, Key: "a", Value: "1"},
+		{Type: services.OperationDelete, Key: "a"},
+	})
+	assert.That(t, "err must be ErrorInvalidBatch", err, services.ErrorInvalidBatch)
+}
+
+func TestObjectService_Apply_Restart_With_FileLogger(t *testing.T) {
+	cfg := &config.Config{}
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transactions.log")
+
+	logger, _ := txlog.NewFileLogger(path)
+	svc := services.NewObjectService(cfg).
+		WithPort(inmemory.NewObjectStore(2)).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	_ = svc.Put(ctx, "a", "1")
+	_, err := svc.Apply(ctx, []services.Operation{
+		{Type: services.OperationDelete, Key: "a"},
+		{Type: services.OperationPut, Key: "b", Value: "2"},
+	})
+	assert.That(t, "err must be nil", err, nil)
+	svc.Teardown()
+
+	logger, _ = txlog.NewFileLogger(path)
+	port := inmemory.NewObjectStore(2)
+	svc = services.NewObjectService(cfg).
+		WithPort(port).
+		WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+	defer svc.Teardown()
+
+	page, _ := port.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "batch must be replayed", page.Keys, []string{"b"})
+	value, _ := svc.Get(ctx, "b")
+	assert.That(t, "value must be '2'", value, "2")
+}
+
+// ----------------------------------------------------------------------------
+// 11) Test the rotation of encryption keys
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Key_Rotation(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+
+	// Write a value with the first key.
+	first := &config.Config{}
+	first.Service.Key = [32]byte{1}
+	first.Service.KeyID = "k1"
+	svc := services.NewObjectService(first).WithPort(port)
+	version, _ := svc.PutIf(ctx, "foo", "bar", services.Condition{}, 0)
+
+	// Rotate to the second key and keep the first one for decryption.
+	second := &config.Config{}
+	second.Service.Key = [32]byte{2}
+	second.Service.KeyID = "k2"
+	second.Service.RetiredKeys = map[string][32]byte{"k1": first.Service.Key}
+	svc = services.NewObjectService(second).WithPort(port)
+
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be readable with the retired key", value, "bar")
+
+	count, err := svc.Reencrypt(ctx)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "one value must be migrated", count, 1)
+
+	count, _ = svc.Reencrypt(ctx)
+	assert.That(t, "migrated values must be skipped", count, 0)
+
+	// The retired key is not needed anymore.
+	second.Service.RetiredKeys = nil
+	obj, err := svc.GetObject(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "object must be unchanged", obj, services.Object{Value: "bar", Version: version})
+}
+
+func TestObjectService_Unknown_Key(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	cfg := &config.Config{}
+	cfg.Service.KeyID = "k1"
+	_ = services.NewObjectService(cfg).WithPort(port).Put(ctx, "foo", "bar")
+
+	cfg = &config.Config{}
+	cfg.Service.KeyID = "k2"
+	_, err := services.NewObjectService(cfg).WithPort(port).Get(ctx, "foo")
+	assert.That(t, "err must be ErrorUnknownKey", err, services.ErrorUnknownKey)
+}
+
+func TestObjectService_Setup_Reencrypts_In_Background(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	_ = services.NewObjectService(&config.Config{}).WithPort(port).Put(ctx, "foo", "bar")
+	legacy, _ := port.Get(ctx, "foo")
+
+	cfg := &config.Config{}
+	cfg.Service.Key = [32]byte{2}
+	cfg.Service.KeyID = "k2"
+	cfg.Service.RetiredKeys = map[string][32]byte{"": {}}
+	logger := NewFakeLogger(nil, nil)
+	svc := services.NewObjectService(cfg).WithPort(port).WithTransactionalLogger(logger)
+	assert.That(t, "setup err must be nil", svc.Setup(), nil)
+
+	// Wait for the background re-encryption.
+	time.Sleep(50 * time.Millisecond)
+	svc.Teardown()
+
+	raw, _ := port.Get(ctx, "foo")
+	assert.That(t, "value must be migrated", raw != legacy, true)
+	assert.That(t, "migration must be logged", logger.wrotePut["foo"], raw)
+}
+
+// ----------------------------------------------------------------------------
+// 12) Test corrupt values and the integrity scan
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Get_Corrupt_Value(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = port.Put(ctx, "foo", "not base64!")
+
+	_, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be ErrCorruptValue", errors.Is(err, services.ErrCorruptValue), true)
+}
+
+func TestObjectService_Get_Foreign_Key(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	cfg := &config.Config{}
+	cfg.Service.Key = [32]byte{1}
+	_ = services.NewObjectService(cfg).WithPort(port).Put(ctx, "foo", "bar")
+
+	_, err := services.NewObjectService(&config.Config{}).WithPort(port).Get(ctx, "foo")
+	assert.That(t, "err must be ErrDecryptionFailed", errors.Is(err, services.ErrDecryptionFailed), true)
+}
+
+func TestObjectService_Verify(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = svc.Put(ctx, "a", "ok")
+	_ = port.Put(ctx, "b", "not base64!")
+	_ = services.NewObjectService(&config.Config{Service: config.Service{Key: [32]byte{1}}}).WithPort(port).Put(ctx, "c", "foreign")
+
+	failures, err := svc.Verify(ctx)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "must have 2 failures", len(failures), 2)
+	assert.That(t, "first failure must be 'b'", failures[0].Key, "b")
+	assert.That(t, "'b' must be corrupt", errors.Is(failures[0].Err, services.ErrCorruptValue), true)
+	assert.That(t, "second failure must be 'c'", failures[1].Key, "c")
+	assert.That(t, "'c' must fail to decrypt", errors.Is(failures[1].Err, services.ErrDecryptionFailed), true)
+}
+
+// ----------------------------------------------------------------------------
+// 13) Test the binding of values to their objects
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Moved_Ciphertext_Fails(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = svc.Put(ctx, "alice", "secret")
+	_ = svc.Put(ctx, "mallory", "public")
+
+	// Move the ciphertext of one object to another one.
+	raw, _ := port.Get(ctx, "alice")
+	_ = port.Put(ctx, "mallory", raw)
+
+	_, err := svc.Get(ctx, "mallory")
+	assert.That(t, "moved value must fail", errors.Is(err, services.ErrDecryptionFailed), true)
+}
+
+func TestObjectService_Changed_Version_Fails(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = svc.Put(ctx, "foo", "bar")
+
+	// Change the version of the stored value.
+	raw, _ := port.Get(ctx, "foo")
+	var env map[string]any
+	_ = json.Unmarshal([]byte(raw), &env)
+	env["version"] = 1
+	data, _ := json.Marshal(env)
+	_ = port.Put(ctx, "foo", string(data))
+
+	_, err := svc.Get(ctx, "foo")
+	assert.That(t, "changed version must fail", errors.Is(err, services.ErrDecryptionFailed), true)
+}
+
+func TestObjectService_Changed_Expiry_Fails(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = svc.PutWithTTL(ctx, "foo", "bar", time.Hour)
+	raw, _ := port.Get(ctx, "foo")
+
+	// Extend and remove the expiry of the stored value.
+	for _, expiresAt := range []any{time.Now().Add(24 * time.Hour).UnixNano(), nil} {
+		var env map[string]any
+		_ = json.Unmarshal([]byte(raw), &env)
+		env["expires_at"] = expiresAt
+		data, _ := json.Marshal(env)
+		_ = port.Put(ctx, "foo", string(data))
+
+		_, err := svc.Get(ctx, "foo")
+		assert.That(t, "changed expiry must fail", errors.Is(err, services.ErrDecryptionFailed), true)
+	}
+}
+
+func TestObjectService_Added_Expiry_Fails(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	svc := services.NewObjectService(&config.Config{}).WithPort(port)
+	_ = svc.Put(ctx, "foo", "bar")
+	raw, _ := port.Get(ctx, "foo")
+
+	// Add an expiry to the stored value, also together with the format without a bound expiry.
+	for _, format := range []any{nil, 1} {
+		var env map[string]any
+		_ = json.Unmarshal([]byte(raw), &env)
+		env["expires_at"] = time.Now().Add(time.Minute).UnixNano()
+		if format != nil {
+			env["format"] = format
+		}
+		data, _ := json.Marshal(env)
+		_ = port.Put(ctx, "foo", string(data))
+
+		_, err := svc.Get(ctx, "foo")
+		assert.That(t, "added expiry must fail", errors.Is(err, services.ErrDecryptionFailed), true)
+	}
+}
+
+func TestObjectService_Legacy_Migration(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	_ = port.Put(ctx, "foo", legacyValue("bar", [32]byte{}))
+
+	cfg := &config.Config{}
+	svc := services.NewObjectService(cfg).WithPort(port)
+	count, err := svc.Reencrypt(ctx)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "legacy value must be migrated", count, 1)
+
+	// Legacy values are rejected after the migration.
+	cfg.Service.RejectLegacy = true
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	_ = port.Put(ctx, "baz", legacyValue("qux", [32]byte{}))
+	_, err = svc.Get(ctx, "baz")
+	assert.That(t, "legacy value must be rejected", errors.Is(err, services.ErrDecryptionFailed), true)
+}
+
+// ----------------------------------------------------------------------------
+// 14) Test the envelope encryption with a key provider
+// ----------------------------------------------------------------------------
+
+// fakeKeyProvider wraps the data keys by prefixing them with the ID of its only master key.
+type fakeKeyProvider struct {
+	err   error
+	keyID string
+	wraps int
+}
+
+func (a *fakeKeyProvider) KeyID() string {
+	return a.keyID
+}
+
+func (a *fakeKeyProvider) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	if keyID != a.keyID {
+		return nil, ports.ErrorUnknownMasterKey
+	}
+	if len(wrapped) < len(keyID) || string(wrapped[:len(keyID)]) != keyID {
+		return nil, ports.ErrorUnwrapFailed
+	}
+	return wrapped[len(keyID):], nil
+}
+
+func (a *fakeKeyProvider) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	a.wraps++
+	return append([]byte(a.keyID), dataKey...), nil
+}
+
+func TestObjectService_Envelope_Encryption(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	provider := &fakeKeyProvider{keyID: "m1"}
+	svc := services.NewObjectService(&config.Config{}).WithPort(port).WithKeyProvider(provider)
+	_ = svc.Put(ctx, "foo", "bar")
+	_ = svc.Put(ctx, "baz", "bar")
+
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+
+	// Every value has its own data key.
+	var foo, baz map[string]any
+	raw, _ := port.Get(ctx, "foo")
+	_ = json.Unmarshal([]byte(raw), &foo)
+	raw, _ = port.Get(ctx, "baz")
+	_ = json.Unmarshal([]byte(raw), &baz)
+	assert.That(t, "each value must wrap a data key", provider.wraps, 2)
+	assert.That(t, "key ID must be the master key", foo["key_id"], "m1")
+	assert.That(t, "data keys must differ", foo["data_key"] != baz["data_key"], true)
+}
+
+func TestObjectService_Envelope_Errors(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	provider := &fakeKeyProvider{keyID: "m1"}
+	_ = services.NewObjectService(&config.Config{}).WithPort(port).WithKeyProvider(provider).Put(ctx, "foo", "bar")
+
+	// A foreign master key fails to unwrap the data key.
+	_, err := services.NewObjectService(&config.Config{}).WithPort(port).WithKeyProvider(&fakeKeyProvider{keyID: "m2"}).Get(ctx, "foo")
+	assert.That(t, "err must be ErrDecryptionFailed", errors.Is(err, services.ErrDecryptionFailed), true)
+
+	// An unavailable provider is not a decryption failure.
+	provider.err = errors.New("kms unavailable")
+	_, err = services.NewObjectService(&config.Config{}).WithPort(port).WithKeyProvider(provider).Get(ctx, "foo")
+	assert.That(t, "err must be the provider error", errors.Is(err, services.ErrDecryptionFailed), false)
+
+	// Without a provider, the data key cannot be unwrapped.
+	_, err = services.NewObjectService(&config.Config{}).WithPort(port).Get(ctx, "foo")
+	assert.That(t, "err must be ErrorUnknownKey", err, services.ErrorUnknownKey)
+}
+
+func TestObjectService_Envelope_Migration(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	cfg := &config.Config{}
+	cfg.Service.Key = [32]byte{1}
+	_ = services.NewObjectService(cfg).WithPort(port).Put(ctx, "foo", "bar")
+
+	svc := services.NewObjectService(cfg).WithPort(port).WithKeyProvider(&fakeKeyProvider{keyID: "m1"})
+	count, err := svc.Reencrypt(ctx)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be migrated", count, 1)
+
+	count, _ = svc.Reencrypt(ctx)
+	assert.That(t, "migrated values must be skipped", count, 0)
+
+	// The local key is not needed anymore.
+	cfg.Service.Key = [32]byte{}
+	value, err := svc.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'bar'", value, "bar")
+}
+
+// ----------------------------------------------------------------------------
+// 15) Test the isolation of namespaces
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Namespaces_Are_Isolated(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	a, err := svc.Namespace("team-a")
+	assert.That(t, "err must be nil", err, nil)
+	b, _ := svc.Namespace("team-b")
+	_ = a.Put(ctx, "foo", "a")
+	_ = b.Put(ctx, "foo", "b")
+	_ = svc.Put(ctx, "bar", "default")
+
+	value, err := a.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be 'a'", value, "a")
+
+	value, _ = b.Get(ctx, "foo")
+	assert.That(t, "value must be 'b'", value, "b")
+
+	_, err = svc.Get(ctx, "foo")
+	assert.That(t, "err must be ErrKeyNotFound", err, services.ErrKeyNotFound)
+
+	page, _ := a.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "keys must only be those of team-a", page.Keys, []string{"foo"})
+
+	page, _ = svc.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "keys must only be those of the default namespace", page.Keys, []string{"bar"})
+}
+
+func TestObjectService_Namespace_Invalid(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+
+	_, err := svc.Namespace("Team A")
+	assert.That(t, "err must be ErrorInvalidNamespace", err, services.ErrorInvalidNamespace)
+
+	err = svc.Put(ctx, "team-a\x00foo", "bar")
+	assert.That(t, "err must be ErrorInvalidKey", err, services.ErrorInvalidKey)
+}
+
+func TestObjectService_Namespace_Keys_Are_Derived(t *testing.T) {
+	ctx := context.Background()
+	port := inmemory.NewObjectStore(2)
+	cfg := &config.Config{}
+	cfg.Service.Key = [32]byte{1}
+	svc := services.NewObjectService(cfg).WithPort(port)
+	a, _ := svc.Namespace("team-a")
+	b, _ := svc.Namespace("team-b")
+	_ = a.Put(ctx, "foo", "secret")
+	_ = b.Put(ctx, "foo", "public")
+
+	// Move the value of one tenant to another one.
+	raw, _ := port.Get(ctx, "team-a\x00foo")
+	_ = port.Put(ctx, "team-b\x00foo", raw)
+
+	_, err := b.Get(ctx, "foo")
+	assert.That(t, "moved value must fail", errors.Is(err, services.ErrDecryptionFailed), true)
+
+	// The value of a namespace is not encrypted with the key itself.
+	var env struct {
+		Ciphertext string `json:"ciphertext"`
+		Version    uint64 `json:"version"`
+	}
+	_ = json.Unmarshal([]byte(raw), &env)
+	ciphertext, _ := base64.StdEncoding.DecodeString(env.Ciphertext)
+	_, err = security.Decrypt(ciphertext, cfg.Service.Key)
+	assert.That(t, "value must not be decrypted with the key itself", err != nil, true)
+}
+
+func TestObjectService_Namespaces_Replay_And_Verify(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, _ := txlog.NewFileLogger(path)
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2)).WithTransactionalLogger(logger)
+	a, _ := svc.Namespace("team-a")
+	_ = a.Put(ctx, "foo", "bar")
+	svc.Teardown()
+
+	logger, _ = txlog.NewFileLogger(path)
+	port := inmemory.NewObjectStore(2)
+	svc = services.NewObjectService(&config.Config{}).WithPort(port).WithTransactionalLogger(logger)
+	assert.That(t, "replay err must be nil", svc.Replay(), nil)
+	defer svc.Teardown()
+
+	a, _ = svc.Namespace("team-a")
+	value, err := a.Get(ctx, "foo")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "value must be replayed", value, "bar")
+
+	_ = port.Put(ctx, "team-a\x00baz", "not base64!")
+	failures, _ := svc.Verify(ctx)
+	assert.That(t, "must have 1 failure", len(failures), 1)
+	assert.That(t, "failure must be 'baz'", failures[0].Key, "baz")
+	assert.That(t, "failure must be in team-a", failures[0].Namespace, "team-a")
+}
+
+// ----------------------------------------------------------------------------
+// 16) Test the metrics
+// ----------------------------------------------------------------------------
+
+func TestObjectService_Stats(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	logger, err := txlog.NewFileLogger(path)
+	assert.That(t, "err must be nil", err, nil)
+	defer logger.Close()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2)).WithTransactionalLogger(logger)
+
+	_ = svc.Put(ctx, "foo", "bar")
+	_, _ = svc.Get(ctx, "foo")
+	_, err = svc.Get(ctx, "missing")
+	assert.That(t, "err must be ErrKeyNotFound", err, services.ErrKeyNotFound)
+
+	stats := svc.Stats()
+	operations := map[string]services.OperationStats{}
+	for _, op := range stats.Operations {
+		operations[op.Operation] = op
+	}
+	assert.That(t, "get must be counted twice", operations["get"].Count, uint64(2))
+	assert.That(t, "put must be counted once", operations["put"].Count, uint64(1))
+	assert.That(t, "missing key must be counted as not found", operations["get"].Errors["not_found"], uint64(1))
+	assert.That(t, "all gets must be in the last bucket", operations["get"].Buckets[len(services.LatencyBuckets)-1], uint64(2))
+	assert.That(t, "shards must have 1 key", stats.ShardKeys[0]+stats.ShardKeys[1], 1)
+	assert.That(t, "transaction log must not be empty", stats.TransactionLogBytes > 0, true)
+}
+
+func TestObjectService_Stats_Retries_And_Breaker(t *testing.T) {
+	ctx := context.Background()
+	p := newFailingPort(6)
+	policy := config.StabilityPolicy{BreakerThreshold: 2, RetryDelay: time.Millisecond, RetryMax: 1}
+	svc := services.NewObjectService(&config.Config{Stability: config.Stability{Write: policy}}).WithPort(p)
+
+	// The first two deletes fail after one retry each, which opens the breaker.
+	_ = svc.Delete(ctx, "foo")
+	_ = svc.Delete(ctx, "foo")
+
+	stats := svc.Stats()
+	assert.That(t, "stats must have the delete calls", len(stats.Calls), 1)
+	assert.That(t, "each delete must be retried once", stats.Calls[0].Retries, uint64(2))
+	assert.That(t, "breaker must be open", stats.Calls[0].BreakerState, services.BreakerOpen)
+	assert.That(t, "breaker must be tripped once", stats.Calls[0].BreakerTrips, uint64(1))
+	assert.That(t, "errors must be counted by type", stats.Operations[0].Errors["port"], uint64(2))
+	assert.That(t, "port without shards must have no shard keys", len(stats.ShardKeys), 0)
+	assert.That(t, "size of missing transaction log must be unknown", stats.TransactionLogBytes, int64(-1))
+}
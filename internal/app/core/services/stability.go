@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-utils/service"
+	"github.com/andygeiss/cloud-native-utils/stability"
+)
+
+// portCalls are the calls of the port, which are protected by the stability patterns.
+// Each call is built once, so that its breaker keeps its state across the operations.
+// The reads use the read policy and the writes use the write policy of the configuration.
+type portCalls struct {
+	apply  service.Function[[]ports.Write[string, string], bool]
+	delete service.Function[string, struct{}]
+	get    service.Function[string, string]
+	load   service.Function[string, string]
+	scan   service.Function[ports.Range[string], ports.Page[string]]
+	swap   service.Function[swapRequest, bool]
+}
+
+// swapRequest replaces the raw value of the key, if it is still the old one.
+// An empty old value means that the key must not exist and an empty value deletes the key.
+type swapRequest struct {
+	Key   string
+	Old   string
+	Value string
+}
+
+// newPortCalls builds the calls of the port of the service.
+// The calls use the port of the service at the time they are called, thus the port may be set afterwards.
+func newPortCalls(a *ObjectService, policies config.Stability) portCalls {
+	return portCalls{
+		// A concurrent change is not an error, thus it is neither retried nor counted by the breaker.
+		apply: stable(a.metrics, "apply", policies.Write, func(ctx context.Context, writes []ports.Write[string, string]) (bool, error) {
+			port, ok := a.port.(ports.BatchPort[string, string])
+			if !ok {
+				return false, ports.Permanent(ErrorBatchNotSupported)
+			}
+			err := port.Apply(ctx, writes)
+			if errors.Is(err, ports.ErrorValueChanged) {
+				return false, nil
+			}
+			return err == nil, err
+		}),
+		delete: stable(a.metrics, "delete", policies.Write, func(ctx context.Context, key string) (struct{}, error) {
+			return struct{}{}, a.port.Delete(ctx, key)
+		}),
+		get: stable(a.metrics, "get", policies.Read, func(ctx context.Context, key string) (string, error) {
+			return a.port.Get(ctx, key)
+		}),
+		// A missing key is read as an empty value, which is the old value of a new object.
+		load: stable(a.metrics, "load", policies.Read, func(ctx context.Context, key string) (string, error) {
+			old, err := a.port.Get(ctx, key)
+			if errors.Is(err, ports.ErrorKeyDoesNotExist) {
+				return "", nil
+			}
+			return old, err
+		}),
+		scan: stable(a.metrics, "scan", policies.Read, func(ctx context.Context, r ports.Range[string]) (ports.Page[string], error) {
+			return a.port.Scan(ctx, r)
+		}),
+		// A concurrent change is not an error, thus it is neither retried nor counted by the breaker.
+		swap: stable(a.metrics, "swap", policies.Write, func(ctx context.Context, req swapRequest) (bool, error) {
+			var err error
+			switch {
+			case req.Old == "" && req.Value == "":
+				return true, nil
+			case req.Value == "":
+				err = a.port.CompareAndDelete(ctx, req.Key, req.Old)
+			default:
+				err = a.port.CompareAndSwap(ctx, req.Key, req.Old, req.Value)
+			}
+			if errors.Is(err, ports.ErrorValueChanged) {
+				return false, nil
+			}
+			return err == nil, err
+		}),
+	}
+}
+
+// outcome is the result of an attempt of a call together with its error, if the error is not transient.
+// Such an attempt passes the retry and the breaker as a success, thus it is neither retried nor counted as a failure.
+type outcome[OUT any] struct {
+	err error
+	out OUT
+}
+
+// stable applies the stability patterns of the policy to the function, whose calls are recorded by
+// the metrics under the given name. Each attempt of a call is counted, so that the retries are known,
+// and the breaker of the call is owned by the service, so that the metrics read its state.
+// Only transient errors of the port are retried and open the breaker, e.g. a missing key is returned at once.
+// The function is not debounced, because a debounced call would return the result of a call with another input.
+func stable[IN, OUT any](metrics *Metrics, name string, policy config.StabilityPolicy, fn service.Function[IN, OUT]) service.Function[IN, OUT] {
+	chain := func(ctx context.Context, in IN) (outcome[OUT], error) {
+		countAttempt(ctx)
+		out, err := fn(ctx, in)
+		if err != nil && !ports.IsTransient(err) {
+			return outcome[OUT]{err: err, out: out}, nil
+		}
+		return outcome[OUT]{out: out}, err
+	}
+	if policy.Timeout > 0 {
+		chain = stability.Timeout(chain, policy.Timeout)
+	}
+	if policy.RetryMax > 0 {
+		chain = stability.Retry(chain, policy.RetryMax, policy.RetryDelay)
+	}
+	var b *breaker
+	if policy.BreakerThreshold > 0 {
+		b = newBreaker(policy.BreakerThreshold)
+		chain = protect(b, chain)
+	}
+	return func(ctx context.Context, in IN) (OUT, error) {
+		attempts := new(atomic.Int64)
+		res, err := chain(context.WithValue(ctx, attemptsContextKey{}, attempts), in)
+		metrics.call(name, b, attempts.Load())
+		if err != nil {
+			return res.out, err
+		}
+		return res.out, res.err
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+)
+
+var (
+	// ErrorForbidden is returned when a token does not grant the role which is required for a key.
+	ErrorForbidden = errors.New("forbidden")
+	// ErrorInvalidGrant is returned when a grant has an unknown role or namespace.
+	ErrorInvalidGrant = errors.New("invalid grant")
+	// ErrorUnauthorized is returned when a token is missing, malformed or unknown.
+	ErrorUnauthorized = errors.New("unauthorized")
+)
+
+const (
+	// AnyNamespace is the namespace of a grant which applies to all namespaces.
+	AnyNamespace = "*"
+	// tokenNamespace is the reserved namespace which holds the tokens.
+	// Its name does not match the pattern of namespaces, thus it cannot be accessed by the API.
+	tokenNamespace = "_tokens"
+	// tokenPrefix is the prefix of every token, which makes leaked tokens easy to find.
+	tokenPrefix = "cns"
+)
+
+// Role is the level of access which is granted to the keys of a prefix.
+// Each role includes the roles before it.
+type Role string
+
+const (
+	// RoleRead allows to get and list the keys.
+	RoleRead Role = "read"
+	// RoleWrite allows to put and delete the keys.
+	RoleWrite Role = "write"
+	// RoleAdmin allows to issue, list and revoke tokens for the keys.
+	RoleAdmin Role = "admin"
+)
+
+// roles are the known roles in ascending order of their access.
+var roles = []Role{RoleRead, RoleWrite, RoleAdmin}
+
+// includes reports whether the role includes the other role.
+func (r Role) includes(other Role) bool {
+	return slices.Index(roles, r) >= slices.Index(roles, other)
+}
+
+// Grant gives a role for all keys with the prefix in the namespace.
+// An empty prefix applies to all keys and the namespace AnyNamespace to all namespaces.
+type Grant struct {
+	Namespace string `json:"namespace"`
+	Prefix    string `json:"prefix"`
+	Role      Role   `json:"role"`
+}
+
+// covers reports whether the grant includes every access of the other grant.
+func (g Grant) covers(other Grant) bool {
+	return (g.Namespace == AnyNamespace || g.Namespace == other.Namespace) &&
+		strings.HasPrefix(other.Prefix, g.Prefix) &&
+		g.Role.includes(other.Role)
+}
+
+// Token is an API token with its grants. The secret of the token is only returned once,
+// when the token is issued. Only its SHA-256 hash is stored, which is sufficient,
+// because the secret is random and thus cannot be guessed.
+type Token struct {
+	CreatedAt time.Time `json:"created_at"`
+	Grants    []Grant   `json:"grants"`
+	Hash      string    `json:"hash,omitempty"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+}
+
+// Allows reports whether the token grants the role for the key in the namespace.
+func (t Token) Allows(namespace, key string, role Role) bool {
+	return t.Covers(Grant{Namespace: namespace, Prefix: key, Role: role})
+}
+
+// Covers reports whether any grant of the token includes every access of the grant.
+func (t Token) Covers(grant Grant) bool {
+	for _, g := range t.Grants {
+		if g.covers(grant) {
+			return true
+		}
+	}
+	return false
+}
+
+// manages reports whether the token is allowed to manage the other token,
+// which requires the admin role for every grant of the other token.
+func (t Token) manages(other Token) bool {
+	for _, grant := range other.Grants {
+		if !t.Covers(Grant{Namespace: grant.Namespace, Prefix: grant.Prefix, Role: RoleAdmin}) {
+			return false
+		}
+	}
+	return true
+}
+
+// TokenService issues and authenticates the API tokens.
+// The tokens are stored in a reserved namespace of the ObjectService,
+// thus they are encrypted and logged like every other object.
+type TokenService struct {
+	acl    ACL        // Maps the logins of the UI sessions to their grants.
+	tokens *Namespace // Reserved namespace, which holds the tokens.
+}
+
+// NewTokenService creates a new instance of TokenService, which stores the tokens in the service.
+func NewTokenService(service *ObjectService) *TokenService {
+	return &TokenService{
+		tokens: &Namespace{name: tokenNamespace, svc: service},
+	}
+}
+
+// Authenticate returns the token of the secret or ErrorUnauthorized if the secret is not valid.
+func (a *TokenService) Authenticate(ctx context.Context, secret string) (token Token, err error) {
+	prefix, id, found := strings.Cut(secret, "_")
+	if !found || prefix != tokenPrefix {
+		return token, ErrorUnauthorized
+	}
+	id, _, found = strings.Cut(id, "_")
+	if !found {
+		return token, ErrorUnauthorized
+	}
+
+	token, err = a.get(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return token, ErrorUnauthorized
+	}
+	if err != nil {
+		return token, err
+	}
+
+	// Compare the hashes in constant time, so that the hash cannot be guessed by the timing.
+	if subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(token.Hash)) != 1 {
+		return Token{}, ErrorUnauthorized
+	}
+	return token, nil
+}
+
+// AuthenticateSession returns a token with the grants of the ACL for the GitHub login of a UI session,
+// so that the requests of the UI are authorized by the same rules as the requests with an API token.
+// It returns ErrorUnauthorized if the ACL does not grant any access to the login.
+func (a *TokenService) AuthenticateSession(login string) (token Token, err error) {
+	return a.acl.Token(login)
+}
+
+// Issue creates a token with the grants on behalf of the issuer, which must cover all of them.
+// It returns the token together with its secret, which cannot be retrieved afterwards.
+func (a *TokenService) Issue(ctx context.Context, issuer Token, name string, grants []Grant) (token Token, secret string, err error) {
+	if len(grants) == 0 {
+		return token, "", ErrorInvalidGrant
+	}
+	for _, grant := range grants {
+		if err := validateGrant(grant); err != nil {
+			return token, "", err
+		}
+	}
+	token = Token{CreatedAt: time.Now().UTC(), Grants: grants, Name: name}
+	if !issuer.manages(token) {
+		return Token{}, "", ErrorForbidden
+	}
+
+	// The ID is part of the secret, so that the token can be found without its hash.
+	id := make([]byte, 8)
+	random := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return Token{}, "", err
+	}
+	if _, err := rand.Read(random); err != nil {
+		return Token{}, "", err
+	}
+	token.ID = hex.EncodeToString(id)
+	secret = tokenPrefix + "_" + token.ID + "_" + base64.RawURLEncoding.EncodeToString(random)
+	token.Hash = hash(secret)
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return Token{}, "", err
+	}
+	if _, err := a.tokens.PutIf(ctx, token.ID, string(data), Condition{Absent: true}, 0); err != nil {
+		return Token{}, "", err
+	}
+
+	token.Hash = ""
+	return token, secret, nil
+}
+
+// IssueAdmin creates a token with the admin role for all keys in all namespaces.
+// It is used to get the first token, which issues all other tokens.
+func (a *TokenService) IssueAdmin(ctx context.Context, name string) (token Token, secret string, err error) {
+	root := Token{Grants: []Grant{{Namespace: AnyNamespace, Role: RoleAdmin}}}
+	return a.Issue(ctx, root, name, root.Grants)
+}
+
+// List returns the tokens which are managed by the issuer without their hashes.
+func (a *TokenService) List(ctx context.Context, issuer Token) (tokens []Token, err error) {
+	tokens = []Token{}
+	r := ports.Range[string]{Limit: scanPageSize}
+	for {
+		page, err := a.tokens.Scan(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range page.Keys {
+			token, err := a.get(ctx, id)
+			// The token has been revoked in the meantime.
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if issuer.manages(token) {
+				token.Hash = ""
+				tokens = append(tokens, token)
+			}
+		}
+		if page.Next == "" {
+			return tokens, nil
+		}
+		r.After = page.Next
+	}
+}
+
+// Revoke deletes the token with the ID, if the issuer manages it.
+func (a *TokenService) Revoke(ctx context.Context, issuer Token, id string) (err error) {
+	token, err := a.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !issuer.manages(token) {
+		return ErrorForbidden
+	}
+	return a.tokens.Delete(ctx, id)
+}
+
+// WithACL sets the ACL, which maps the logins of the UI sessions to their grants, and returns the updated service.
+func (a *TokenService) WithACL(acl ACL) *TokenService {
+	a.acl = acl
+	return a
+}
+
+// get returns the stored token with the ID.
+func (a *TokenService) get(ctx context.Context, id string) (token Token, err error) {
+	data, err := a.tokens.Get(ctx, id)
+	if err != nil {
+		return token, err
+	}
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return token, ErrCorruptValue
+	}
+	return token, nil
+}
+
+// hash returns the hex encoded SHA-256 hash of the secret.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateGrant checks that the role and the namespace of the grant are known.
+func validateGrant(grant Grant) error {
+	if !slices.Contains(roles, grant.Role) {
+		return ErrorInvalidGrant
+	}
+	if grant.Namespace != AnyNamespace && grant.Namespace != DefaultNamespace && !namespacePattern.MatchString(grant.Namespace) {
+		return ErrorInvalidGrant
+	}
+	return nil
+}
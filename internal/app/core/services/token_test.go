@@ -0,0 +1,93 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andygeiss/cloud-native-store/internal/app/adapters/outbound/inmemory"
+	"github.com/andygeiss/cloud-native-store/internal/app/config"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/ports"
+	"github.com/andygeiss/cloud-native-store/internal/app/core/services"
+	"github.com/andygeiss/cloud-native-utils/assert"
+)
+
+// ----------------------------------------------------------------------------
+// 1) Test the API tokens
+// ----------------------------------------------------------------------------
+
+func TestTokenService_Issue_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	tokens := services.NewTokenService(svc)
+	admin, secret, err := tokens.IssueAdmin(ctx, "admin")
+	assert.That(t, "err must be nil", err, nil)
+
+	token, err := tokens.Authenticate(ctx, secret)
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "token must be the admin token", token.ID, admin.ID)
+	assert.That(t, "token must allow everything", token.Allows("team-a", "foo", services.RoleAdmin), true)
+
+	_, err = tokens.Authenticate(ctx, secret+"x")
+	assert.That(t, "err must be ErrorUnauthorized", err, services.ErrorUnauthorized)
+
+	list, _ := tokens.List(ctx, token)
+	assert.That(t, "list must contain the token", len(list), 1)
+	assert.That(t, "list must not contain the hash", list[0].Hash, "")
+
+	// The tokens are stored in their own namespace.
+	page, _ := svc.Scan(ctx, ports.Range[string]{})
+	assert.That(t, "tokens must not be in the default namespace", page.Keys, []string{})
+}
+
+func TestTokenService_Grants(t *testing.T) {
+	ctx := context.Background()
+	svc := services.NewObjectService(&config.Config{}).WithPort(inmemory.NewObjectStore(2))
+	tokens := services.NewTokenService(svc)
+	admin, _, _ := tokens.IssueAdmin(ctx, "admin")
+
+	writer, _, err := tokens.Issue(ctx, admin, "writer", []services.Grant{{Namespace: "team-a", Prefix: "users/", Role: services.RoleWrite}})
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "writer must read the prefix", writer.Allows("team-a", "users/1", services.RoleRead), true)
+	assert.That(t, "writer must write the prefix", writer.Allows("team-a", "users/1", services.RoleWrite), true)
+	assert.That(t, "writer must not administrate the prefix", writer.Allows("team-a", "users/1", services.RoleAdmin), false)
+	assert.That(t, "writer must not read other prefixes", writer.Allows("team-a", "orders/1", services.RoleRead), false)
+	assert.That(t, "writer must not read other namespaces", writer.Allows("team-b", "users/1", services.RoleRead), false)
+
+	_, _, err = tokens.Issue(ctx, writer, "reader", []services.Grant{{Namespace: "team-a", Prefix: "users/", Role: services.RoleRead}})
+	assert.That(t, "err must be ErrorForbidden", err, services.ErrorForbidden)
+
+	_, _, err = tokens.Issue(ctx, admin, "invalid", []services.Grant{{Namespace: "Team A", Role: services.RoleRead}})
+	assert.That(t, "err must be ErrorInvalidGrant", err, services.ErrorInvalidGrant)
+}
+
+// ----------------------------------------------------------------------------
+// 2) Test the ACL of the UI sessions
+// ----------------------------------------------------------------------------
+
+func TestTokenService_Sessions(t *testing.T) {
+	acl, err := services.ParseACL([]byte(`{
+		"rules": [
+			{"subjects": ["alice"], "grants": [{"namespace": "team-a", "prefix": "", "role": "write"}]},
+			{"subjects": ["team:acme/ops"], "grants": [{"namespace": "*", "prefix": "logs/", "role": "read"}]}
+		],
+		"teams": {"acme/ops": ["alice", "bob"]}
+	}`))
+	assert.That(t, "err must be nil", err, nil)
+	tokens := services.NewTokenService(services.NewObjectService(&config.Config{})).WithACL(acl)
+
+	alice, err := tokens.AuthenticateSession("Alice")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "alice must have the grants of both rules", len(alice.Grants), 2)
+	assert.That(t, "alice must write team-a", alice.Allows("team-a", "x", services.RoleWrite), true)
+	assert.That(t, "alice must read the logs", alice.Allows("team-b", "logs/1", services.RoleRead), true)
+
+	bob, err := tokens.AuthenticateSession("bob")
+	assert.That(t, "err must be nil", err, nil)
+	assert.That(t, "bob must not write team-a", bob.Allows("team-a", "x", services.RoleWrite), false)
+
+	_, err = tokens.AuthenticateSession("eve")
+	assert.That(t, "err must be ErrorUnauthorized", err, services.ErrorUnauthorized)
+
+	_, err = services.ParseACL([]byte(`{"rules": [{"subjects": ["alice"], "grants": [{"role": "owner"}]}]}`))
+	assert.That(t, "err must be ErrorInvalidGrant", err, services.ErrorInvalidGrant)
+}